@@ -0,0 +1,264 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package ggifpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// GgifClient is the client API for Ggif service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type GgifClient interface {
+	Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*Result, error)
+	Encode(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (Ggif_EncodeClient, error)
+	Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*Result, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Ggif_WatchClient, error)
+}
+
+type ggifClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGgifClient(cc grpc.ClientConnInterface) GgifClient {
+	return &ggifClient{cc}
+}
+
+func (c *ggifClient) Convert(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (*Result, error) {
+	out := new(Result)
+	err := c.cc.Invoke(ctx, "/ggif.v1.Ggif/Convert", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ggifClient) Encode(ctx context.Context, in *ConvertRequest, opts ...grpc.CallOption) (Ggif_EncodeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Ggif_ServiceDesc.Streams[0], "/ggif.v1.Ggif/Encode", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ggifEncodeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Ggif_EncodeClient interface {
+	Recv() (*Chunk, error)
+	grpc.ClientStream
+}
+
+type ggifEncodeClient struct {
+	grpc.ClientStream
+}
+
+func (x *ggifEncodeClient) Recv() (*Chunk, error) {
+	m := new(Chunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ggifClient) Upload(ctx context.Context, in *UploadRequest, opts ...grpc.CallOption) (*Result, error) {
+	out := new(Result)
+	err := c.cc.Invoke(ctx, "/ggif.v1.Ggif/Upload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ggifClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Ggif_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Ggif_ServiceDesc.Streams[1], "/ggif.v1.Ggif/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ggifWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Ggif_WatchClient interface {
+	Recv() (*Result, error)
+	grpc.ClientStream
+}
+
+type ggifWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *ggifWatchClient) Recv() (*Result, error) {
+	m := new(Result)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GgifServer is the server API for Ggif service.
+// All implementations must embed UnimplementedGgifServer
+// for forward compatibility
+type GgifServer interface {
+	Convert(context.Context, *ConvertRequest) (*Result, error)
+	Encode(*ConvertRequest, Ggif_EncodeServer) error
+	Upload(context.Context, *UploadRequest) (*Result, error)
+	Watch(*WatchRequest, Ggif_WatchServer) error
+	mustEmbedUnimplementedGgifServer()
+}
+
+// UnimplementedGgifServer must be embedded to have forward compatible implementations.
+type UnimplementedGgifServer struct {
+}
+
+func (UnimplementedGgifServer) Convert(context.Context, *ConvertRequest) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Convert not implemented")
+}
+func (UnimplementedGgifServer) Encode(*ConvertRequest, Ggif_EncodeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Encode not implemented")
+}
+func (UnimplementedGgifServer) Upload(context.Context, *UploadRequest) (*Result, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Upload not implemented")
+}
+func (UnimplementedGgifServer) Watch(*WatchRequest, Ggif_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedGgifServer) mustEmbedUnimplementedGgifServer() {}
+
+// UnsafeGgifServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GgifServer will
+// result in compilation errors.
+type UnsafeGgifServer interface {
+	mustEmbedUnimplementedGgifServer()
+}
+
+func RegisterGgifServer(s grpc.ServiceRegistrar, srv GgifServer) {
+	s.RegisterService(&Ggif_ServiceDesc, srv)
+}
+
+func _Ggif_Convert_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConvertRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GgifServer).Convert(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ggif.v1.Ggif/Convert",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GgifServer).Convert(ctx, req.(*ConvertRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ggif_Encode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ConvertRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GgifServer).Encode(m, &ggifEncodeServer{stream})
+}
+
+type Ggif_EncodeServer interface {
+	Send(*Chunk) error
+	grpc.ServerStream
+}
+
+type ggifEncodeServer struct {
+	grpc.ServerStream
+}
+
+func (x *ggifEncodeServer) Send(m *Chunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Ggif_Upload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UploadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GgifServer).Upload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ggif.v1.Ggif/Upload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GgifServer).Upload(ctx, req.(*UploadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Ggif_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GgifServer).Watch(m, &ggifWatchServer{stream})
+}
+
+type Ggif_WatchServer interface {
+	Send(*Result) error
+	grpc.ServerStream
+}
+
+type ggifWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *ggifWatchServer) Send(m *Result) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Ggif_ServiceDesc is the grpc.ServiceDesc for Ggif service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Ggif_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ggif.v1.Ggif",
+	HandlerType: (*GgifServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Convert",
+			Handler:    _Ggif_Convert_Handler,
+		},
+		{
+			MethodName: "Upload",
+			Handler:    _Ggif_Upload_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Encode",
+			Handler:       _Ggif_Encode_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Ggif_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "ggif.proto",
+}
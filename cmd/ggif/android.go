@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// maxAndroidDuration is the longest screenrecord records for.
+const maxAndroidDuration = 180
+
+// adbArgs prefixes arg with the device to talk to, when --device names one;
+// otherwise adb picks the only device connected, or $ANDROID_SERIAL.
+func adbArgs(c *cli.Context, arg ...string) []string {
+	if serial := c.String("device"); serial != "" {
+		return append([]string{"-s", serial}, arg...)
+	}
+	return arg
+}
+
+// pidWriter takes the pid the adb shell prints first, and drops the rest
+// of what it prints.
+type pidWriter struct {
+	pids chan<- int
+	line []byte
+	read bool
+}
+
+func (w *pidWriter) Write(p []byte) (int, error) {
+	if !w.read {
+		w.line = append(w.line, p...)
+		if i := bytes.IndexByte(w.line, '\n'); i >= 0 {
+			w.read = true
+			if pid, err := strconv.Atoi(strings.TrimSpace(string(w.line[:i]))); err == nil && pid > 0 {
+				w.pids <- pid
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// captureAndroid records the screen of a device connected with adb until
+// enter is pressed or --duration is up, then pulls the recording into the
+// src folder.
+func captureAndroid(c *cli.Context) (string, error) {
+	duration, err := recordingDuration(c)
+	if err != nil {
+		return "", err
+	}
+	if duration > maxAndroidDuration {
+		return "", exitError(exitUsage, fmt.Errorf("screenrecord stops after %d seconds, --duration can't be longer", maxAndroidDuration))
+	}
+
+	outfn := recordingName(c, ".mp4")
+	remote := path.Join("/sdcard", filepath.Base(outfn))
+	// the shell says its pid and becomes screenrecord, so only this
+	// recording is stopped later
+	script := "echo $$; exec " + shellJoin([]string{"screenrecord", remote})
+	cmd := exec.Command("adb", adbArgs(c, "shell", script)...)
+	if c.Bool("dry-run") {
+		fmt.Fprintln(os.Stderr, shellJoin(cmd.Args))
+		runCmd(c.Context, c, "adb", adbArgs(c, "pull", remote, outfn)...)
+		return outfn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(outfn), 0755); err != nil {
+		return "", exitError(exitUsage, err)
+	}
+
+	var output bytes.Buffer
+	pids := make(chan int, 1)
+	cmd.Stdout = &pidWriter{pids: pids}
+	cmd.Stderr = &output
+	log.Debug(cmd.Args)
+	if err := cmd.Start(); err != nil {
+		if isMissingTool(err) {
+			return "", exitError(exitDependency, fmt.Errorf("adb is needed to record android devices: %w", err))
+		}
+		return "", exitError(exitDependency, fmt.Errorf("adb: %w", err))
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	// the device, not adb, has to be told to stop for the mp4 to be
+	// finished
+	stop := func(ctx context.Context) error {
+		select {
+		case pid := <-pids:
+			return runCmd(ctx, c, "adb", adbArgs(c, "shell", "kill", "-INT", strconv.Itoa(pid))...)
+		case <-time.After(time.Second):
+			return fmt.Errorf("screenrecord didn't start, there is nothing to stop")
+		}
+	}
+
+	ok, err := waitRecording(c, done, duration, "the android device")
+	switch {
+	case ok:
+		if err := stop(c.Context); err != nil {
+			log.Warning(err)
+		}
+		select {
+		case err = <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			err = <-done
+		}
+	case c.Context.Err() != nil:
+		// c.Context is done, these get a context of their own
+		ctx := context.Background()
+		stop(ctx)
+		cmd.Process.Kill()
+		<-done
+		runCmd(ctx, c, "adb", adbArgs(c, "shell", "rm", "-f", remote)...)
+		return "", err
+	}
+	// screenrecord exits by itself at its time limit, which is fine, or
+	// right away when there is no device
+	if err != nil && !ok {
+		if last := lastLine(output.String()); last != "" {
+			return "", exitError(exitDependency, fmt.Errorf("adb: %w: %s", err, last))
+		}
+		return "", exitError(exitDependency, fmt.Errorf("adb: %w", err))
+	}
+
+	err = runCmd(c.Context, c, "adb", adbArgs(c, "pull", remote, outfn)...)
+	if rmErr := runCmd(c.Context, c, "adb", adbArgs(c, "shell", "rm", "-f", remote)...); rmErr != nil {
+		log.Warning(rmErr)
+	}
+	if err != nil {
+		return "", exitError(exitDependency, err)
+	}
+	return outfn, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var cacheBucket = []byte("cache")
+
+// cacheEntry is a gif made before from the same video with the same
+// settings, with the urls it was uploaded to by destination.
+type cacheEntry struct {
+	Output string            `json:"output"`
+	SHA256 string            `json:"sha256"`
+	Frames int               `json:"frames"`
+	URLs   map[string]string `json:"urls,omitempty"`
+}
+
+// cacheKey hashes sourceSHA256, the sha256 of the video, together with the
+// settings and captions that change the gif, or returns "" when the cache
+// is off or the video couldn't be read.
+func cacheKey(ctx context.Context, c *cli.Context, sourceSHA256 string) string {
+	if c.Bool("no-cache") || c.Bool("dry-run") || sourceSHA256 == "" {
+		return ""
+	}
+
+	h := sha256.New()
+	io.WriteString(h, sourceSHA256)
+	fmt.Fprintf(h, "\x00quality=%d frames=%d width=%d start=%s end=%s",
+		c.Int("quality"), c.Int("frames"), c.Int("width"),
+		c.String("start"), c.String("end"))
+	if c.String("seek-mode") == "accurate" && c.String("start") != "" {
+		fmt.Fprint(h, " seek=accurate")
+	}
+	if c.Bool("shared-palette") && c.Int("segments") > 1 {
+		fmt.Fprint(h, " shared-palette")
+	}
+	if c.String("palette") != "" {
+		fmt.Fprintf(h, " palette=%s dither=%s", c.String("palette"), c.String("dither"))
+	}
+	for _, cp := range captionsOf(ctx) {
+		fmt.Fprintf(h, " caption=%q@%g-%g", cp.text, cp.from, cp.to)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// uploadDestination names where uploads currently go, so a cached url is
+// only reused for the same uploader and bucket.
+func uploadDestination(c *cli.Context) string {
+	name := c.String("uploader")
+	if name == "" {
+		name = "gcs"
+	}
+	return name + ":" + c.String("bucket")
+}
+
+// cachedURL returns the url entry was uploaded to at the current
+// destination, "" when there is none.
+func cachedURL(c *cli.Context, entry *cacheEntry) string {
+	if entry == nil {
+		return ""
+	}
+	return entry.URLs[uploadDestination(c)]
+}
+
+// lookupCache returns the entry for key when its gif is still there as it
+// was made.
+func lookupCache(key string) *cacheEntry {
+	if key == "" {
+		return nil
+	}
+	var entry *cacheEntry
+	err := withCache(func(b *bolt.Bucket) error {
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		entry = &cacheEntry{}
+		return json.Unmarshal(data, entry)
+	}, false)
+	if err != nil {
+		log.Warningf("Could not read the cache: %v", err)
+		return nil
+	}
+	if entry == nil {
+		return nil
+	}
+	if sum, err := fileSHA256(entry.Output); err != nil || sum != entry.SHA256 {
+		// moved, deleted or changed since
+		return nil
+	}
+	return entry
+}
+
+// storeCache records the gif of a conversion under key, adding url for the
+// current destination when it is not "".
+func storeCache(c *cli.Context, key string, res *result, url string) {
+	if key == "" {
+		return
+	}
+	err := withCache(func(b *bolt.Bucket) error {
+		entry := cacheEntry{}
+		if data := b.Get([]byte(key)); data != nil {
+			json.Unmarshal(data, &entry)
+		}
+		if entry.SHA256 != res.SHA256 {
+			entry = cacheEntry{}
+		}
+		entry.Output, entry.SHA256, entry.Frames = res.Output, res.SHA256, res.Frames
+		if abs, err := filepath.Abs(entry.Output); err == nil {
+			entry.Output = abs
+		}
+		if url != "" {
+			if entry.URLs == nil {
+				entry.URLs = make(map[string]string)
+			}
+			entry.URLs[uploadDestination(c)] = url
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	}, true)
+	if err != nil {
+		log.Warningf("Could not update the cache: %v", err)
+	}
+}
+
+// copyCached copies the gif of entry, and its thumbnail when res wants one,
+// to outfn, for a cache hit from outside the current --dist.
+func copyCached(entry *cacheEntry, res *result, outfn string) error {
+	if err := copyFile(entry.Output, outfn); err != nil {
+		return err
+	}
+	if res.Thumbnail == "" {
+		return nil
+	}
+	thumb := thumbnailName(entry.Output)
+	if !fileExists(thumb) {
+		res.Thumbnail = ""
+		return nil
+	}
+	return copyFile(thumb, res.Thumbnail)
+}
+
+// copyFile copies src to dst, which only appears once it is complete.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := partialName(dst)
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// withCache runs fn on the cache bucket of the history database.
+func withCache(fn func(b *bolt.Bucket) error, write bool) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	db, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !write {
+		return db.View(func(tx *bolt.Tx) error {
+			return fn(tx.Bucket(cacheBucket))
+		})
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(cacheBucket))
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// isCastFile reports whether fname is an asciinema recording: a .cast file
+// starting with its json header.
+func isCastFile(fname string) bool {
+	if !strings.EqualFold(filepath.Ext(fname), ".cast") {
+		return false
+	}
+	f, err := os.Open(fname)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head, _ := bufio.NewReader(f).Peek(64)
+	return bytes.HasPrefix(head, []byte("{")) && bytes.Contains(head, []byte(`"version"`))
+}
+
+// isRecording reports whether fname is something ggif converts: a video
+// or an asciinema recording.
+func isRecording(fname string) bool {
+	return isVideoFile(fname) || isCastFile(fname)
+}
+
+// keepIdleTime is the idle time limit agg is given to play a recording in
+// its own time.
+const keepIdleTime = "86400"
+
+// renderCast plays an asciinema recording into a gif in dir with agg.
+// ffmpeg reads gifs like any video, so the gif is then trimmed, scaled and
+// encoded by gifski the same way a screen recording is.  agg shortens
+// pauses, which would move captions and trim points away from the moments
+// of the recording they were timed on, so with those the recording is
+// played as it was.
+func renderCast(ctx context.Context, c *cli.Context, castFile string, dir string) (string, error) {
+	outfn := filepath.Join(dir, "cast.gif")
+	var args []string
+	if len(captionsOf(ctx)) > 0 || c.String("start") != "" || c.String("end") != "" {
+		args = append(args, "--idle-time-limit", keepIdleTime)
+	}
+	args = append(args, castFile, outfn)
+	if err := runCmd(ctx, c, "agg", args...); err != nil {
+		if isMissingTool(err) {
+			return "", exitError(exitDependency, fmt.Errorf("agg is needed to convert asciinema recordings, install it from https://github.com/asciinema/agg: %w", err))
+		}
+		return "", err
+	}
+	return outfn, nil
+}
+
+// captureTerminal records a terminal session with asciinema until the
+// shell it starts exits, and returns the recording.
+func captureTerminal(c *cli.Context) (string, error) {
+	outfn := recordingName(c, ".cast")
+	cmd := exec.Command("asciinema", "rec", "--quiet", outfn)
+	if c.Bool("dry-run") {
+		fmt.Fprintln(os.Stderr, shellJoin(cmd.Args))
+		return outfn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(outfn), 0755); err != nil {
+		return "", exitError(exitUsage, err)
+	}
+	if !c.Bool("quiet") {
+		fmt.Fprintf(os.Stderr, "Recording the terminal to %s, exit the shell to stop\n", outfn)
+	}
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log.Debug(cmd.Args)
+	if err := cmd.Run(); err != nil {
+		if isMissingTool(err) {
+			return "", exitError(exitDependency, fmt.Errorf("asciinema is needed to record the terminal: %w", err))
+		}
+		return "", exitError(exitDependency, fmt.Errorf("asciinema: %w", err))
+	}
+	if !fileExists(outfn) {
+		return "", exitError(exitDependency, fmt.Errorf("asciinema wrote no recording"))
+	}
+	return outfn, nil
+}
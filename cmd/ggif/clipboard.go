@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/urfave/cli/v2"
+)
+
+const clipboardPollInterval = time.Second
+
+// clipboardVideoFile turns the contents of the clipboard into a path to a
+// video file, returning "" when the clipboard holds anything else.  Plain
+// paths, file:// urls and bare file names (as copied from Finder) relative to
+// the src folder are understood.
+func clipboardVideoFile(text string, srcDir string) string {
+	text = strings.TrimSpace(text)
+	if i := strings.IndexAny(text, "\r\n"); i >= 0 {
+		text = text[:i]
+	}
+	if text == "" {
+		return ""
+	}
+
+	if strings.HasPrefix(text, "file://") {
+		u, err := url.Parse(text)
+		if err != nil {
+			return ""
+		}
+		text = u.Path
+	}
+
+	if strings.HasPrefix(text, "~") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		text = filepath.Join(home, text[1:])
+	}
+
+	if !filepath.IsAbs(text) {
+		text = filepath.Join(srcDir, text)
+	}
+
+	if !isRecording(text) {
+		return ""
+	}
+	return text
+}
+
+func watchClipboard(c *cli.Context) {
+	log.Debug("Watching clipboard")
+	serveMonitoring(c)
+
+	last, err := clipboard.ReadAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for {
+		time.Sleep(clipboardPollInterval)
+
+		text, err := clipboard.ReadAll()
+		if err != nil {
+			log.Debug("error:", err)
+			continue
+		}
+		if text == last {
+			continue
+		}
+		last = text
+
+		videoFile := clipboardVideoFile(text, c.String("src"))
+		if videoFile == "" {
+			continue
+		}
+		log.Debug("clipboard file:", videoFile)
+		res, err := process(c.Context, c, videoFile)
+		printError(err)
+		printResult(c, res)
+	}
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"gopkg.in/yaml.v2"
+)
+
+// configExts are the supported config file extensions, in order of
+// preference.
+var configExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// configDir returns the per-user config folder for ggif:
+// $XDG_CONFIG_HOME/ggif when set, otherwise the platform default
+// (~/.config, ~/Library/Application Support or %AppData%).
+func configDir() (string, error) {
+	// the spec says to ignore relative paths
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ggif"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ggif"), nil
+}
+
+// dataDir returns the per-user folder ggif keeps its state and history in:
+// $XDG_DATA_HOME/ggif when set, otherwise ~/.local/share/ggif on unix,
+// ~/Library/Application Support/ggif on macOS and %LocalAppData%\ggif on
+// Windows.
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ggif"), nil
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LocalAppData"); dir != "" {
+			return filepath.Join(dir, "ggif"), nil
+		}
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "ggif"), nil
+	case "darwin", "ios":
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, "ggif"), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "ggif"), nil
+}
+
+// findConfigFile looks for config.{json,yaml,yml,toml} in the config folder
+// and falls back to a .ggif.{json,yaml,yml,toml} dotfile in the home
+// directory.  --load, or $GGIF_CONFIG, overrides what is found here.
+func findConfigFile() string {
+	var candidates []string
+
+	// this runs before logging is set up, and having no config or home
+	// folder, as in containers, only means there is no file to find
+	if dir, err := configDir(); err == nil {
+		for _, ext := range configExts {
+			candidates = append(candidates, filepath.Join(dir, "config"+ext))
+		}
+	}
+	// $HOME rather than the user database, which containers running as an
+	// arbitrary uid have no entry in
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, ext := range configExts {
+			candidates = append(candidates, filepath.Join(home, ".ggif"+ext))
+		}
+	}
+
+	for _, fname := range candidates {
+		if fileExists(fname) {
+			return fname
+		}
+	}
+	return ""
+}
+
+// readConfigFile decodes a json, yaml or toml config file, picking the format
+// from the file extension.
+func readConfigFile(fname string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yaml", ".yml":
+		var raw map[interface{}]interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+		for k, v := range raw {
+			values[fmt.Sprint(k)] = stringKeys(v)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(data), &values); err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+	default:
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("%s: %v", fname, err)
+		}
+	}
+	return values, nil
+}
+
+// loadConfigFile reads and validates a config file, reporting every problem
+// found at once.
+func loadConfigFile(c *cli.Context, fname string) (map[string]interface{}, error) {
+	values, err := readConfigFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	errs := validateConfig(fname, values, appFlags(c))
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	return values, nil
+}
+
+// stringKeys converts the map[interface{}]interface{} values yaml produces
+// for nested objects into map[string]interface{} like json and toml do.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = stringKeys(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = stringKeys(item)
+		}
+	}
+	return value
+}
+
+// applyProfile overlays the settings of the named profile from the
+// "profiles" section onto the top level settings.
+func applyProfile(values map[string]interface{}, fname string, profile string) (map[string]interface{}, error) {
+	profiles, _ := values["profiles"].(map[string]interface{})
+	settings, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: profile %q not found", fname, profile)
+	}
+
+	merged := make(map[string]interface{}, len(values)+len(settings))
+	for k, v := range values {
+		merged[k] = v
+	}
+	for k, v := range settings {
+		merged[k] = v
+	}
+	return merged, nil
+}
+
+// findProjectConfigFile walks up from the working directory looking for a
+// .ggif.{json,yaml,yml,toml} file, like .editorconfig, stopping before the
+// home directory so the global dotfile is not picked up twice.
+func findProjectConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		log.Debug(err)
+		return ""
+	}
+	home, _ := os.UserHomeDir()
+
+	for dir != home {
+		for _, ext := range configExts {
+			fname := filepath.Join(dir, ".ggif"+ext)
+			if fileExists(fname) {
+				return fname
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
+
+// mergeConfig overlays the values of a project config file onto the global
+// ones, recording where each key came from.  Profiles are merged by name and
+// relative src/dist folders are resolved against the project folder.
+func mergeConfig(m *configMap, fname string, values map[string]interface{}) {
+	for key, value := range values {
+		switch key {
+		case "profiles":
+			profiles, _ := m.values[key].(map[string]interface{})
+			if profiles == nil {
+				profiles = make(map[string]interface{})
+			}
+			if override, ok := value.(map[string]interface{}); ok {
+				for name, settings := range override {
+					profiles[name] = settings
+					m.project["profiles."+name] = true
+				}
+				value = profiles
+			}
+		case "src", "dist":
+			if dir, ok := value.(string); ok && dir != "" && !filepath.IsAbs(dir) {
+				value = filepath.Join(filepath.Dir(fname), dir)
+			}
+		}
+		m.values[key] = value
+		m.origins[key] = fname
+		if key != "profiles" {
+			m.project[key] = true
+		}
+	}
+}
+
+// readConfigMap reads the config file named by flag, with any project
+// config file found above the working directory merged on top.  A missing
+// config file is not an error; the map is simply empty.
+func readConfigMap(c *cli.Context, flag string) (*configMap, error) {
+	fname := c.String(flag)
+	m := &configMap{
+		file:    fname,
+		values:  make(map[string]interface{}),
+		origins: make(map[string]string),
+		project: make(map[string]bool),
+	}
+
+	if fname != "" {
+		values, err := loadConfigFile(c, fname)
+		if err != nil {
+			return nil, err
+		}
+		m.values = values
+	}
+
+	if project := findProjectConfigFile(); project != "" && project != fname {
+		log.Debugf("Using project config %s", project)
+		values, err := loadConfigFile(c, project)
+		if err != nil {
+			return nil, err
+		}
+		mergeConfig(m, project, values)
+	}
+	return m, nil
+}
+
+// configSource returns an input source for the config files readConfigMap
+// finds, in which every flag they don't set keeps its default.  When a
+// profile is selected its settings win over the top level ones.
+func configSource(flag string) func(c *cli.Context) (altsrc.InputSourceContext, error) {
+	return func(c *cli.Context) (altsrc.InputSourceContext, error) {
+		m, err := readConfigMap(c, flag)
+		if err != nil {
+			return nil, err
+		}
+
+		if profile := c.String("profile"); profile != "" {
+			source := m.origin("profiles")
+			if source == "" {
+				return nil, fmt.Errorf("profile %q selected but no config file found", profile)
+			}
+			values, err := applyProfile(m.values, source, profile)
+			if err != nil {
+				return nil, err
+			}
+			m.values = values
+			for key := range m.profileKeys(profile) {
+				m.origins[key] = fmt.Sprintf("%s (profile %s)", m.origin("profiles"), profile)
+				m.project[key] = m.project["profiles."+profile]
+			}
+		}
+		delete(m.values, "profiles")
+
+		if err := checkSecretRefs(m, c.Bool("allow-project-secrets")); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+}
+
+// settingSources records where each setting's effective value came from, for
+// `ggif config show --effective`.
+var settingSources = make(map[string]string)
+
+// flagEnvVars returns the environment variables a flag can be set from.
+func flagEnvVars(f cli.Flag) []string {
+	switch f := f.(type) {
+	case *cli.StringFlag:
+		return f.EnvVars
+	case *cli.IntFlag:
+		return f.EnvVars
+	case *cli.BoolFlag:
+		return f.EnvVars
+	case *altsrc.StringFlag:
+		return f.EnvVars
+	case *altsrc.IntFlag:
+		return f.EnvVars
+	case *altsrc.BoolFlag:
+		return f.EnvVars
+	}
+	return nil
+}
+
+// applyConfig loads the config files and applies them to every flag that was
+// not given on the command line or through the environment.
+func applyConfig(c *cli.Context, flags []cli.Flag) error {
+	for _, name := range c.LocalFlagNames() {
+		settingSources[name] = "flag --" + name
+	}
+	for _, f := range flags {
+		name := f.Names()[0]
+		if _, ok := settingSources[name]; ok {
+			continue
+		}
+		for _, key := range flagEnvVars(f) {
+			if _, set := os.LookupEnv(key); set {
+				settingSources[name] = "env $" + key
+				break
+			}
+		}
+	}
+
+	source, err := configSource("load")(c)
+	if err != nil {
+		return err
+	}
+	m := source.(*configMap)
+	for key := range m.values {
+		if _, ok := settingSources[key]; !ok {
+			settingSources[key] = m.origin(key)
+		}
+	}
+
+	return altsrc.ApplyInputSourceValues(c, source, flags)
+}
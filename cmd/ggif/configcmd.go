@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+	"gopkg.in/yaml.v2"
+)
+
+// parseConfigValue converts a value typed on the command line into the kind
+// the config key holds.
+func parseConfigValue(kind string, key string, raw string) (interface{}, error) {
+	switch kind {
+	case "int":
+		i, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q should be a whole number, got %q", key, raw)
+		}
+		return i, nil
+	case "bool":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q should be true or false, got %q", key, raw)
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+// encodeConfigFile serializes config values in the format matching the file
+// extension.
+func encodeConfigFile(fname string, values map[string]interface{}) ([]byte, error) {
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".yaml", ".yml":
+		return yaml.Marshal(values)
+	case ".toml":
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(values); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		data, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to fname and renames
+// it into place, so readers never see a partially written file.
+func writeFileAtomic(fname string, data []byte, perm os.FileMode) error {
+	if fi, err := os.Stat(fname); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(fname), "."+filepath.Base(fname)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fname)
+}
+
+// configFileForWriting returns the config file in use, or the default
+// location in the config folder when there is none yet.
+func configFileForWriting(c *cli.Context) (string, error) {
+	if fname := c.String("load"); fname != "" {
+		return fname, nil
+	}
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// profileSection returns the map settings should be read from or written to:
+// the selected profile's table, or the top level when no profile is selected.
+func profileSection(c *cli.Context, values map[string]interface{}, create bool) map[string]interface{} {
+	profile := c.String("profile")
+	if profile == "" {
+		return values
+	}
+
+	profiles, ok := values["profiles"].(map[string]interface{})
+	if !ok {
+		if !create {
+			return nil
+		}
+		profiles = make(map[string]interface{})
+		values["profiles"] = profiles
+	}
+	settings, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		if !create {
+			return nil
+		}
+		settings = make(map[string]interface{})
+		profiles[profile] = settings
+	}
+	return settings
+}
+
+func configGet(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif config get <key>"))
+	}
+	key := c.Args().First()
+	if configKind(appFlags(c), key) == "" {
+		return fmt.Errorf("unknown key %q", key)
+	}
+
+	fname := c.String("load")
+	if fname == "" {
+		return fmt.Errorf("no config file found")
+	}
+	values, err := readConfigFile(fname)
+	if err != nil {
+		return err
+	}
+
+	section := profileSection(c, values, false)
+	if value, ok := section[key]; ok {
+		fmt.Println(value)
+	}
+	return nil
+}
+
+func configSet(c *cli.Context) error {
+	if c.Args().Len() != 2 {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif config set <key> <value>"))
+	}
+	key := c.Args().Get(0)
+	kind := configKind(appFlags(c), key)
+	if kind == "" {
+		return fmt.Errorf("unknown key %q, valid keys are: %s", key, strings.Join(configKeys(appFlags(c)), ", "))
+	}
+	value, err := parseConfigValue(kind, key, c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	if err := checkConfigValue(key, value); err != nil {
+		return fmt.Errorf("%q %v", key, err)
+	}
+
+	fname, err := configFileForWriting(c)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]interface{})
+	if fileExists(fname) {
+		values, err = readConfigFile(fname)
+		if err != nil {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+
+	profileSection(c, values, true)[key] = value
+
+	data, err := encodeConfigFile(fname, values)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(fname, data, 0644); err != nil {
+		return err
+	}
+	log.Debugf("Wrote %s", fname)
+	return nil
+}
+
+func configList(c *cli.Context) error {
+	fname := c.String("load")
+	if fname == "" {
+		return fmt.Errorf("no config file found")
+	}
+	values, err := readConfigFile(fname)
+	if err != nil {
+		return err
+	}
+
+	section := profileSection(c, values, false)
+	for _, key := range sortedKeys(section) {
+		if key == "profiles" {
+			continue
+		}
+		fmt.Printf("%s = %v\n", key, section[key])
+	}
+	return nil
+}
+
+func configShow(c *cli.Context) error {
+	if !c.Bool("effective") {
+		return configList(c)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, f := range appFlags(c) {
+		name := f.Names()[0]
+		if name == "help" {
+			continue
+		}
+
+		var value interface{}
+		switch f.(type) {
+		case *cli.IntFlag, *altsrc.IntFlag:
+			value = c.Int(name)
+		case *cli.BoolFlag, *altsrc.BoolFlag:
+			value = c.Bool(name)
+		default:
+			value = fmt.Sprintf("%q", c.String(name))
+		}
+		if sensitiveSettings[name] && c.String(name) != "" {
+			value = "<secret>"
+		}
+
+		source, ok := settingSources[name]
+		if !ok {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %v\t# %s\n", name, value, source)
+	}
+	return nil
+}
+
+func configValidate(c *cli.Context) error {
+	fname := c.String("load")
+	if fname == "" {
+		return fmt.Errorf("no config file found")
+	}
+	values, err := readConfigFile(fname)
+	if err != nil {
+		return exitError(exitConfig, err)
+	}
+
+	errs := validateConfig(fname, values, appFlags(c))
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		return exitError(exitConfig, fmt.Errorf("%s: %d problem(s) found", fname, len(errs)))
+	}
+	fmt.Printf("%s: ok\n", fname)
+	return nil
+}
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "read, edit and check the config file",
+	Description: `Examples:
+      ggif config set bucket my-bucket
+      ggif --profile work config set width 640
+      ggif config get bucket
+      ggif config show --effective
+      ggif config validate`,
+	Subcommands: []*cli.Command{
+		{
+			Name:      "get",
+			Usage:     "print the value of a key",
+			ArgsUsage: "<key>",
+			Action:    configGet,
+		},
+		{
+			Name:      "set",
+			Usage:     "set a key, creating the config file if needed",
+			ArgsUsage: "<key> <value>",
+			Action:    configSet,
+		},
+		{
+			Name:   "list",
+			Usage:  "print every key set in the config file",
+			Action: configList,
+		},
+		{
+			Name:   "show",
+			Usage:  "print the config file, or with --effective every setting and where it came from",
+			Action: configShow,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "effective",
+					Usage: "merge defaults, config files, environment and flags",
+				},
+			},
+		},
+		{
+			Name:   "validate",
+			Usage:  "check the config file for unknown keys and bad values",
+			Action: configValidate,
+		},
+	},
+}
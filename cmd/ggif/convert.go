@@ -0,0 +1,904 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/h2non/filetype"
+	"github.com/urfave/cli/v2"
+)
+
+// isVideoFile reports whether fname is a regular file whose header looks
+// like a video container.
+func isVideoFile(fname string) bool {
+	fi, err := os.Stat(fname)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	f, err := os.Open(fname)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	head := make([]byte, 262)
+	n, _ := io.ReadFull(f, head)
+	return filetype.IsVideo(head[:n])
+}
+
+// findNewestFile returns the most recently modified recording in dir, or ""
+// when there is none.  Only the header of each file is read.
+func findNewestFile(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Error(err.Error())
+	}
+	var newestFile string
+	var newestTime time.Time
+	for _, f := range files {
+		fname := filepath.Join(dir, f.Name())
+		if f.Mode()&os.ModeSymlink != 0 {
+			if fi, err := os.Stat(fname); err == nil {
+				f = fi
+			}
+		}
+		if f.IsDir() || !f.ModTime().After(newestTime) {
+			continue
+		}
+		if !isRecording(fname) {
+			continue
+		}
+		newestTime = f.ModTime()
+		newestFile = fname
+	}
+	return newestFile
+}
+
+// shellQuote quotes an argument for display so a printed command line can be
+// pasted into a shell as is.
+func shellQuote(arg string) string {
+	if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:%@+,", r))
+	}) < 0 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes a command line for display with shellQuote.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = shellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// runCmd runs a program, logging its output.  In a dry run the command
+// line is printed instead.
+func runCmd(ctx context.Context, c *cli.Context, name string, arg ...string) error {
+	return runCmdIn(ctx, c, "", name, arg...)
+}
+
+// runCmdIn is runCmd with dir as the working directory of the tool.
+func runCmdIn(ctx context.Context, c *cli.Context, dir string, name string, arg ...string) error {
+	cmd := exec.Command(name, arg...)
+	cmd.Dir = dir
+	return runCommand(ctx, c, cmd)
+}
+
+// runCommand is runCmd for a command that has more set up than its
+// arguments, like its stdin.
+func runCommand(ctx context.Context, c *cli.Context, cmd *exec.Cmd) error {
+	name, dir := cmd.Args[0], cmd.Dir
+	if c.Bool("nice") {
+		lowPriority(cmd)
+	}
+	if c.Bool("dry-run") {
+		if dir != "" {
+			fmt.Fprintf(os.Stderr, "cd %s && ", shellQuote(dir))
+		}
+		fmt.Fprintln(os.Stderr, shellJoin(cmd.Args))
+		return nil
+	}
+
+	log.Debug(cmd.Args)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err := runTool(ctx, cmd, toolTimeout(c, name == "gsutil"))
+	printOutput(output.Bytes())
+	logCommand(ctx, cmd.Args, output.Bytes(), err)
+	if err != nil {
+		if ctx.Err() != nil || isTimeout(err) {
+			// killed, the output is of no interest
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if last := lastLine(output.String()); last != "" {
+			return fmt.Errorf("%s: %w: %s", name, err, last)
+		}
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// tempDir is where frames and received videos are kept while they are
+// converted: the tmp-dir setting, or else the system's temp folder.
+func tempDir(c *cli.Context) string {
+	if dir := c.String("tmp-dir"); dir != "" {
+		return dir
+	}
+	return os.TempDir()
+}
+
+func createTmpDir(c *cli.Context) (string, error) {
+	if c.Bool("dry-run") {
+		return filepath.Join(tempDir(c), fmt.Sprintf("ggif-%d-framesXXXXXX", os.Getpid())), nil
+	}
+	return makeTempDir(tempDir(c), "frames")
+}
+
+// createGif encodes the frames in tmpDir into outfn.  The frames are
+// listed here and passed to gifski as arguments, with no shell in between
+// to misread odd file names.
+func createGif(ctx context.Context, c *cli.Context, tmpDir string, outfn string) error {
+	frames, err := filepath.Glob(filepath.Join(tmpDir, "*.png"))
+	if err != nil {
+		return err
+	}
+	if len(frames) == 0 {
+		if !c.Bool("dry-run") {
+			return fmt.Errorf("gifski: ffmpeg extracted no frames")
+		}
+		frames = []string{"*.png"}
+	}
+	// gifski runs in tmpDir so the frames can be passed by their bare
+	// names, which keeps long videos under the 32K command line limit of
+	// windows
+	for i, frame := range frames {
+		frames[i] = filepath.Base(frame)
+	}
+	outfn, err = filepath.Abs(outfn)
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-W", strconv.Itoa(c.Int("width")),
+		"-r", strconv.Itoa(c.Int("frames")),
+		"-Q", strconv.Itoa(c.Int("quality")),
+		"-o", outfn,
+	}
+	return runCmdIn(ctx, c, tmpDir, "gifski", append(args, frames...)...)
+}
+
+// process converts one video and uploads the gif.  The result is returned
+// even when a stage fails, with the error recorded in it; the error carries
+// the exit code of the stage.
+func process(ctx context.Context, c *cli.Context, videoFile string) (*result, error) {
+	return processWith(ctx, c, videoFile, nil)
+}
+
+// processWith is process calling onStage as each stage starts.
+func processWith(ctx context.Context, c *cli.Context, videoFile string, onStage func(stage string)) (*result, error) {
+	start := time.Now()
+	res := &result{
+		Source:    videoFile,
+		URLs:      []string{},
+		Durations: make(map[string]float64),
+		onStage:   onStage,
+	}
+	if l := jobLogOf(ctx); l != nil {
+		res.Job = l.id
+	}
+	fail := func(code int, err error) (*result, error) {
+		res.Error = err.Error()
+		res.Durations["total"] = time.Since(start).Seconds()
+		observeResult(res)
+		return res, exitError(code, err)
+	}
+
+	if videoFile == "" {
+		return fail(exitUsage, fmt.Errorf("no file specified and no file found in %s", c.String("src")))
+	}
+	fi, err := os.Stat(videoFile)
+	if err != nil {
+		return fail(exitUsage, err)
+	}
+	res.Input.Size = fi.Size()
+	if err := checkVideo(ctx, videoFile); err != nil {
+		return fail(exitUsage, err)
+	}
+	if info, err := probe(ctx, videoFile); err == nil {
+		res.Input.Width = info.Width
+		res.Input.Height = info.Height
+		res.Input.Duration = info.Duration
+	}
+	if !c.Bool("dry-run") {
+		res.SourceSHA256, err = fileSHA256(videoFile)
+		printError(err)
+	}
+
+	key := cacheKey(ctx, c, res.SourceSHA256)
+	cached := lookupCache(key)
+	distDir := c.String("dist")
+	if distDir == "" {
+		distDir = c.String("src")
+	}
+	var outfn, outputFile string
+	var stream *streamUpload
+	if cached != nil && insideDir(filepath.Dir(cached.Output), distDir) {
+		log.Infof("%s was converted with the same settings before, reusing %s", videoFile, cached.Output)
+		outfn, outputFile = cached.Output, filepath.Base(cached.Output)
+		res.Output = outfn
+		res.Frames = cached.Frames
+		res.Cached = true
+		if thumb := thumbnailName(outfn); wantThumbnail(c) && fileExists(thumb) {
+			res.Thumbnail = thumb
+		}
+	} else {
+		if err := prepareDist(c, distDir); err != nil {
+			return fail(exitUsage, err)
+		}
+		for _, dc := range append(routeContexts(c, "gif"), routeContexts(c, "thumbnail")...) {
+			if err := preflightUpload(ctx, dc); err != nil {
+				return fail(exitUpload, err)
+			}
+		}
+		policy := c.String("if-exists")
+		gifDests := routeContexts(c, "gif")
+		outputFile = outputName(func(name string) bool {
+			// keep the local and remote names the same
+			return policy == "rename" && (fileExists(filepath.Join(distDir, name)) ||
+				existsAtAny(ctx, gifDests, name))
+		})
+		outfn = filepath.Join(distDir, outputFile)
+		res.Output = outfn
+		if policy == "skip" && fileExists(outfn) {
+			log.Warningf("%s already exists, skipping %s", outfn, videoFile)
+			res.Skipped = true
+			observeResult(res)
+			return res, nil
+		}
+
+		if wantThumbnail(c) {
+			res.Thumbnail = thumbnailName(outfn)
+		}
+		if cached != nil {
+			// made for another --dist: copied, so it lands where this
+			// one's gifs go, named as they are
+			log.Infof("%s was converted with the same settings before, copying %s", videoFile, cached.Output)
+			if err := copyCached(cached, res, outfn); err != nil {
+				return fail(exitFailure, err)
+			}
+			res.Frames = cached.Frames
+			res.Cached = true
+		} else {
+			if canStreamUpload(c, outfn) {
+				stream = startStreamUpload(withProvenance(ctx, res), c, outfn, outputFile)
+			}
+			if err := renderGif(ctx, c, res, videoFile, outfn); err != nil {
+				if stream != nil {
+					stream.abort()
+				}
+				return fail(exitConvert, err)
+			}
+		}
+	}
+	if !c.Bool("dry-run") {
+		// nothing broken gets uploaded or shared
+		if err := checkGif(c, outfn); err != nil {
+			if stream != nil {
+				stream.abort()
+			}
+			return fail(exitConvert, err)
+		}
+	}
+	if fi, err := os.Stat(outfn); err == nil {
+		res.Size = fi.Size()
+		res.SHA256, err = fileSHA256(outfn)
+		printError(err)
+		res.Width, res.Height = gifSize(outfn)
+		warnGifSize(c, res)
+	}
+	if cached == nil || cached.Output != outfn {
+		// before uploading, so a failed upload can be retried without
+		// encoding again; a copy is found in its own --dist from now on
+		storeCache(c, key, res, "")
+	}
+
+	if c.Bool("no-upload") {
+		if !c.Bool("json") {
+			fmt.Println(outfn)
+		}
+	} else if stream != nil {
+		// only the part of the upload that outlasted encoding
+		err = res.timeStage("upload", func() error {
+			url, err := stream.finish()
+			if err == nil {
+				err = waitForURL(ctx, c, url)
+			}
+			if err != nil {
+				return err
+			}
+			uploadBytesTotal.Add(float64(res.Size))
+			res.URLs = append(res.URLs, url)
+			storeCache(c, key, res, url)
+			announceURL(c, url)
+			return nil
+		})
+		if err != nil {
+			return fail(exitUpload, err)
+		}
+	} else {
+		err = res.timeStage("upload", func() error {
+			for _, dc := range routeContexts(c, "gif") {
+				if url := cachedURL(dc, cached); url != "" {
+					log.Infof("%s was uploaded before to %s", outfn, url)
+					res.URLs = append(res.URLs, url)
+					announceURL(dc, url)
+					continue
+				}
+				url, err := uploadFile(withProvenance(ctx, res), dc, outfn, outputFile)
+				if err != nil {
+					return err
+				}
+				if url != "" {
+					res.URLs = append(res.URLs, url)
+					storeCache(dc, key, res, url)
+				}
+			}
+			return uploadThumbnail(ctx, c, res, outputFile)
+		})
+		if err != nil {
+			return fail(exitUpload, err)
+		}
+	}
+	if !c.Bool("no-upload") && (c.Bool("include-source") || routed(c, "source")) {
+		err = res.timeStage("source", func() error {
+			return uploadSource(withProvenance(ctx, res), c, res, outputFile)
+		})
+		if err != nil {
+			return fail(exitUpload, err)
+		}
+	}
+	if err := shareResult(c, res); err != nil {
+		return fail(exitUpload, err)
+	}
+	openResult(c, res)
+
+	res.Durations["total"] = time.Since(start).Seconds()
+	observeResult(res)
+	recordHistory(c, res)
+	return res, nil
+}
+
+// wantThumbnail reports whether a thumbnail is asked for, with --thumbnail
+// or by routing thumbnails somewhere.
+func wantThumbnail(c *cli.Context) bool {
+	return c.Bool("thumbnail") || routed(c, "thumbnail")
+}
+
+// thumbnailName is where --thumbnail writes the poster image of the gif
+// outfn.
+func thumbnailName(outfn string) string {
+	return strings.TrimSuffix(outfn, filepath.Ext(outfn)) + ".png"
+}
+
+// checkGif fails unless outfn is a non-empty gif within --max-gif-size.
+func checkGif(c *cli.Context, outfn string) error {
+	fi, err := os.Stat(outfn)
+	if err != nil {
+		return fmt.Errorf("no gif was written: %w", err)
+	}
+	if fi.Size() == 0 {
+		return fmt.Errorf("%s is empty", outfn)
+	}
+	if w, h := gifSize(outfn); w == 0 || h == 0 {
+		return fmt.Errorf("%s is not a readable gif", outfn)
+	}
+	max, _ := parseSize(c.String("max-gif-size"))
+	if max > 0 && uint64(fi.Size()) > max {
+		return fmt.Errorf("%s is %s, more than --max-gif-size %s, lower --width, --frames or --quality",
+			outfn, humanSize(fi.Size()), c.String("max-gif-size"))
+	}
+	return nil
+}
+
+// prepareDist creates the dist folder when it is missing and makes sure
+// gifs can be written to it, before minutes go into a conversion.
+func prepareDist(c *cli.Context, distDir string) error {
+	if c.Bool("dry-run") {
+		return nil
+	}
+	if err := os.MkdirAll(distDir, 0755); err != nil {
+		return fmt.Errorf("can't create the dist folder: %v", err)
+	}
+	f, err := ioutil.TempFile(distDir, ".ggif-check")
+	if err != nil {
+		return fmt.Errorf("can't write gifs to the dist folder %s: %v", distDir, err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// partialName is where the gif for outfn is written until it is complete,
+// hidden and under a name nothing takes for a gif.
+func partialName(outfn string) string {
+	return filepath.Join(filepath.Dir(outfn), "."+filepath.Base(outfn)+".part")
+}
+
+// renderGif runs the frames, gif and filter stages, writing the gif of
+// videoFile to outfn.  The gif and its thumbnail only appear under their
+// names once they are complete, so whoever watches the folder never sees
+// half of them.  The error carries the exit code of the stage.
+func renderGif(ctx context.Context, c *cli.Context, res *result, videoFile string, outfn string) error {
+	partfn := partialName(outfn)
+	thumbfn := res.Thumbnail
+	if thumbfn != "" {
+		res.Thumbnail = partialName(thumbfn)
+	}
+	err := renderPartial(ctx, c, res, videoFile, partfn)
+	partThumb := res.Thumbnail
+	res.Thumbnail = thumbfn
+	if err != nil {
+		os.Remove(partfn)
+		if partThumb != "" {
+			os.Remove(partThumb)
+		}
+		return err
+	}
+	if c.Bool("dry-run") {
+		return nil
+	}
+	if partThumb != "" && fileExists(partThumb) {
+		if err := os.Rename(partThumb, thumbfn); err != nil {
+			os.Remove(partfn)
+			os.Remove(partThumb)
+			return exitError(exitFailure, err)
+		}
+	}
+	if err := os.Rename(partfn, outfn); err != nil {
+		os.Remove(partfn)
+		return exitError(exitFailure, err)
+	}
+	return nil
+}
+
+func renderPartial(ctx context.Context, c *cli.Context, res *result, videoFile string, outfn string) error {
+	tmpDir, err := createTmpDir(c)
+	if err != nil {
+		return exitError(exitFailure, err)
+	}
+	if !c.Bool("dry-run") {
+		defer os.RemoveAll(tmpDir)
+	}
+	if isCastFile(videoFile) {
+		err = res.timeStage("cast", func() error {
+			videoFile, err = renderCast(ctx, c, videoFile, tmpDir)
+			return err
+		})
+		if err != nil {
+			return exitError(exitConvert, err)
+		}
+	}
+
+	inputArgs, err := ffmpegInputArgs(c, videoFile)
+	if err != nil {
+		return exitError(exitUsage, err)
+	}
+	if err := checkLimits(ctx, c, videoFile, tmpDir); err != nil {
+		return exitError(exitFailure, err)
+	}
+
+	if n, start, length := segmentCount(ctx, c, videoFile); n > 1 {
+		err = renderSegments(ctx, c, res, videoFile, tmpDir, outfn, n, start, length)
+	} else {
+		err = renderWhole(ctx, c, res, inputArgs, tmpDir, outfn)
+	}
+	if err != nil {
+		return err
+	}
+	if c.String("palette") != "" {
+		err = res.timeStage("palette", func() error {
+			return applyPalette(ctx, c, outfn)
+		})
+		if err != nil {
+			return exitError(exitConvert, err)
+		}
+	}
+	if c.Bool("strip-metadata") && !c.Bool("dry-run") {
+		for _, fname := range []string{outfn, res.Thumbnail} {
+			if fname == "" {
+				continue
+			}
+			if err := stripMetadata(fname); err != nil {
+				return exitError(exitConvert, err)
+			}
+		}
+	}
+	return nil
+}
+
+// renderWhole extracts the frames of the clip into tmpDir and encodes them
+// into outfn in one go.
+func renderWhole(ctx context.Context, c *cli.Context, res *result, inputArgs []string, tmpDir string, outfn string) error {
+	// checked when inputArgs were made
+	start, _, _ := trimRange(c)
+	args := frameArgs(ctx, c, res, inputArgs, start, 1, tmpDir, true)
+	err := res.timeStage("frames", func() error {
+		return runCmd(ctx, c, "ffmpeg", args...)
+	})
+	if err != nil {
+		return exitError(exitConvert, err)
+	}
+	if frames, err := filepath.Glob(filepath.Join(tmpDir, "*.png")); err == nil {
+		res.Frames = len(frames)
+	}
+
+	err = res.timeStage("gif", func() error {
+		return createGif(ctx, c, tmpDir, outfn)
+	})
+	if err != nil {
+		return exitError(exitConvert, err)
+	}
+	return nil
+}
+
+// frameArgs are the ffmpeg arguments that extract the frames of the clip
+// inputArgs read, starting at start, as pngs into dir: capped to the share
+// of --max-frames of one of n segments, with the captions, and writing the
+// thumbnail too if thumbnail is set.
+func frameArgs(ctx context.Context, c *cli.Context, res *result, inputArgs []string, start float64, n int, dir string, thumbnail bool) []string {
+	args := append([]string{}, inputArgs...)
+	args = append(args, frameCapArgs(c, n)...)
+	args = append(args, captionArgs(ctx, c, start)...)
+	args = append(args, filepath.Join(dir, "frame%04d.png"))
+	if thumbnail {
+		args = append(args, thumbnailArgs(c, res)...)
+	}
+	return args
+}
+
+// thumbnailSample is how many frames a second, and thumbnailBatch how many
+// of those in all, the thumbnail is picked from: the first 30 seconds.
+const (
+	thumbnailSample = 2
+	thumbnailBatch  = 60
+)
+
+// thumbnailArgs are the ffmpeg output arguments that write a frame of the
+// clip, scaled like the gif, to res.Thumbnail in the same pass that
+// extracts the frames, so the video is decoded only once.  The frame is the
+// one ffmpeg's thumbnail filter finds closest to the average of the start
+// of the clip rather than the first, which for a screen recording is
+// usually the desktop before anything happened.
+func thumbnailArgs(c *cli.Context, res *result) []string {
+	if res.Thumbnail == "" {
+		return nil
+	}
+	return []string{
+		"-y", "-frames:v", "1",
+		// scaled first so the batch the filter holds stays small
+		"-vf", fmt.Sprintf("fps=%d,scale='min(%d,iw)':-2,thumbnail=%d", thumbnailSample, c.Int("width"), thumbnailBatch),
+		// written under a partial name, which says nothing of the format
+		"-f", "image2", "-c:v", "png",
+		res.Thumbnail,
+	}
+}
+
+// outputNames holds the names handed out lately with the second they were
+// made in.  Names are made from the time, so they can't come up again once
+// the conversions that asked for them have long picked theirs, and are
+// forgotten after a minute.
+var (
+	outputNamesMu sync.Mutex
+	outputNames   = make(map[string]int64)
+)
+
+// reserveOutputName claims the first name of the second base no other
+// conversion in this run has claimed, starting at the suffix after.
+func reserveOutputName(base int64, after int) (string, int) {
+	outputNamesMu.Lock()
+	defer outputNamesMu.Unlock()
+
+	for name, sec := range outputNames {
+		if sec < base-60 {
+			delete(outputNames, name)
+		}
+	}
+	for i := after; ; i++ {
+		name := strconv.FormatInt(base, 10) + ".gif"
+		if i > 0 {
+			name = fmt.Sprintf("%d-%d.gif", base, i)
+		}
+		if _, ok := outputNames[name]; !ok {
+			outputNames[name] = base
+			return name, i
+		}
+	}
+}
+
+// outputName returns a unix timestamp file name for a new gif that no other
+// conversion in this run has used and taken does not reject, adding a -1,
+// -2, ... suffix when several files are converted within the same second.
+// taken can ask the bucket, so it runs without holding the lock.
+func outputName(taken func(name string) bool) string {
+	base := time.Now().Unix()
+	name, i := reserveOutputName(base, 0)
+	for taken(name) {
+		name, i = reserveOutputName(base, i+1)
+	}
+	return name
+}
+
+// expandArgs expands glob patterns among the arguments, which shells on
+// Windows leave alone, keeping plain paths as they are.
+func expandArgs(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern %q: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", arg)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// batchProgress counts the files of a batch as they finish, on one line
+// redrawn in place when stderr is a terminal.
+type batchProgress struct {
+	mu     sync.Mutex
+	total  int
+	done   int
+	failed int
+	live   bool
+}
+
+func (p *batchProgress) finished(file string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if err != nil {
+		p.failed++
+		if p.live {
+			// clear the progress line for the error
+			fmt.Fprint(os.Stderr, "\r\033[K")
+		}
+		log.Errorf("%s: %v", file, err)
+	}
+	if !p.live {
+		log.Infof("[%d/%d] %s", p.done, p.total, file)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\rconverted %d/%d", p.done, p.total)
+	if p.failed > 0 {
+		fmt.Fprintf(os.Stderr, ", %d failed", p.failed)
+	}
+	if p.done == p.total {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// processBatch converts several files at once through a pool of --jobs
+// workers, returning the results in the order the files were given, and the
+// error of the first file that failed.
+func processBatch(c *cli.Context, files []string) ([]*result, error) {
+	results := make([]*result, len(files))
+	errs := make([]error, len(files))
+	jobs := make(chan int)
+	progress := &batchProgress{
+		total: len(files),
+		live:  !c.Bool("quiet") && isTerminal(os.Stderr),
+	}
+
+	workers := c.Int("jobs")
+	if workers > len(files) {
+		workers = len(files)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = process(c.Context, c, files[i])
+				progress.finished(files[i], errs[i])
+			}
+		}()
+	}
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	var first error
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, exitError(exitCode(first), fmt.Errorf("%d of %d conversions failed", failed, len(files)))
+	}
+	return results, nil
+}
+
+// printSummary prints a table with one line per converted file.
+func printSummary(results []*result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "SOURCE\tGIF\tSIZE\tTIME\tURL")
+	for _, res := range results {
+		status := filepath.Base(res.Output)
+		if res.Error != "" {
+			status = "failed"
+		}
+		url := "-"
+		if len(res.URLs) > 0 {
+			url = res.URLs[0]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.1f KB\t%.1fs\t%s\n",
+			res.Source, status, float64(res.Size)/1024, res.Durations["total"], url)
+	}
+}
+
+// readFileList reads newline separated paths, skipping blank lines.
+func readFileList(r io.Reader) ([]string, error) {
+	var files []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// inputFiles collects the files to convert from the arguments, expanding
+// globs, and from stdin when --stdin or a "-" argument is given.
+func inputFiles(c *cli.Context) ([]string, error) {
+	var args []string
+	fromStdin := c.Bool("stdin")
+	for _, arg := range c.Args().Slice() {
+		if arg == "-" {
+			fromStdin = true
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	files, err := expandArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if fromStdin {
+		list, err := readFileList(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, list...)
+	}
+	return files, nil
+}
+
+// convert turns the video files given as arguments (globs are expanded) or
+// on stdin, or else the newest one in the src folder, into gifs.
+func convert(c *cli.Context) error {
+	if c.Bool("stdout") && isTerminal(os.Stdout) {
+		return exitError(exitUsage, fmt.Errorf("--stdout would write the gif to the terminal, redirect it to a file or pipe"))
+	}
+	if c.Args().Len() == 0 && !c.Bool("stdin") {
+		var videoFile string
+		if !c.Bool("newest") && isTerminal(os.Stdin) && isTerminal(os.Stderr) {
+			videoFile = pickVideo(c.String("src"))
+			if videoFile == "" {
+				return nil
+			}
+		} else {
+			videoFile = findNewestFile(c.String("src"))
+		}
+		if c.Bool("interactive") {
+			ok, err := scrub(c, videoFile)
+			if err != nil || !ok {
+				return err
+			}
+		}
+		if c.Bool("stdout") {
+			return writeGif(c.Context, c, os.Stdout, videoFile)
+		}
+		res, err := process(c.Context, c, videoFile)
+		printResult(c, res)
+		return err
+	}
+
+	files, err := inputFiles(c)
+	if err != nil {
+		return exitError(exitUsage, err)
+	}
+	if len(files) == 0 {
+		return exitError(exitUsage, fmt.Errorf("no files given on stdin"))
+	}
+	if len(files) == 1 {
+		if c.Bool("interactive") {
+			ok, err := scrub(c, files[0])
+			if err != nil || !ok {
+				return err
+			}
+		}
+		if c.Bool("stdout") {
+			return writeGif(c.Context, c, os.Stdout, files[0])
+		}
+		res, err := process(c.Context, c, files[0])
+		printResult(c, res)
+		return err
+	}
+	if c.Bool("stdout") {
+		return exitError(exitUsage, fmt.Errorf("--stdout takes a single video, got %d", len(files)))
+	}
+
+	results, err := processBatch(c, files)
+	if c.Bool("json") {
+		printJSON(results)
+	} else if !c.Bool("quiet") {
+		printSummary(results)
+	}
+	return err
+}
+
+var convertCommand = &cli.Command{
+	Name:  "convert",
+	Usage: "convert a movie to a gif and upload it (the default command)",
+	Description: `Converts right away in this process, without queueing behind the
+   recordings a ggif watch is converting.
+
+   Examples:
+      ggif convert recording.mov
+      ggif --width 640 --start 0:05 --end 0:12 convert recording.mov
+      ggif convert --no-upload '*.mov'
+      ls *.mov | ggif convert -
+      ggif convert --stdout recording.mov | aws s3 cp - s3://gifs/demo.gif`,
+	ArgsUsage: "[file|glob|-...]",
+	Before:    sweepBefore,
+	Action:    convert,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "no-upload",
+			Usage: "only write the gif locally",
+		},
+		&cli.BoolFlag{
+			Name:  "stdout",
+			Usage: "write the gif to stdout instead of the dist folder, without uploading it",
+		},
+	},
+}
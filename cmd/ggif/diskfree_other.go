@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+// diskFree can't tell the free space on this system.
+func diskFree(dir string) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// configReference lists every setting the config file accepts, generated
+// from the flags so it can't drift from them.
+func configReference(flags []cli.Flag) string {
+	var b strings.Builder
+	b.WriteString("Config keys, set in the config file, as GGIF_ variables or as flags:\n")
+	for _, f := range flags {
+		key := f.Names()[0]
+		kind := configKind(flags, key)
+		if kind == "" {
+			continue
+		}
+
+		var usage, value string
+		switch f := f.(type) {
+		case *altsrc.StringFlag:
+			usage, value = f.Usage, f.Value
+		case *altsrc.IntFlag:
+			usage, value = f.Usage, fmt.Sprint(f.Value)
+		case *altsrc.BoolFlag:
+			usage, value = f.Usage, fmt.Sprint(f.Value)
+		}
+		fmt.Fprintf(&b, "\n   %s (%s", key, kind)
+		if value != "" {
+			fmt.Fprintf(&b, ", default %s", value)
+		}
+		fmt.Fprintf(&b, ")\n      %s", usage)
+	}
+	b.WriteString("\n\n   Every key can also be set in a named profile, picked with --profile.")
+	return b.String()
+}
+
+func printDocs(c *cli.Context) error {
+	app := rootApp(c)
+	var (
+		out string
+		err error
+	)
+	if c.Bool("markdown") {
+		out, err = app.ToMarkdown()
+	} else {
+		out, err = app.ToMan()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
+
+var docsCommand = &cli.Command{
+	Name:   "docs",
+	Usage:  "print the man page, or markdown with --markdown",
+	Hidden: true,
+	Action: printDocs,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "markdown",
+			Usage: "print markdown instead of roff",
+		},
+	},
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/urfave/cli/v2"
+)
+
+// doctor collects the outcome of each environment check.
+type doctor struct {
+	failed int
+}
+
+func (d *doctor) pass(name string, detail string) {
+	fmt.Printf("  ✓ %s", name)
+	if detail != "" {
+		fmt.Printf(" (%s)", detail)
+	}
+	fmt.Println()
+}
+
+func (d *doctor) fail(name string, err error, hint string) {
+	d.failed++
+	fmt.Printf("  ✗ %s: %v\n", name, err)
+	if hint != "" {
+		fmt.Printf("      %s\n", hint)
+	}
+}
+
+func (d *doctor) tool(name string, hint string) bool {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		d.fail(name, fmt.Errorf("not found on PATH"), hint)
+		return false
+	}
+	d.pass(name, path)
+	return true
+}
+
+// optionalTool is tool for a program only some conversions need: a
+// missing one is reported but isn't a failure.
+func (d *doctor) optionalTool(name string, hint string) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		fmt.Printf("  - %s: not found on PATH\n      %s\n", name, hint)
+		return
+	}
+	d.pass(name, path)
+}
+
+func (d *doctor) writable(name string, dir string) {
+	f, err := ioutil.TempFile(dir, ".ggif-doctor")
+	if err != nil {
+		d.fail(name, err, fmt.Sprintf("create %s or point ggif at another folder", dir))
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.pass(name, dir)
+}
+
+func (d *doctor) config(c *cli.Context, fname string) {
+	values, err := readConfigFile(fname)
+	if err == nil {
+		errs := validateConfig(fname, values, appFlags(c))
+		if len(errs) > 0 {
+			msgs := make([]string, len(errs))
+			for i, err := range errs {
+				msgs[i] = err.Error()
+			}
+			err = fmt.Errorf("%d problem(s)\n      %s", len(errs), strings.Join(msgs, "\n      "))
+		}
+	}
+	if err != nil {
+		d.fail("config", err, "")
+		return
+	}
+	d.pass("config", fname)
+}
+
+func runDoctor(c *cli.Context) error {
+	d := &doctor{}
+
+	fmt.Println("Tools:")
+	d.tool("ffmpeg", "install it from https://ffmpeg.org")
+	d.tool("gifski", "install it from https://gif.ski")
+	d.optionalTool("agg", "needed for asciinema recordings only, install it from https://github.com/asciinema/agg")
+
+	fmt.Println("Config:")
+	configs := 0
+	if fname := c.String("load"); fname != "" {
+		d.config(c, fname)
+		configs++
+	}
+	if project := findProjectConfigFile(); project != "" && project != c.String("load") {
+		d.config(c, project)
+		configs++
+	}
+	if configs == 0 {
+		d.pass("config", "none found, using defaults; run `ggif init` to create one")
+	}
+
+	fmt.Println("Upload:")
+	if !usesGCS(c) {
+		d.tool(uploaderTool(c), "install the plugin or set uploader back to gcs")
+	} else if bucket := c.String("bucket"); bucket == "" {
+		d.pass("bucket", "none configured, gifs stay local")
+	} else if d.tool("gsutil", "install the google cloud sdk from https://cloud.google.com/sdk") {
+		out, err := exec.Command("gsutil", gsutilArgs(c, "ls", "-b", fmt.Sprintf("gs://%s", bucket))...).CombinedOutput()
+		if err != nil {
+			d.fail("bucket gs://"+bucket, fmt.Errorf("%s", strings.TrimSpace(string(out))), "run `gcloud auth login` or set gcs-credentials, and check the bucket name")
+		} else {
+			d.pass("bucket gs://"+bucket, "reachable")
+		}
+	}
+	if clipboard.Unsupported {
+		d.fail("clipboard", fmt.Errorf("no clipboard utility available"), "install xsel, xclip or wl-clipboard to have urls copied")
+	} else {
+		d.pass("clipboard", "")
+	}
+
+	fmt.Println("Folders:")
+	d.writable("temp", tempDir(c))
+	distDir := c.String("dist")
+	if distDir == "" {
+		distDir = c.String("src")
+	}
+	d.writable("output", distDir)
+
+	if d.failed > 0 {
+		return exitError(exitDependency, fmt.Errorf("%d check(s) failed", d.failed))
+	}
+	fmt.Println("Everything looks good.")
+	return nil
+}
+
+var doctorCommand = &cli.Command{
+	Name:  "doctor",
+	Usage: "check that ggif's dependencies and settings work",
+	Description: `Checks for ffmpeg, gifski and gsutil or the uploader plugin, the config
+   files, the bucket, clipboard support and that the temp and output folders
+   are writable.  Exits non-zero when something is broken.  agg, which only
+   asciinema recordings need, is looked for too but may be missing.`,
+	Action: runDoctor,
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// emailRecipients splits the email-to setting.
+func emailRecipients(c *cli.Context) []string {
+	var to []string
+	for _, addr := range strings.Split(c.String("email-to"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			to = append(to, addr)
+		}
+	}
+	return to
+}
+
+// emailMessage builds the mail for e: the gif attached when it is at most
+// maxSize, and the url when there is one.  It fails when there is neither.
+func emailMessage(from string, to []string, e *historyEntry, maxSize int64) ([]byte, error) {
+	var attach []byte
+	if fi, err := os.Stat(e.Output); err == nil && (maxSize == 0 || fi.Size() <= maxSize) {
+		if attach, err = ioutil.ReadFile(e.Output); err != nil {
+			return nil, err
+		}
+	}
+	var text string
+	switch {
+	case len(e.URLs) > 0 && attach != nil:
+		text = e.URLs[0] + "\n\nThe gif is attached too.\n"
+	case len(e.URLs) > 0:
+		text = e.URLs[0] + "\n"
+	case attach != nil:
+		text = "The gif is attached.\n"
+	default:
+		return nil, fmt.Errorf("%s is too big to attach and was never uploaded", e.Output)
+	}
+
+	var msg bytes.Buffer
+	w := multipart.NewWriter(&msg)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "gif of "+filepath.Base(e.Source)))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(text, "\n", "\r\n")))
+
+	if attach != nil {
+		name := filepath.Base(e.Output)
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType("image/gif", map[string]string{"name": name})},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attach)
+		// lines of mail may not be longer than 998 characters
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// sendMail delivers msg through the smtp-server setting, over TLS from the
+// start on port 465 and with STARTTLS elsewhere when the server offers it.
+// The certificate authorities of --ca-file are trusted too.
+func sendMail(c *cli.Context, from string, to []string, msg []byte) error {
+	addr := c.String("smtp-server")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("bad smtp-server %q, want host:port like smtp.example.com:587", addr)
+	}
+	tlsConfig := &tls.Config{ServerName: host}
+	if t, ok := http.DefaultTransport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		tlsConfig.RootCAs = t.TLSClientConfig.RootCAs
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	if port == "465" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != "465" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if user := c.String("smtp-user"); user != "" {
+		// PlainAuth refuses to send the password without TLS
+		password, err := secretSetting(c, "smtp-password")
+		if err != nil {
+			return err
+		}
+		if err := client.Auth(smtp.PlainAuth("", user, password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := client.Rcpt(addr); err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// shareEmail mails the gif to the email-to recipients, attached when it is
+// within email-max-attachment and as its url otherwise.
+func shareEmail(c *cli.Context, e *historyEntry) (string, error) {
+	to := emailRecipients(c)
+	if len(to) == 0 {
+		return "", fmt.Errorf("no recipients configured, set them with `ggif config set email-to <address>,...`")
+	}
+	if c.String("smtp-server") == "" {
+		return "", fmt.Errorf("no smtp server configured, set one with `ggif config set smtp-server <host:port>`")
+	}
+	from := c.String("email-from")
+	if from == "" {
+		from = c.String("smtp-user")
+	}
+	if from == "" {
+		return "", fmt.Errorf("no sender configured, set one with `ggif config set email-from <address>`")
+	}
+	maxSize, _ := parseSize(c.String("email-max-attachment"))
+	msg, err := emailMessage(from, to, e, int64(maxSize))
+	if err != nil {
+		return "", err
+	}
+
+	sent := e.Output
+	if len(e.URLs) > 0 {
+		sent = e.URLs[0]
+	}
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "MAIL %s to %s (%s)\n", sent, strings.Join(to, ", "), humanSize(int64(len(msg))))
+		return sent, nil
+	}
+	if err := sendMail(c, from, to, msg); err != nil {
+		return "", fmt.Errorf("email: %w", err)
+	}
+	log.Infof("Mailed %s to %s", sent, strings.Join(to, ", "))
+	return sent, nil
+}
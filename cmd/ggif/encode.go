@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// gifReader reads a gif out of a temporary folder that Close removes.
+type gifReader struct {
+	*os.File
+	dir string
+}
+
+func (g *gifReader) Close() error {
+	err := g.File.Close()
+	os.RemoveAll(g.dir)
+	return err
+}
+
+// encodeGif converts videoFile into a gif without writing to the dist folder,
+// uploading it or recording it in the history, for callers that send the gif
+// somewhere themselves.  The caller must close the reader.
+func encodeGif(ctx context.Context, c *cli.Context, videoFile string) (io.ReadCloser, *result, error) {
+	start := time.Now()
+	res := &result{
+		Source:    videoFile,
+		URLs:      []string{},
+		Durations: make(map[string]float64),
+	}
+	fail := func(err error) (io.ReadCloser, *result, error) {
+		res.Error = err.Error()
+		res.Durations["total"] = time.Since(start).Seconds()
+		observeResult(res)
+		return nil, res, err
+	}
+
+	if err := checkVideo(ctx, videoFile); err != nil {
+		return fail(exitError(exitUsage, err))
+	}
+	dir, err := makeTempDir(tempDir(c), "encode")
+	if err != nil {
+		return fail(exitError(exitFailure, err))
+	}
+	outfn := filepath.Join(dir, "out.gif")
+	if err := renderGif(ctx, c, res, videoFile, outfn); err != nil {
+		os.RemoveAll(dir)
+		return fail(err)
+	}
+	if c.Bool("dry-run") {
+		os.RemoveAll(dir)
+		return ioutil.NopCloser(strings.NewReader("")), res, nil
+	}
+
+	if err := checkGif(c, outfn); err != nil {
+		os.RemoveAll(dir)
+		return fail(exitError(exitConvert, err))
+	}
+	f, err := os.Open(outfn)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fail(exitError(exitConvert, err))
+	}
+	if fi, err := f.Stat(); err == nil {
+		res.Size = fi.Size()
+	}
+	res.Width, res.Height = gifSize(outfn)
+	res.Durations["total"] = time.Since(start).Seconds()
+	observeResult(res)
+	return &gifReader{f, dir}, res, nil
+}
+
+// writeGif streams the gif of videoFile to w.
+func writeGif(ctx context.Context, c *cli.Context, w io.Writer, videoFile string) error {
+	gif, _, err := encodeGif(ctx, c, videoFile)
+	if err != nil {
+		return err
+	}
+	defer gif.Close()
+	_, err = io.Copy(w, gif)
+	return err
+}
+
+// serveGif answers with the gif itself rather than the result json, for
+// POST /convert with Accept: image/gif.  It converts in one of the local
+// slots of q, waiting for one to free up.
+func serveGif(w http.ResponseWriter, r *http.Request, c *cli.Context, q *jobQueue) {
+	if cap(q.slots) == 0 {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("this server leaves conversions to ggif worker (--workers 0), which can't stream a gif back"))
+		return
+	}
+
+	dir, err := makeTempDir(tempDir(c), "serve")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	videoFile, err := receiveVideo(w, r, dir)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	if !q.slots.acquire(r.Context()) {
+		return
+	}
+	gif, res, err := encodeGif(r.Context(), c, videoFile)
+	q.slots.release()
+	if err != nil {
+		log.Errorf("%s: %v", r.RemoteAddr, err)
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	defer gif.Close()
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.FormatInt(res.Size, 10))
+	io.Copy(w, gif)
+}
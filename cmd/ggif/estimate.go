@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// defaultBytesPerPixel is about what a pixel of a frame of a gifski gif
+	// of a screen recording takes, for estimates without a history.
+	defaultBytesPerPixel = 0.12
+	// estimateHistory is how many of the latest gifs estimates learn from.
+	estimateHistory = 50
+)
+
+// gifEstimate is what a conversion with the current settings is expected
+// to make, as printed by ggif estimate.
+type gifEstimate struct {
+	Source   string  `json:"source"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Duration float64 `json:"duration"`
+	Frames   int     `json:"frames"`
+	Size     int64   `json:"size"`
+	// TmpSize is about how much temp space the extracted frames take.
+	TmpSize int64 `json:"tmp_size"`
+	// Basis is how many earlier gifs the size is estimated from, 0 for a
+	// rule of thumb.
+	Basis int `json:"basis"`
+
+	videoDuration float64
+}
+
+// clipLength is the length of the part of a video of duration seconds
+// that start and end select.
+func clipLength(start string, end string, duration float64) float64 {
+	from, _ := parseTimestamp(start)
+	to := duration
+	if end != "" {
+		if t, err := parseTimestamp(end); err == nil && t < duration {
+			to = t
+		}
+	}
+	return math.Max(to-from, 0)
+}
+
+// gifFrames is how many frames gifski keeps of a clip of length seconds,
+// at fps or the frame rate of the video if that is lower.
+func gifFrames(length float64, videoFPS float64, fps int) float64 {
+	rate := float64(fps)
+	if videoFPS > 0 && videoFPS < rate {
+		rate = videoFPS
+	}
+	return length * rate
+}
+
+// historyBytesPerPixel learns what a pixel of a frame took in the latest
+// gifs of the history, those made at quality when there are a few.  It
+// returns the median and how many gifs it is taken from.
+func historyBytesPerPixel(quality int) (float64, int) {
+	entries, err := readHistory(estimateHistory, func(e *historyEntry) bool {
+		return e.Size > 0 && e.Width > 0 && e.Height > 0 && e.Input.Duration > 0 && !e.Cached
+	})
+	if err != nil {
+		log.Debug(err)
+		return 0, 0
+	}
+
+	var all, same []float64
+	for _, e := range entries {
+		fps, _ := toInt(e.Settings["frames"])
+		start, _ := e.Settings["start"].(string)
+		end, _ := e.Settings["end"].(string)
+		frames := gifFrames(clipLength(start, end, e.Input.Duration), 0, fps)
+		if frames < 1 {
+			continue
+		}
+		bpp := float64(e.Size) / (float64(e.Width*e.Height) * frames)
+		all = append(all, bpp)
+		if q, _ := toInt(e.Settings["quality"]); q == quality {
+			same = append(same, bpp)
+		}
+	}
+	if len(same) >= 3 {
+		all = same
+	}
+	if len(all) == 0 {
+		return 0, 0
+	}
+	sort.Float64s(all)
+	return all[len(all)/2], len(all)
+}
+
+// estimateGif predicts the gif of videoFile from its probe and the
+// settings, without extracting a frame.
+func estimateGif(c *cli.Context, videoFile string) (*gifEstimate, error) {
+	info, err := probe(c.Context, videoFile)
+	switch {
+	case err != nil && isMissingTool(err):
+		return nil, exitError(exitDependency, fmt.Errorf("ffprobe is needed to estimate: %w", err))
+	case err != nil:
+		return nil, exitError(exitUsage, fmt.Errorf("%s is not a video ffmpeg can read: %v", videoFile, err))
+	case info.Width == 0 || info.Duration == 0:
+		return nil, exitError(exitUsage, fmt.Errorf("%s has no video stream", videoFile))
+	}
+	start, end, err := trimRange(c)
+	if err != nil {
+		return nil, exitError(exitUsage, err)
+	}
+	if start >= info.Duration {
+		return nil, exitError(exitUsage, fmt.Errorf("--start %s is past the end of %s", c.String("start"), videoFile))
+	}
+
+	e := &gifEstimate{Source: videoFile, Width: info.Width, Height: info.Height}
+	// gifski only ever scales down
+	if w := c.Int("width"); w < info.Width {
+		e.Width = w
+		e.Height = int(math.Round(float64(info.Height) * float64(w) / float64(info.Width)))
+	}
+	if end == 0 || end > info.Duration {
+		end = info.Duration
+	}
+	e.Duration = end - start
+
+	extracted, frameSize, _ := estimateFrames(c.Context, c, videoFile)
+	frames := gifFrames(e.Duration, info.FPS, c.Int("frames"))
+	if max := int64(c.Int("max-frames")); max > 0 && extracted > max {
+		// ffmpeg stops early, gifski keeps the same share of what it got
+		frames = frames * float64(max) / float64(extracted)
+		extracted = max
+	}
+	e.Frames = int(math.Round(frames))
+	e.TmpSize = extracted * int64(frameSize)
+
+	bpp, basis := historyBytesPerPixel(c.Int("quality"))
+	if basis == 0 {
+		bpp = defaultBytesPerPixel
+	}
+	e.Basis = basis
+	e.Size = int64(bpp * float64(e.Width*e.Height) * frames)
+	e.videoDuration = info.Duration
+	return e, nil
+}
+
+func estimate(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return exitError(exitUsage, fmt.Errorf("give the video to estimate, like `ggif estimate recording.mov`"))
+	}
+	e, err := estimateGif(c, c.Args().First())
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		printJSON(e)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "clip\t%s\n", formatTimestamp(e.Duration))
+	fmt.Fprintf(w, "size\t%dx%d\n", e.Width, e.Height)
+	fmt.Fprintf(w, "frames\tabout %d, %s of frames in the temp folder\n", e.Frames, humanSize(e.TmpSize))
+	basis := "a rule of thumb, there is no history yet"
+	if e.Basis > 0 {
+		basis = fmt.Sprintf("your last %d gifs", e.Basis)
+	}
+	fmt.Fprintf(w, "gif\tabout %s, from %s\n", humanSize(e.Size), basis)
+
+	target, _ := parseSize(c.String("warn-gif-size"))
+	if target > 0 && uint64(e.Size) > target {
+		res := &result{Width: e.Width, Size: e.Size}
+		res.Input.Duration = e.videoDuration
+		var suggestions []string
+		for _, s := range sizeSuggestions(c, res, int64(target)) {
+			suggestions = append(suggestions, fmt.Sprintf("%s (est. %s)", strings.Join(s.args, " "), humanSize(s.size)))
+		}
+		if len(suggestions) > 0 {
+			fmt.Fprintf(w, "smaller\t%s\n", strings.Join(suggestions, ", "))
+		}
+	}
+	return w.Flush()
+}
+
+var estimateCommand = &cli.Command{
+	Name:      "estimate",
+	Usage:     "predict the size, frames and dimensions of a gif without converting",
+	ArgsUsage: "<file>",
+	Description: `Probes the video and works out what a conversion with the current
+   settings would make, to adjust them before a long run.  The size is
+   estimated from the gifs in the history made at the same quality, or a
+   rule of thumb until there are some.
+
+   Examples:
+      ggif estimate recording.mov
+      ggif --width 640 --frames 12 --start 0:05 --end 0:40 estimate recording.mov`,
+	Action: estimate,
+}
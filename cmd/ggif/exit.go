@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Exit codes, so wrapper scripts can tell what went wrong.
+const (
+	exitOK         = 0
+	exitFailure    = 1 // anything not covered below
+	exitUsage      = 2 // bad arguments, missing or unreadable input files
+	exitDependency = 3 // ffmpeg, gifski, gsutil, ... not installed
+	exitConvert    = 4 // extracting frames or encoding the gif failed
+	exitUpload     = 5 // copying the gif to the bucket failed
+	exitConfig     = 6 // unreadable or invalid config file or settings
+)
+
+// codedError is an error with the exit code of its failure class.
+type codedError struct {
+	error
+	code int
+}
+
+func (e *codedError) ExitCode() int { return e.code }
+func (e *codedError) Unwrap() error { return e.error }
+
+// exitError marks err with the exit code of its failure class, unless it
+// already has one.  A missing program is always a dependency error, whatever
+// it was run for.
+func exitError(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return err
+	}
+	if isMissingTool(err) {
+		code = exitDependency
+	}
+	return &codedError{err, code}
+}
+
+// isMissingTool reports whether running a program failed because it is not
+// installed, either directly or as reported by a wrapper script.
+func isMissingTool(err error) bool {
+	if errors.Is(err, exec.ErrNotFound) {
+		return true
+	}
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == 127
+}
+
+// exitCode returns the code the process should exit with for err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var coded *codedError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return exitFailure
+}
+
+// usageError gives flag parsing errors the usage exit code.
+func usageError(c *cli.Context, err error, isSubcommand bool) error {
+	return exitError(exitUsage, fmt.Errorf("%v, see `ggif help`", err))
+}
+
+// setUsageErrors installs usageError on every command.
+func setUsageErrors(commands []*cli.Command) {
+	for _, cmd := range commands {
+		cmd.OnUsageError = usageError
+		setUsageErrors(cmd.Subcommands)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// gcsKeyOptions are the boto options gsutil reads each kind of application
+// default credentials file from.  authorized_user files, from gcloud auth
+// application-default login, aren't there: gsutil already has that login.
+var gcsKeyOptions = map[string]string{
+	"service_account":                  "Credentials:gs_service_key_file",
+	"external_account":                 "Credentials:gs_external_account_file",
+	"external_account_authorized_user": "Credentials:gs_external_account_authorized_user_file",
+}
+
+// gcsCredentialsType returns the type of the credentials file fname, one of
+// the keys of gcsKeyOptions or authorized_user.
+func gcsCredentialsType(fname string) (string, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return "", err
+	}
+	var creds struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return "", fmt.Errorf("%s isn't a google credentials file: %v", fname, err)
+	}
+	if _, ok := gcsKeyOptions[creds.Type]; !ok && creds.Type != "authorized_user" {
+		return "", fmt.Errorf("%s has credentials of unknown type %q", fname, creds.Type)
+	}
+	return creds.Type, nil
+}
+
+func gcsCredentialsFile(value interface{}) error {
+	if value.(string) == "" {
+		return nil
+	}
+	_, err := gcsCredentialsType(value.(string))
+	return err
+}
+
+// fromADC reports whether gcs-credentials was only taken from
+// $GOOGLE_APPLICATION_CREDENTIALS, which other tools set for themselves.
+func fromADC() bool {
+	return settingSources["gcs-credentials"] == "env $GOOGLE_APPLICATION_CREDENTIALS"
+}
+
+// checkGCSCredentials checks the gcs-credentials file before uploading.  A
+// file of a type gsutil can't use from $GOOGLE_APPLICATION_CREDENTIALS is
+// left for the tools it was set for, and gsutil uses its own login.
+func checkGCSCredentials(c *cli.Context) error {
+	fname := c.String("gcs-credentials")
+	if fname == "" {
+		return nil
+	}
+	if _, err := gcsCredentialsType(fname); err != nil && !fromADC() {
+		return fmt.Errorf("--gcs-credentials %v", err)
+	}
+	return nil
+}
+
+func serviceAccountEmail(value interface{}) error {
+	if email := value.(string); email != "" && !strings.Contains(email, "@") {
+		return fmt.Errorf("must be a service account email like uploader@project.iam.gserviceaccount.com, got %q", email)
+	}
+	return nil
+}
+
+// gcsCredentialArgs are the gsutil options for the gcs-credentials and
+// impersonate-service-account settings.  Without them gsutil uses its own
+// login, or the metadata server on GCE and GKE, workload identity
+// included.
+func gcsCredentialArgs(c *cli.Context) []string {
+	var opts []string
+	if fname := c.String("gcs-credentials"); fname != "" {
+		kind, err := gcsCredentialsType(fname)
+		if err != nil {
+			// checked by checkGCSCredentials before uploading
+			log.Debugf("Not passing gcs-credentials to gsutil: %v", err)
+		} else if option, ok := gcsKeyOptions[kind]; ok {
+			opts = append(opts, "-o", option+"="+fname)
+		}
+	}
+	if email := c.String("impersonate-service-account"); email != "" {
+		opts = append(opts, "-i", email)
+	}
+	return opts
+}
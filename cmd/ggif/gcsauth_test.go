@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGCSCredentialsType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ggif-gcs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		contents string
+		want     string
+		ok       bool
+	}{
+		{`{"type": "service_account", "client_email": "a@b"}`, "service_account", true},
+		{`{"type": "external_account"}`, "external_account", true},
+		{`{"type": "external_account_authorized_user"}`, "external_account_authorized_user", true},
+		{`{"type": "authorized_user"}`, "authorized_user", true},
+		{`{"type": "impersonated_service_account"}`, "", false},
+		{`{}`, "", false},
+		{`not json`, "", false},
+	}
+	for i, tt := range tests {
+		fname := filepath.Join(dir, "creds.json")
+		if err := ioutil.WriteFile(fname, []byte(tt.contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := gcsCredentialsType(fname)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("%d: gcsCredentialsType(%s) = %q, %v, want %q ok %v", i, tt.contents, got, err, tt.want, tt.ok)
+		}
+	}
+	if _, err := gcsCredentialsType(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("a missing file should be an error")
+	}
+}
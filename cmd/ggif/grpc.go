@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	"github.com/neurosnap/ggif/api/ggifpb"
+)
+
+// grpcCode maps the exit code of a failed conversion to a grpc status code,
+// the way httpStatus does for the http api.
+func grpcCode(err error) codes.Code {
+	switch exitCode(err) {
+	case exitUsage:
+		return codes.InvalidArgument
+	case exitConvert, exitConfig, exitDependency:
+		return codes.FailedPrecondition
+	case exitUpload:
+		return codes.Unavailable
+	}
+	return codes.Internal
+}
+
+func grpcError(err error) error {
+	return status.Error(grpcCode(err), err.Error())
+}
+
+// pbResult converts a result to its protobuf message.
+func pbResult(res *result) *ggifpb.Result {
+	if res == nil {
+		return &ggifpb.Result{}
+	}
+	return &ggifpb.Result{
+		Source: res.Source,
+		Input: &ggifpb.Media{
+			Width:    int32(res.Input.Width),
+			Height:   int32(res.Input.Height),
+			Duration: res.Input.Duration,
+			Size:     res.Input.Size,
+		},
+		Output:    res.Output,
+		Width:     int32(res.Width),
+		Height:    int32(res.Height),
+		Frames:    int32(res.Frames),
+		Size:      res.Size,
+		Sha256:    res.SHA256,
+		Urls:      res.URLs,
+		Skipped:   res.Skipped,
+		Error:     res.Error,
+		Durations: res.Durations,
+	}
+}
+
+// grpcServer runs the conversion pipeline for grpc clients.
+type grpcServer struct {
+	ggifpb.UnimplementedGgifServer
+	c *cli.Context
+	// jobs runs Convert at high priority ahead of the watched recordings
+	// of Watch at low.  Its slots bound those and the gifs of Encode to
+	// --workers.
+	jobs *jobQueue
+}
+
+// allowedDirs are the folders clients may name files in: --allow-dir, or
+// else the src and dist folders.
+func allowedDirs(c *cli.Context) []string {
+	if dirs := c.StringSlice("allow-dir"); len(dirs) > 0 {
+		return dirs
+	}
+	dirs := []string{c.String("src")}
+	if dist := c.String("dist"); dist != "" {
+		dirs = append(dirs, dist)
+	}
+	return dirs
+}
+
+// insideDir reports whether path, already absolute and free of symlinks,
+// is dir or lies under it.
+func insideDir(path string, dir string) bool {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = real
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// allowedPath resolves a path on this machine that a client named, and
+// refuses it unless it lies in one of the allowed folders with symlinks
+// followed, so a client can't have any file the server can read uploaded.
+func allowedPath(c *cli.Context, path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		return "", exitError(exitUsage, fmt.Errorf("%s does not exist", path))
+	}
+	for _, dir := range allowedDirs(c) {
+		if dir != "" && insideDir(abs, dir) {
+			return abs, nil
+		}
+	}
+	return "", exitError(exitUsage, fmt.Errorf("%s is outside the folders clients may use, see --allow-dir", path))
+}
+
+// requestVideo finds the video of a request, storing it in dir unless it is
+// already on this machine.
+func requestVideo(ctx context.Context, c *cli.Context, dir string, req *ggifpb.ConvertRequest) (string, error) {
+	var videoFile string
+	var err error
+	switch src := req.Source.(type) {
+	case *ggifpb.ConvertRequest_Video:
+		videoFile, err = saveVideo(dir, req.Name, bytes.NewReader(src.Video))
+	case *ggifpb.ConvertRequest_Url:
+		videoFile, err = fetchVideo(ctx, dir, src.Url)
+	case *ggifpb.ConvertRequest_Path:
+		videoFile, err = allowedPath(c, src.Path)
+	default:
+		err = exitError(exitUsage, fmt.Errorf("set one of video, url or path"))
+	}
+	if err == nil && !isRecording(videoFile) {
+		err = exitError(exitUsage, fmt.Errorf("not a video file or asciinema recording"))
+	}
+	return videoFile, err
+}
+
+func (s *grpcServer) Convert(ctx context.Context, req *ggifpb.ConvertRequest) (*ggifpb.Result, error) {
+	dir, err := makeTempDir(tempDir(s.c), "grpc")
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	defer os.RemoveAll(dir)
+
+	videoFile, err := requestVideo(ctx, s.c, dir, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	// someone is waiting for the answer
+	queued, err := s.jobs.start(videoFile, "", "high")
+	if err != nil {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	log.Infof("grpc: job %s converting %s", queued.ID, videoFile)
+	state, ok := s.jobs.wait(ctx, queued.ID)
+	if !ok {
+		if j, ok := s.jobs.lookup(queued.ID); ok {
+			s.jobs.abort(j)
+		}
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if state.err != nil {
+		log.Errorf("%s: %v", videoFile, state.err)
+		return nil, grpcError(state.err)
+	}
+	return pbResult(state.Result), nil
+}
+
+// encodeChunk is the size of the pieces Encode sends the gif in.
+const encodeChunk = 64 << 10
+
+func (s *grpcServer) Encode(req *ggifpb.ConvertRequest, stream ggifpb.Ggif_EncodeServer) error {
+	dir, err := makeTempDir(tempDir(s.c), "grpc")
+	if err != nil {
+		return grpcError(err)
+	}
+	defer os.RemoveAll(dir)
+
+	videoFile, err := requestVideo(stream.Context(), s.c, dir, req)
+	if err != nil {
+		return grpcError(err)
+	}
+
+	log.Infof("grpc: encoding %s", videoFile)
+	if !s.jobs.slots.acquire(stream.Context()) {
+		return status.FromContextError(stream.Context().Err()).Err()
+	}
+	gif, _, err := encodeGif(stream.Context(), s.c, videoFile)
+	s.jobs.slots.release()
+	if err != nil {
+		log.Errorf("%s: %v", videoFile, err)
+		return grpcError(err)
+	}
+	defer gif.Close()
+
+	buf := make([]byte, encodeChunk)
+	for {
+		n, err := gif.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&ggifpb.Chunk{Data: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return grpcError(err)
+		}
+	}
+}
+
+func (s *grpcServer) Upload(ctx context.Context, req *ggifpb.UploadRequest) (*ggifpb.Result, error) {
+	if err := checkUploader(s.c); err != nil {
+		return nil, grpcError(err)
+	}
+	path, err := allowedPath(s.c, req.Path)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	// only gifs go to the bucket, whatever else sits in the folder
+	if err := checkGif(s.c, path); err != nil {
+		return nil, grpcError(exitError(exitUsage, err))
+	}
+
+	log.Infof("grpc: uploading %s", path)
+	sum, _ := fileSHA256(path)
+	url, err := uploadFile(withGifProvenance(ctx, s.c, sum), s.c, path, filepath.Base(path))
+	if err != nil {
+		return nil, grpcError(exitError(exitUpload, err))
+	}
+	res := &result{Source: path, Output: path, SHA256: sum}
+	if fi, err := os.Stat(path); err == nil {
+		res.Size = fi.Size()
+	}
+	if url != "" {
+		res.URLs = []string{url}
+		recordHistory(s.c, res)
+	}
+	return pbResult(res), nil
+}
+
+// Watch streams a result for every video converted from the src folder.
+// They are queued at low priority, behind Convert calls.  Only one client
+// can watch at a time, a second one gets the error of watchFolder.
+func (s *grpcServer) Watch(req *ggifpb.WatchRequest, stream ggifpb.Ggif_WatchServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	err := watchFolder(ctx, s.c, s.jobs, nil, func(res *result, err error) {
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+		if err := stream.Send(pbResult(res)); err != nil {
+			log.Infof("grpc: watch client went away: %v", err)
+			cancel()
+		}
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+// checkToken rejects calls without the bearer token in their metadata, when
+// one is set.  Health checks are let through for probes.
+func checkToken(ctx context.Context, method string, token string) error {
+	if token == "" || strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if tokenMatches(auth, token) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or wrong token")
+}
+
+func grpcServe(c *cli.Context) error {
+	if err := serverMode(c); err != nil {
+		return err
+	}
+
+	if c.Int("workers") < 1 {
+		return exitError(exitUsage, fmt.Errorf("--workers must be at least 1"))
+	}
+	addr := c.String("addr")
+	token := c.String("token")
+	if token == "" && !loopbackAddr(addr) {
+		return exitError(exitUsage, fmt.Errorf("--token is needed to serve grpc on %s, anyone who can reach it could convert and upload", addr))
+	}
+
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(maxVideoSize+1<<20),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := checkToken(ctx, info.FullMethod, token); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := checkToken(ss.Context(), info.FullMethod, token); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	ggifpb.RegisterGgifServer(server, &grpcServer{c: c, jobs: newJobQueue(c, c.Int("workers"))})
+	// lets grpcurl and friends list the service without the .proto
+	reflection.Register(server)
+	healthServer := health.NewServer()
+	if missing := missingTools(c); len(missing) > 0 {
+		log.Warningf("Health checks report NOT_SERVING, %s not on the PATH", strings.Join(missing, ", "))
+		healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	serveMonitoring(c)
+	log.Noticef("Listening on %s", addr)
+	go func() {
+		<-c.Context.Done()
+		// cancels the contexts of in-flight calls, and with them their
+		// conversions
+		server.Stop()
+	}()
+	return server.Serve(lis)
+}
+
+var grpcCommand = &cli.Command{
+	Name:  "grpc",
+	Usage: "run a grpc server for the convert, upload and watch pipeline",
+	Description: `Serves the ggif.v1.Ggif service from api/ggif.proto.  Convert takes the
+   video's bytes, a url to fetch it from or a path on the server, Upload
+   takes the path of a gif and Watch streams a result for every video that
+   shows up in the src folder, converting them after any Convert call
+   waiting.  Paths must be inside the src or dist folder, or one given
+   with --allow-dir.  With --token, calls need an "authorization: Bearer
+   <token>" metadata entry; it is required unless the server only listens
+   on localhost.
+
+   Examples:
+      ggif grpc --addr :9090 --token s3cret
+      grpcurl -plaintext -H 'authorization: Bearer s3cret' \
+         -d '{"url": "https://example.com/demo.mp4"}' localhost:9090 ggif.v1.Ggif/Convert`,
+	Before: sweepBefore,
+	Action: grpcServe,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "addr",
+			EnvVars: []string{"GGIF_GRPC_ADDR"},
+			Value:   "localhost:9090",
+			Usage:   "address to listen on",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			EnvVars: []string{"GGIF_TOKEN"},
+			Usage:   "require this bearer token on every call",
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			EnvVars: []string{"GGIF_WORKERS"},
+			Value:   runtime.NumCPU(),
+			Usage:   "conversions to run at once, more calls wait their turn",
+		},
+		&cli.StringSliceFlag{
+			Name:    "allow-dir",
+			EnvVars: []string{"GGIF_GRPC_ALLOW_DIR"},
+			Usage:   "folder clients may name paths in, repeatable (default: the src and dist folders)",
+		},
+	},
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestAllowedPath(t *testing.T) {
+	root, err := ioutil.TempDir("", "ggif-grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	root, _ = filepath.EvalSymlinks(root)
+
+	src := filepath.Join(root, "src")
+	other := filepath.Join(root, "other")
+	for _, dir := range []string{src, other} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, fname := range []string{filepath.Join(src, "demo.gif"), filepath.Join(other, "secret")} {
+		if err := ioutil.WriteFile(fname, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a link in src must not lead outside it
+	if err := os.Symlink(filepath.Join(other, "secret"), filepath.Join(src, "link.gif")); err != nil {
+		t.Skip(err)
+	}
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("src", src, "")
+	set.String("dist", "", "")
+	set.Var(cli.NewStringSlice(), "allow-dir", "")
+	c := cli.NewContext(nil, set, nil)
+
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{filepath.Join(src, "demo.gif"), true},
+		{filepath.Join(src, "..", "src", "demo.gif"), true},
+		{filepath.Join(src, "..", "other", "secret"), false},
+		{filepath.Join(other, "secret"), false},
+		{filepath.Join(src, "link.gif"), false},
+		{filepath.Join(src, "missing.gif"), false},
+		{src + "-sibling", false},
+	}
+	for _, tt := range tests {
+		_, err := allowedPath(c, tt.path)
+		if (err == nil) != tt.ok {
+			t.Errorf("allowedPath(%s) error = %v, want ok %v", tt.path, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	lastSuccessMu sync.Mutex
+	lastSuccess   time.Time
+)
+
+// markSuccess records that a conversion just went through.
+func markSuccess() {
+	lastSuccessMu.Lock()
+	lastSuccess = time.Now()
+	lastSuccessMu.Unlock()
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Status      string     `json:"status"` // ok or unavailable
+	Missing     []string   `json:"missing,omitempty"`
+	Queued      int        `json:"queued"`
+	Running     int        `json:"running"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// missingTools lists the programs conversions need that are not on the PATH.
+func missingTools(c *cli.Context) []string {
+	tools := []string{"ffmpeg", "gifski"}
+	if !c.Bool("no-upload") && (c.String("bucket") != "" || !usesGCS(c)) {
+		tools = append(tools, uploaderTool(c))
+	}
+	var missing []string
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool); err != nil {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// healthzHandler answers as long as the process is up.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether conversions can run, with 503 when a tool
+// is missing.  q is nil outside of ggif serve.
+func readyzHandler(c *cli.Context, q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ready := readiness{Status: "ok"}
+		if q != nil {
+			ready.Queued, ready.Running = q.depth()
+		}
+		if q == nil || q.workers > 0 {
+			// with remote workers only, the tools are their business
+			ready.Missing = missingTools(c)
+		}
+		lastSuccessMu.Lock()
+		if !lastSuccess.IsZero() {
+			t := lastSuccess
+			ready.LastSuccess = &t
+		}
+		lastSuccessMu.Unlock()
+
+		status := http.StatusOK
+		if len(ready.Missing) > 0 {
+			ready.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, ready)
+	}
+}
+
+// handleHealth mounts /healthz and /readyz, which never need a token so
+// probes can reach them.
+func handleHealth(mux *http.ServeMux, c *cli.Context, q *jobQueue) {
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.Handle("/readyz", readyzHandler(c, q))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var historyBucket = []byte("history")
+
+// historyEntry is one conversion or upload as recorded in the history
+// database.
+type historyEntry struct {
+	ID       uint64                 `json:"id"`
+	Time     time.Time              `json:"time"`
+	Settings map[string]interface{} `json:"settings"`
+	result
+}
+
+// historyMu serializes access to the database, bolt's file lock would
+// otherwise block parallel conversions against each other.
+var historyMu sync.Mutex
+
+// openHistory opens the history database in the data folder, creating both
+// when needed.
+func openHistory() (*bolt.DB, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, "history.db"), 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(historyBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(cacheBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(uploadsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func historyKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
+
+// recordHistory stores a finished conversion along with the settings it was
+// made with.  Only gifs that were made or uploaded are recorded, not failed
+// or skipped conversions.  Failing to record is logged, never fatal.
+func recordHistory(c *cli.Context, res *result) {
+	if c.Bool("dry-run") || res.Output == "" || res.Error != "" || res.Skipped {
+		return
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	db, err := openHistory()
+	if err != nil {
+		log.Warningf("Could not open history: %v", err)
+		return
+	}
+	defer db.Close()
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		entry := historyEntry{
+			ID:   id,
+			Time: time.Now(),
+			Settings: map[string]interface{}{
+				"quality": c.Int("quality"),
+				"frames":  c.Int("frames"),
+				"width":   c.Int("width"),
+				"bucket":  c.String("bucket"),
+				"profile": c.String("profile"),
+				"start":   c.String("start"),
+				"end":     c.String("end"),
+			},
+			result: *res,
+		}
+		if abs, err := filepath.Abs(entry.Source); err == nil {
+			entry.Source = abs
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put(historyKey(id), data)
+	})
+	if err != nil {
+		log.Warningf("Could not record history: %v", err)
+	}
+}
+
+// readHistory returns the recorded entries for which keep returns true,
+// newest first, stopping after limit entries when limit > 0.  Failed
+// conversions older versions recorded are left out.
+func readHistory(limit int, keep func(e *historyEntry) bool) ([]*historyEntry, error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	db, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var entries []*historyEntry
+	err = db.View(func(tx *bolt.Tx) error {
+		cur := tx.Bucket(historyBucket).Cursor()
+		for k, v := cur.Last(); k != nil; k, v = cur.Prev() {
+			e := &historyEntry{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			if e.Error != "" || keep != nil && !keep(e) {
+				continue
+			}
+			entries = append(entries, e)
+			if limit > 0 && len(entries) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// historyByID looks up the entry named by the first argument.
+func historyByID(c *cli.Context) (*historyEntry, error) {
+	id, err := strconv.ParseUint(c.Args().First(), 10, 64)
+	if err != nil {
+		return nil, exitError(exitUsage, fmt.Errorf("usage: ggif history %s <id>", c.Command.Name))
+	}
+	entries, err := readHistory(1, func(e *historyEntry) bool { return e.ID == id })
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no history entry %d", id)
+	}
+	return entries[0], nil
+}
+
+// printHistory prints entries as a table, or as json with --json.
+func printHistory(c *cli.Context, entries []*historyEntry) {
+	if c.Bool("json") {
+		if entries == nil {
+			entries = []*historyEntry{}
+		}
+		printJSON(entries)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tWHEN\tSOURCE\tSIZE\tURL")
+	for _, e := range entries {
+		url := "-"
+		if len(e.URLs) > 0 {
+			url = e.URLs[0]
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+			e.ID, e.Time.Format("2006-01-02 15:04"), filepath.Base(e.Source), humanSize(e.Size), url)
+	}
+	w.Flush()
+}
+
+func historyList(c *cli.Context) error {
+	entries, err := readHistory(c.Int("limit"), nil)
+	if err != nil {
+		return err
+	}
+	printHistory(c, entries)
+	return nil
+}
+
+func historySearch(c *cli.Context) error {
+	if c.Args().Len() == 0 {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif history search <text>"))
+	}
+	query := strings.ToLower(strings.Join(c.Args().Slice(), " "))
+	entries, err := readHistory(c.Int("limit"), func(e *historyEntry) bool {
+		fields := append([]string{e.Source, e.Output, e.SHA256, e.SourceSHA256}, e.URLs...)
+		return strings.Contains(strings.ToLower(strings.Join(fields, "\n")), query)
+	})
+	if err != nil {
+		return err
+	}
+	printHistory(c, entries)
+	return nil
+}
+
+func historyShow(c *cli.Context) error {
+	e, err := historyByID(c)
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		printJSON(e)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id\t%d\n", e.ID)
+	fmt.Fprintf(w, "when\t%s\n", e.Time.Format(time.RFC1123))
+	fmt.Fprintf(w, "source\t%s\n", e.Source)
+	if e.SourceSHA256 != "" {
+		fmt.Fprintf(w, "source sha256\t%s\n", e.SourceSHA256)
+	}
+	fmt.Fprintf(w, "output\t%s\n", e.Output)
+	fmt.Fprintf(w, "size\t%s\n", humanSize(e.Size))
+	fmt.Fprintf(w, "sha256\t%s\n", e.SHA256)
+	for _, key := range sortedKeys(e.Settings) {
+		fmt.Fprintf(w, "%s\t%v\n", key, e.Settings[key])
+	}
+	for _, url := range e.URLs {
+		fmt.Fprintf(w, "url\t%s\n", url)
+	}
+	w.Flush()
+	return nil
+}
+
+// historyCopy puts the url of a past upload back on the clipboard.
+func historyCopy(c *cli.Context) error {
+	e, err := historyByID(c)
+	if err != nil {
+		return err
+	}
+	if len(e.URLs) == 0 {
+		return fmt.Errorf("%s was never uploaded", e.Output)
+	}
+	fmt.Println(e.URLs[0])
+	return copyURL(e.URLs[0])
+}
+
+var historyLimitFlag = &cli.IntFlag{
+	Name:  "limit",
+	Value: 20,
+	Usage: "show at most this many entries, 0 for all",
+}
+
+var historyCommand = &cli.Command{
+	Name:  "history",
+	Usage: "list, search and re-copy past conversions",
+	Description: `Examples:
+      ggif history list --limit 5
+      ggif history search standup
+      ggif history show 42
+      ggif history copy 42`,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "print the most recent conversions",
+			Action: historyList,
+			Flags:  []cli.Flag{historyLimitFlag},
+		},
+		{
+			Name:      "show",
+			Usage:     "print everything recorded about a conversion",
+			ArgsUsage: "<id>",
+			Action:    historyShow,
+		},
+		{
+			Name:      "search",
+			Usage:     "find conversions by source, output, checksum or url",
+			ArgsUsage: "<text>",
+			Action:    historySearch,
+			Flags:     []cli.Flag{historyLimitFlag},
+		},
+		{
+			Name:      "copy",
+			Usage:     "copy the url of a past upload to the clipboard again",
+			ArgsUsage: "<id>",
+			Action:    historyCopy,
+		},
+	},
+}
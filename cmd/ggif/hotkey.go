@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// hotkeyArgs is what a hotkey runs: convert the newest recording in the
+// src folder and upload it, leaving the url in the clipboard.
+var hotkeyArgs = []string{"--newest", "--quiet"}
+
+// hotkeyBegin and hotkeyEnd surround the binding in a file shared with the
+// user's own, so installing again replaces it.
+const (
+	hotkeyBegin = "ggif hotkey begin"
+	hotkeyEnd   = "ggif hotkey end"
+)
+
+// hotkey is a key with modifiers, as in super+shift+g.
+type hotkey struct {
+	mods []string
+	key  string
+}
+
+func parseHotkey(s string) (*hotkey, error) {
+	parts := strings.Split(strings.ToLower(strings.ReplaceAll(s, " ", "")), "+")
+	h := &hotkey{key: parts[len(parts)-1]}
+	for _, mod := range parts[:len(parts)-1] {
+		switch mod {
+		case "super", "ctrl", "alt", "shift":
+			h.mods = append(h.mods, mod)
+		default:
+			return nil, fmt.Errorf("bad hotkey %q, modifiers are super, ctrl, alt and shift", s)
+		}
+	}
+	if h.key == "" {
+		return nil, fmt.Errorf("bad hotkey %q, it needs a key", s)
+	}
+	return h, nil
+}
+
+// hotkeyTool is a hotkey daemon ggif can write a binding for.
+type hotkeyTool struct {
+	// file is where the binding goes, relative to the folder base returns
+	base    func() (string, error)
+	file    string
+	comment string
+	binding func(h *hotkey, exe string) string
+	reload  string
+}
+
+var hotkeyTools = map[string]hotkeyTool{
+	"sxhkd": {
+		base:    os.UserConfigDir,
+		file:    filepath.Join("sxhkd", "sxhkdrc"),
+		comment: "#",
+		binding: func(h *hotkey, exe string) string {
+			keys := append(append([]string{}, h.mods...), h.key)
+			args := append([]string{shellQuote(exe)}, hotkeyArgs...)
+			return strings.Join(keys, " + ") + "\n\t" + strings.Join(args, " ") + "\n"
+		},
+		reload: "pkill -USR1 -x sxhkd",
+	},
+	"hammerspoon": {
+		base:    os.UserHomeDir,
+		file:    filepath.Join(".hammerspoon", "init.lua"),
+		comment: "--",
+		binding: func(h *hotkey, exe string) string {
+			mods := make([]string, len(h.mods))
+			for i, mod := range h.mods {
+				if mod == "super" {
+					mod = "cmd"
+				}
+				mods[i] = fmt.Sprintf("%q", mod)
+			}
+			args := make([]string, len(hotkeyArgs))
+			for i, arg := range hotkeyArgs {
+				args[i] = fmt.Sprintf("%q", arg)
+			}
+			return fmt.Sprintf(`hs.hotkey.bind({%s}, %q, function()
+  hs.task.new(%q, function(code)
+    hs.notify.show("ggif", "", code == 0 and "url copied" or "conversion failed")
+  end, {%s}):start()
+end)
+`, strings.Join(mods, ", "), h.key, exe, strings.Join(args, ", "))
+		},
+		reload: "reload the Hammerspoon config",
+	},
+	"autohotkey": {
+		// runs at login from the startup folder
+		base:    os.UserConfigDir,
+		file:    filepath.Join("Microsoft", "Windows", "Start Menu", "Programs", "Startup", "ggif.ahk"),
+		comment: ";",
+		binding: func(h *hotkey, exe string) string {
+			prefixes := map[string]string{"super": "#", "ctrl": "^", "alt": "!", "shift": "+"}
+			var keys string
+			for _, mod := range h.mods {
+				keys += prefixes[mod]
+			}
+			return fmt.Sprintf("#Requires AutoHotkey v2.0\n%s%s::Run('\"%s\" %s', , \"Hide\")\n",
+				keys, h.key, exe, strings.Join(hotkeyArgs, " "))
+		},
+		reload: "run the script, or log in again",
+	},
+}
+
+// defaultHotkeyTool is the hotkey daemon usually found on this platform.
+func defaultHotkeyTool() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "hammerspoon"
+	case "windows":
+		return "autohotkey"
+	}
+	return "sxhkd"
+}
+
+// replaceBlock puts block between the begin and end markers in contents,
+// replacing what was there or else appending it.
+func replaceBlock(contents string, comment string, block string) string {
+	begin := comment + " " + hotkeyBegin + "\n"
+	end := comment + " " + hotkeyEnd + "\n"
+	block = begin + block + end
+
+	i := strings.Index(contents, begin)
+	j := strings.Index(contents, end)
+	if i >= 0 && j > i {
+		return contents[:i] + block + contents[j+len(end):]
+	}
+	if contents != "" && !strings.HasSuffix(contents, "\n") {
+		contents += "\n"
+	}
+	if contents != "" {
+		contents += "\n"
+	}
+	return contents + block
+}
+
+// hotkeyBinding returns the tool and the binding --tool and --key ask for.
+func hotkeyBinding(c *cli.Context) (hotkeyTool, string, error) {
+	name := c.String("tool")
+	if name == "" {
+		name = defaultHotkeyTool()
+	}
+	tool, ok := hotkeyTools[name]
+	if !ok {
+		return tool, "", exitError(exitUsage, fmt.Errorf("unknown tool %q, known: sxhkd, hammerspoon, autohotkey", name))
+	}
+	h, err := parseHotkey(c.String("key"))
+	if err != nil {
+		return tool, "", exitError(exitUsage, err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return tool, "", err
+	}
+	return tool, tool.binding(h, exe), nil
+}
+
+func hotkeyPrint(c *cli.Context) error {
+	_, binding, err := hotkeyBinding(c)
+	if err != nil {
+		return err
+	}
+	fmt.Print(binding)
+	return nil
+}
+
+func hotkeyInstall(c *cli.Context) error {
+	tool, binding, err := hotkeyBinding(c)
+	if err != nil {
+		return err
+	}
+	base, err := tool.base()
+	if err != nil {
+		return err
+	}
+	fname := filepath.Join(base, tool.file)
+	contents, err := ioutil.ReadFile(fname)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	updated := replaceBlock(string(contents), tool.comment, binding)
+	if err := ioutil.WriteFile(fname, []byte(updated), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Added %s to %s, %s to use it\n", c.String("key"), fname, tool.reload)
+	return nil
+}
+
+var hotkeyFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "tool",
+		Usage: "hotkey daemon to bind with: sxhkd, hammerspoon or autohotkey (default: the usual one for this platform)",
+	},
+	&cli.StringFlag{
+		Name:  "key",
+		Value: "super+shift+g",
+		Usage: "key to bind, with super, ctrl, alt or shift",
+	},
+}
+
+var hotkeyCommand = &cli.Command{
+	Name:  "hotkey",
+	Usage: "bind a global hotkey that converts and uploads the newest recording",
+	Description: `ggif doesn't grab keys itself; it writes a binding for the hotkey daemon of
+   the platform that runs ggif --newest --quiet, which leaves the url in the
+   clipboard.
+
+   Examples:
+      ggif hotkey install
+      ggif hotkey install --tool hammerspoon --key ctrl+alt+g
+      ggif hotkey print --tool sxhkd`,
+	Subcommands: []*cli.Command{
+		{
+			Name:   "install",
+			Usage:  "add the binding to the daemon's config, replacing one added before",
+			Action: hotkeyInstall,
+			Flags:  hotkeyFlags,
+		},
+		{
+			Name:   "print",
+			Usage:  "print the binding to add by hand",
+			Action: hotkeyPrint,
+			Flags:  hotkeyFlags,
+		},
+	},
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// prompt asks a question on stdout and reads the answer from in, returning
+// def when the answer is empty.
+func prompt(in *bufio.Reader, question string, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, _ := in.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// promptInt keeps asking until the answer is a whole number in [min, max].
+func promptInt(in *bufio.Reader, question string, def int, min int, max int) int {
+	for {
+		answer := prompt(in, question, strconv.Itoa(def))
+		i, err := strconv.Atoi(answer)
+		if err == nil && i >= min && i <= max {
+			return i
+		}
+		fmt.Printf("  please enter a number between %d and %d\n", min, max)
+	}
+}
+
+func promptYes(in *bufio.Reader, question string) bool {
+	answer := prompt(in, question+" (y/N)", "")
+	return strings.HasPrefix(strings.ToLower(answer), "y")
+}
+
+// checkTool reports whether an external program is on the PATH.
+func checkTool(name string, hint string) bool {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		fmt.Printf("  ✗ %s not found, %s\n", name, hint)
+		return false
+	}
+	fmt.Printf("  ✓ %s (%s)\n", name, path)
+	return true
+}
+
+func initConfig(c *cli.Context) error {
+	in := bufio.NewReader(os.Stdin)
+
+	fname, err := configFileForWriting(c)
+	if err != nil {
+		return err
+	}
+	if fileExists(fname) && !promptYes(in, fmt.Sprintf("%s already exists, overwrite it?", fname)) {
+		return nil
+	}
+
+	fmt.Println("Checking for required tools:")
+	checkTool("ffmpeg", "install it from https://ffmpeg.org")
+	checkTool("gifski", "install it from https://gif.ski")
+	fmt.Println()
+
+	values := make(map[string]interface{})
+
+	fmt.Println("Gifs can be uploaded to a google cloud storage bucket, leave empty to keep them local.")
+	bucket := prompt(in, "Bucket", c.String("bucket"))
+	if bucket != "" {
+		values["bucket"] = bucket
+		if checkTool("gsutil", "install the google cloud sdk from https://cloud.google.com/sdk") {
+			fmt.Printf("  testing access to gs://%s ... ", bucket)
+			out, err := exec.Command("gsutil", gsutilArgs(c, "ls", "-b", fmt.Sprintf("gs://%s", bucket))...).CombinedOutput()
+			if err != nil {
+				fmt.Printf("failed\n  %s\n", strings.TrimSpace(string(out)))
+				fmt.Println("  run `gcloud auth login` or check the bucket name, the config is written anyway")
+			} else {
+				fmt.Println("ok")
+			}
+		}
+	}
+	fmt.Println()
+
+	values["width"] = promptInt(in, "Gif width in pixels", c.Int("width"), 1, 10000)
+	values["frames"] = promptInt(in, "Frames per second", c.Int("frames"), 1, 100)
+	values["quality"] = promptInt(in, "Quality (1-100)", c.Int("quality"), 1, 100)
+	fmt.Println()
+
+	src, _ := filepath.Abs(prompt(in, "Folder your screen recordings are saved to", c.String("src")))
+	values["src"] = src
+	if dist := prompt(in, "Folder to save gifs to (empty for the recordings folder)", c.String("dist")); dist != "" {
+		values["dist"], _ = filepath.Abs(dist)
+	}
+
+	data, err := encodeConfigFile(fname, values)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(fname, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("\nWrote %s\n", fname)
+	return nil
+}
+
+var initCommand = &cli.Command{
+	Name:  "init",
+	Usage: "interactively create the config file",
+	Description: `Asks for the bucket, folders and gif settings, checks the tools ggif
+   needs and writes the config file (or the one given with --load).`,
+	Action: initConfig,
+}
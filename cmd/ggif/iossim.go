@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// captureSimulator records the screen of an iOS simulator with simctl until
+// enter is pressed or --duration is up.
+func captureSimulator(c *cli.Context) (string, error) {
+	if runtime.GOOS != "darwin" {
+		return "", exitError(exitUsage, fmt.Errorf("the iOS simulator only runs on macOS"))
+	}
+	duration, err := recordingDuration(c)
+	if err != nil {
+		return "", err
+	}
+
+	outfn := recordingName(c, ".mov")
+	cmd := exec.Command("xcrun", "simctl", "io", c.String("simulator"), "recordVideo", "--codec=h264", "--force", outfn)
+	if c.Bool("dry-run") {
+		fmt.Fprintln(os.Stderr, shellJoin(cmd.Args))
+		return outfn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(outfn), 0755); err != nil {
+		return "", exitError(exitUsage, err)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	log.Debug(cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return "", exitError(exitDependency, fmt.Errorf("xcrun: %w", err))
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	if ok, err := waitRecording(c, done, duration, outfn); !ok {
+		if c.Context.Err() != nil {
+			cmd.Process.Kill()
+			<-done
+			os.Remove(outfn)
+			return "", err
+		}
+		// no simulator is booted, or the one asked for isn't
+		if err == nil {
+			err = fmt.Errorf("stopped before it was asked to")
+		}
+		if last := lastLine(output.String()); last != "" {
+			return "", exitError(exitDependency, fmt.Errorf("simctl: %w: %s", err, last))
+		}
+		return "", exitError(exitDependency, fmt.Errorf("simctl: %w", err))
+	}
+
+	// simctl finishes the file on an interrupt, like ctrl-c
+	cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+	if !fileExists(outfn) {
+		return "", exitError(exitDependency, fmt.Errorf("simctl wrote no recording: %s", lastLine(output.String())))
+	}
+	return outfn, nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// jobLogTTL is how long the logs of jobs are kept.
+const jobLogTTL = 14 * 24 * time.Hour
+
+// jobLogDir is the folder in the data folder job logs are kept in.
+func jobLogDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "logs"), nil
+}
+
+// jobLog is the log file of one conversion of a watch, server or worker:
+// the stages it went through and the commands it ran with their output.
+// Its methods do nothing on a nil jobLog, for jobs whose log could not be
+// opened.
+type jobLog struct {
+	mu   sync.Mutex
+	id   string
+	file *os.File
+}
+
+type jobLogKey struct{}
+
+// jobLogSweep makes sure old job logs are only looked for once a run.
+var jobLogSweep sync.Once
+
+// sweepJobLogs removes the job logs older than jobLogTTL.
+func sweepJobLogs(dir string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, fi := range entries {
+		if strings.HasSuffix(fi.Name(), ".log") && time.Since(fi.ModTime()) > jobLogTTL {
+			os.Remove(filepath.Join(dir, fi.Name()))
+		}
+	}
+}
+
+// startJobLog opens the log of job id converting videoFile and returns ctx
+// carrying it, so the commands the conversion runs end up in it.  Failing
+// to open it is logged, never fatal.
+func startJobLog(ctx context.Context, id string, videoFile string) (context.Context, *jobLog) {
+	if !validJobID(id, false) {
+		log.Warningf("job %q: no log, the id isn't one ggif made", id)
+		return ctx, nil
+	}
+	dir, err := jobLogDir()
+	if err == nil {
+		jobLogSweep.Do(func() { sweepJobLogs(dir) })
+		err = os.MkdirAll(dir, 0755)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.OpenFile(filepath.Join(dir, id+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	}
+	if err != nil {
+		log.Warningf("job %s: no log: %v", id, err)
+		return ctx, nil
+	}
+	l := &jobLog{id: id, file: f}
+	l.printf("start %s", videoFile)
+	return context.WithValue(ctx, jobLogKey{}, l), l
+}
+
+// jobLogOf returns the log of the job ctx belongs to, or nil.
+func jobLogOf(ctx context.Context) *jobLog {
+	l, _ := ctx.Value(jobLogKey{}).(*jobLog)
+	return l
+}
+
+// printf adds a line with the time to the log.
+func (l *jobLog) printf(format string, a ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.file, "%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, a...))
+}
+
+// stage records that the job reached stage.
+func (l *jobLog) stage(stage string) {
+	l.printf("stage %s", stage)
+}
+
+// command adds a command the job ran, with its output indented below it.
+func (l *jobLog) command(args []string, output []byte, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.file, "$ %s\n", shellJoin(args))
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(l.file, "  %s\n", strings.TrimRight(line, "\r"))
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(l.file, "  (%v)\n", err)
+	}
+}
+
+// finish records the outcome of the job and closes the log.
+func (l *jobLog) finish(res *result, err error) {
+	if l == nil {
+		return
+	}
+	switch {
+	case err != nil:
+		l.printf("failed %v", err)
+	case len(res.URLs) > 0:
+		l.printf("done %s", res.URLs[0])
+	default:
+		l.printf("done %s", res.Output)
+	}
+	l.file.Close()
+}
+
+// logCommand adds a command run for ctx to its job's log, if it has one.
+func logCommand(ctx context.Context, args []string, output []byte, err error) {
+	jobLogOf(ctx).command(args, output, err)
+}
+
+// jobLogSummary reads the video and outcome of a job from its log.
+func jobLogSummary(path string) (video string, status string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	status = "unfinished"
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// the lines of the job itself start with the time
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) < 2 || fields[0] == "$" || fields[0] == "" {
+			continue
+		}
+		switch fields[1] {
+		case "start":
+			if video == "" && len(fields) == 3 {
+				video = fields[2]
+			}
+		case "done", "failed":
+			status = fields[1]
+		}
+	}
+	return video, status
+}
+
+// findJobLog returns the log of the job whose id is or starts with id.
+func findJobLog(dir string, id string) (string, error) {
+	if !validJobID(id, true) {
+		return "", exitError(exitUsage, fmt.Errorf("bad job id %q", id))
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, id+"*.log"))
+	switch len(matches) {
+	case 0:
+		return "", exitError(exitUsage, fmt.Errorf("no log for job %s, they are kept for %d days", id, jobLogTTL/(24*time.Hour)))
+	case 1:
+		return matches[0], nil
+	}
+	return "", exitError(exitUsage, fmt.Errorf("%d jobs start with %s, give more of the id", len(matches), id))
+}
+
+// listJobLogs prints the latest jobs with a log, newest first.
+func listJobLogs(dir string, limit int) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var logs []os.FileInfo
+	for _, fi := range entries {
+		if strings.HasSuffix(fi.Name(), ".log") {
+			logs = append(logs, fi)
+		}
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].ModTime().After(logs[j].ModTime())
+	})
+	if limit > 0 && len(logs) > limit {
+		logs = logs[:limit]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "JOB\tWHEN\tSTATUS\tVIDEO")
+	for _, fi := range logs {
+		video, status := jobLogSummary(filepath.Join(dir, fi.Name()))
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			strings.TrimSuffix(fi.Name(), ".log"), fi.ModTime().Format("2006-01-02 15:04"), status, video)
+	}
+	return nil
+}
+
+func showJobLog(c *cli.Context) error {
+	dir, err := jobLogDir()
+	if err != nil {
+		return err
+	}
+	if c.NArg() == 0 {
+		return listJobLogs(dir, c.Int("limit"))
+	}
+	path, err := findJobLog(dir, c.Args().First())
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+var logsCommand = &cli.Command{
+	Name:      "logs",
+	Usage:     "show what a conversion of ggif watch, serve or worker ran and printed",
+	ArgsUsage: "[job-id]",
+	Description: `Every conversion of a watch, a server or a worker gets a log in the data
+   folder with its stages and the ffmpeg, gifski and upload commands it ran
+   along with their output.  Without a job id the latest jobs are listed;
+   the start of an id is enough.  Logs are removed after two weeks.
+
+   Examples:
+      ggif logs
+      ggif logs 3fa9c2`,
+	Action: showJobLog,
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "limit",
+			Value: 20,
+			Usage: "how many jobs to list",
+		},
+	},
+}
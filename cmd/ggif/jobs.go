@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// jobTTL is how long finished jobs can still be looked up.
+const jobTTL = time.Hour
+
+// maxPending is how many jobs of each priority can wait for a worker
+// before new ones are turned away, so a backlog of watched recordings
+// never keeps out a one-off.
+const maxPending = 256
+
+// jobPriorities are the priorities a job can be queued with, most urgent
+// first.  Workers always take the most urgent job waiting, so a one-off
+// queued with high priority goes ahead of a backlog of watched recordings
+// at low.
+var jobPriorities = []string{"high", "normal", "low"}
+
+// stageProgress is roughly how far along a conversion is once a stage
+// starts, for progress bars.
+var stageProgress = map[string]int{
+	"queued": 0,
+	"frames": 5,
+	"gif":    40,
+	"upload": 80,
+	"done":   100,
+}
+
+// job is a conversion started through the api, tracked until it finishes.
+type job struct {
+	ID       string    `json:"id"`
+	Status   string    `json:"status"` // queued, running, done or failed
+	Stage    string    `json:"stage"`
+	Progress int       `json:"progress"`
+	Priority string    `json:"priority"`
+	Created  time.Time `json:"created"`
+	// Worker names the remote worker converting the job.
+	Worker string  `json:"worker,omitempty"`
+	Result *result `json:"result,omitempty"`
+	Error  string  `json:"error,omitempty"`
+
+	subscribers []chan job
+	// err is the error behind Error, with its exit code.
+	err error
+	// video is the file to convert, in dir, which is removed once the job
+	// is finished.
+	video string
+	dir   string
+	// ctx is cancelled when the job is, or the server shuts down.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// lease fails the job when the remote worker that claimed it doesn't
+	// report back in time.
+	lease *time.Timer
+	// process converts video on this machine, processWith unless the job
+	// came with its own, e.g. to read sidecars.
+	process func(ctx context.Context, c *cli.Context, videoFile string, onStage func(stage string)) (*result, error)
+}
+
+func (j *job) finished() bool {
+	return j.Status == "done" || j.Status == "failed"
+}
+
+// convertSlots bounds how many conversions run on this machine at once,
+// whichever api asked for them.
+type convertSlots chan struct{}
+
+func newConvertSlots(n int) convertSlots {
+	return make(convertSlots, n)
+}
+
+// acquire waits for a free slot.  It returns false when ctx is done first
+// or there are no slots at all.
+func (s convertSlots) acquire(ctx context.Context) bool {
+	if cap(s) == 0 {
+		return false
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s convertSlots) release() {
+	<-s
+}
+
+// jobQueue hands jobs to local and remote workers and keeps their state
+// for polling.
+type jobQueue struct {
+	mu   sync.Mutex
+	ctx  context.Context
+	jobs map[string]*job
+	// pending holds the jobs waiting for a worker, a channel for each of
+	// jobPriorities.
+	pending []chan *job
+	workers int
+	// slots are shared by the local workers and the gifs streamed back
+	// by POST /convert, so together they stay within --workers.
+	slots convertSlots
+	// tmpDir holds the videos of queued jobs.
+	tmpDir string
+}
+
+// newJobQueue starts workers local conversions at a time; with none, jobs
+// wait for ggif worker to claim them.
+func newJobQueue(c *cli.Context, workers int) *jobQueue {
+	q := &jobQueue{
+		ctx:     c.Context,
+		jobs:    make(map[string]*job),
+		pending: make([]chan *job, len(jobPriorities)),
+		workers: workers,
+		slots:   newConvertSlots(workers),
+		tmpDir:  tempDir(c),
+	}
+	for i := range q.pending {
+		q.pending[i] = make(chan *job, maxPending)
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				j, _ := q.next(nil)
+				if !q.slots.acquire(j.ctx) {
+					q.finish(j, nil, j.ctx.Err())
+					continue
+				}
+				q.convert(c, j)
+				q.slots.release()
+			}
+		}()
+	}
+	return q
+}
+
+// priorityLevel returns the index of priority in jobPriorities.
+func priorityLevel(priority string) (int, bool) {
+	for i, p := range jobPriorities {
+		if p == priority {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// next takes the most urgent pending job, waiting until one is queued or
+// done is closed.
+func (q *jobQueue) next(done <-chan struct{}) (*job, bool) {
+	for _, ch := range q.pending {
+		select {
+		case j := <-ch:
+			return j, true
+		default:
+		}
+	}
+	// nothing was waiting, so whichever comes first is the most urgent
+	cases := make([]reflect.SelectCase, 0, len(q.pending)+1)
+	for _, ch := range q.pending {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)})
+	}
+	cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)})
+	chosen, j, _ := reflect.Select(cases)
+	if chosen == len(q.pending) {
+		return nil, false
+	}
+	return j.Interface().(*job), true
+}
+
+func newJobID() string {
+	b := make([]byte, jobIDBytes)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// jobIDBytes is how many random bytes a job id has, twice as many hex
+// digits.
+const jobIDBytes = 8
+
+// validJobID reports whether id is one newJobID could have made, or with
+// prefix the start of one, so it is safe in file names and urls.
+func validJobID(id string, prefix bool) bool {
+	if id == "" || len(id) > 2*jobIDBytes || !prefix && len(id) != 2*jobIDBytes {
+		return false
+	}
+	for _, r := range id {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// update changes a job under the lock and sends the new state to everyone
+// following it.  Finished jobs are left alone.
+func (q *jobQueue) update(j *job, fn func(j *job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if j.finished() {
+		return
+	}
+	fn(j)
+	state := *j
+	state.subscribers = nil
+	for _, ch := range j.subscribers {
+		// a slow reader misses intermediate states, never the last one
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- state
+	}
+	if j.finished() {
+		for _, ch := range j.subscribers {
+			close(ch)
+		}
+		j.subscribers = nil
+		time.AfterFunc(jobTTL, func() {
+			q.mu.Lock()
+			delete(q.jobs, j.ID)
+			q.mu.Unlock()
+		})
+	}
+}
+
+// get returns a copy of the job's current state.
+func (q *jobQueue) get(id string) (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	state := *j
+	state.subscribers = nil
+	return state, true
+}
+
+// depth returns how many jobs are waiting and how many are converting.
+func (q *jobQueue) depth() (queued int, running int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, j := range q.jobs {
+		switch j.Status {
+		case "queued":
+			queued++
+		case "running":
+			running++
+		}
+	}
+	return queued, running
+}
+
+// subscribe returns the job's current state and a channel of the states
+// that follow, closed once the job is finished.
+func (q *jobQueue) subscribe(id string) (job, <-chan job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, nil, false
+	}
+	state := *j
+	state.subscribers = nil
+	ch := make(chan job, 1)
+	if j.finished() {
+		close(ch)
+	} else {
+		j.subscribers = append(j.subscribers, ch)
+	}
+	return state, ch, true
+}
+
+// lookup returns the job itself, for the workers updating it.
+func (q *jobQueue) lookup(id string) (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	j, ok := q.jobs[id]
+	return j, ok
+}
+
+// start queues a conversion of videoFile with one of jobPriorities,
+// removing dir once it is done.
+func (q *jobQueue) start(videoFile string, dir string, priority string) (job, error) {
+	return q.add(q.ctx, &job{video: videoFile, dir: dir}, priority)
+}
+
+// add queues j with one of jobPriorities, to be cancelled along with ctx.
+func (q *jobQueue) add(ctx context.Context, j *job, priority string) (job, error) {
+	level, ok := priorityLevel(priority)
+	if !ok {
+		return job{}, fmt.Errorf("unknown priority %q", priority)
+	}
+	j.ID = newJobID()
+	j.Status = "queued"
+	j.Stage = "queued"
+	j.Priority = priority
+	j.Created = time.Now()
+	j.ctx, j.cancel = context.WithCancel(ctx)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	select {
+	case q.pending[level] <- j:
+	default:
+		j.cancel()
+		return job{}, fmt.Errorf("%d jobs are already waiting at %s priority, try again later", maxPending, priority)
+	}
+	q.jobs[j.ID] = j
+	state := *j
+	return state, nil
+}
+
+// wait follows a job until it finishes and returns its last state.  It
+// returns false when ctx is done first.
+func (q *jobQueue) wait(ctx context.Context, id string) (job, bool) {
+	state, updates, ok := q.subscribe(id)
+	if !ok {
+		return job{}, false
+	}
+	for {
+		select {
+		case j, ok := <-updates:
+			if !ok {
+				return state, true
+			}
+			state = j
+		case <-ctx.Done():
+			return state, false
+		}
+	}
+}
+
+// begin marks a job taken from pending as running on worker, "" for this
+// machine.  It returns false for jobs cancelled while they waited.
+func (q *jobQueue) begin(j *job, worker string) bool {
+	started := false
+	q.update(j, func(j *job) {
+		j.Status = "running"
+		j.Worker = worker
+		started = true
+	})
+	return started
+}
+
+// setStage records that the job reached stage.  It returns false once the
+// job is finished, e.g. because it was cancelled.
+func (q *jobQueue) setStage(j *job, stage string) bool {
+	updated := false
+	q.update(j, func(j *job) {
+		j.Stage = stage
+		if progress, ok := stageProgress[stage]; ok {
+			// the palette stage keeps the progress of the gif stage
+			j.Progress = progress
+		}
+		updated = true
+	})
+	return updated
+}
+
+// stopLease stops the lease of a job claimed by a remote worker, called
+// with the lock held.
+func (j *job) stopLease() {
+	if j.lease != nil {
+		j.lease.Stop()
+		j.lease = nil
+	}
+}
+
+// requeue puts a job claimed by a remote worker back in line, for when the
+// worker never got it.  A job that no longer fits in the queue is failed.
+func (q *jobQueue) requeue(j *job) {
+	level, _ := priorityLevel(j.Priority)
+	requeued := false
+	q.update(j, func(j *job) {
+		j.stopLease()
+		j.Status = "queued"
+		j.Worker = ""
+		select {
+		case q.pending[level] <- j:
+			requeued = true
+		default:
+			j.Status = "failed"
+			j.err = fmt.Errorf("the worker that claimed it went away and %d jobs are waiting at %s priority", maxPending, j.Priority)
+			j.Error = j.err.Error()
+		}
+	})
+	if !requeued {
+		j.cancel()
+		os.RemoveAll(j.dir)
+	}
+}
+
+// finish records the outcome of a job and removes its video.
+func (q *jobQueue) finish(j *job, res *result, err error) {
+	q.update(j, func(j *job) {
+		j.stopLease()
+		j.Result = res
+		if err != nil {
+			j.Status = "failed"
+			j.Error = err.Error()
+			j.err = err
+			return
+		}
+		j.Status = "done"
+		j.Stage = "done"
+		j.Progress = stageProgress["done"]
+	})
+	j.cancel()
+	os.RemoveAll(j.dir)
+}
+
+// abort fails a job that is still queued or running and stops its
+// conversion.  It returns false when the job had already finished.
+func (q *jobQueue) abort(j *job) bool {
+	aborted := false
+	q.update(j, func(j *job) {
+		j.stopLease()
+		j.Status = "failed"
+		j.Error = "cancelled"
+		j.err = context.Canceled
+		aborted = true
+	})
+	j.cancel()
+	os.RemoveAll(j.dir)
+	return aborted
+}
+
+// convert runs a job on this machine.
+func (q *jobQueue) convert(c *cli.Context, j *job) {
+	if !q.begin(j, "") {
+		return
+	}
+	process := j.process
+	if process == nil {
+		process = processWith
+	}
+	ctx, jl := startJobLog(j.ctx, j.ID, j.video)
+	res, err := process(ctx, c, j.video, func(stage string) {
+		jl.stage(stage)
+		q.setStage(j, stage)
+	})
+	jl.finish(res, err)
+	q.finish(j, res, err)
+}
+
+// jobsHandler serves POST /jobs to queue a conversion, GET /jobs/{id} for
+// its state, GET /jobs/{id}/events for a stream of server-sent events and
+// DELETE /jobs/{id} to cancel it.
+func jobsHandler(q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		switch {
+		case rest == "" && r.Method == http.MethodPost:
+			createJob(q, w, r)
+		case rest != "" && r.Method == http.MethodGet:
+			parts := strings.Split(rest, "/")
+			switch {
+			case len(parts) == 1:
+				j, ok := q.get(parts[0])
+				if !ok {
+					writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", parts[0]))
+					return
+				}
+				writeJSON(w, http.StatusOK, j)
+			case len(parts) == 2 && parts[1] == "events":
+				streamJob(q, parts[0], w, r)
+			default:
+				http.NotFound(w, r)
+			}
+		case rest != "" && !strings.Contains(rest, "/") && r.Method == http.MethodDelete:
+			j, ok := q.lookup(rest)
+			if !ok {
+				writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", rest))
+				return
+			}
+			if !q.abort(j) {
+				writeError(w, http.StatusConflict, fmt.Errorf("job %s already finished", rest))
+				return
+			}
+			log.Infof("%s: job %s cancelled", r.RemoteAddr, rest)
+			state, _ := q.get(rest)
+			writeJSON(w, http.StatusOK, state)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("POST /jobs, GET /jobs/{id}[/events] or DELETE /jobs/{id}"))
+		}
+	}
+}
+
+// queueVideo stores the video of a request and queues its conversion with
+// the priority the request asks for, or else priority, writing the error
+// response when that fails.
+func queueVideo(q *jobQueue, w http.ResponseWriter, r *http.Request, priority string) (job, bool) {
+	if p := r.URL.Query().Get("priority"); p != "" {
+		priority = p
+	}
+	if _, ok := priorityLevel(priority); !ok {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("priority must be one of %s, got %q", strings.Join(jobPriorities, ", "), priority))
+		return job{}, false
+	}
+
+	dir, err := makeTempDir(q.tmpDir, "job")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return job{}, false
+	}
+
+	videoFile, err := receiveVideo(w, r, dir)
+	if err == nil && !isRecording(videoFile) {
+		err = exitError(exitUsage, fmt.Errorf("not a video file or asciinema recording"))
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, httpStatus(err), err)
+		return job{}, false
+	}
+
+	j, err := q.start(videoFile, dir, priority)
+	if err != nil {
+		os.RemoveAll(dir)
+		writeError(w, http.StatusServiceUnavailable, err)
+		return job{}, false
+	}
+	log.Infof("%s: job %s converting %s at %s priority", r.RemoteAddr, j.ID, videoFile, priority)
+	return j, true
+}
+
+func createJob(q *jobQueue, w http.ResponseWriter, r *http.Request) {
+	j, ok := queueVideo(q, w, r, "high")
+	if !ok {
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// streamJob sends the job's state as server-sent events until it finishes
+// or the client goes away.
+func streamJob(q *jobQueue, id string, w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	state, updates, ok := q.subscribe(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	send := func(j job) {
+		data, _ := json.Marshal(j)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", j.Status, data)
+		flusher.Flush()
+	}
+
+	send(state)
+	for {
+		select {
+		case j, ok := <-updates:
+			if !ok {
+				return
+			}
+			send(j)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
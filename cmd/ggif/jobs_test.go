@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidJobID(t *testing.T) {
+	if id := newJobID(); !validJobID(id, false) {
+		t.Errorf("newJobID() = %q isn't valid", id)
+	}
+	tests := []struct {
+		id     string
+		prefix bool
+		want   bool
+	}{
+		{"0123456789abcdef", false, true},
+		{"0123456789abcdef", true, true},
+		{"0123", true, true},
+		{"0123", false, false},
+		{"0123456789abcdef0", true, false},
+		{"0123456789ABCDEF", false, false},
+		{"../../etc/passwd", true, false},
+		{"0123456789abcde/", false, false},
+		{"", true, false},
+	}
+	for _, tt := range tests {
+		if got := validJobID(tt.id, tt.prefix); got != tt.want {
+			t.Errorf("validJobID(%q, %v) = %v, want %v", tt.id, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityLevel(t *testing.T) {
+	tests := []struct {
+		priority string
+		level    int
+		ok       bool
+	}{
+		{"high", 0, true},
+		{"normal", 1, true},
+		{"low", 2, true},
+		{"High", 0, false},
+		{"urgent", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		level, ok := priorityLevel(tt.priority)
+		if level != tt.level || ok != tt.ok {
+			t.Errorf("priorityLevel(%q) = %d, %v, want %d, %v", tt.priority, level, ok, tt.level, tt.ok)
+		}
+	}
+}
+
+func TestNextByPriority(t *testing.T) {
+	q := &jobQueue{pending: make([]chan *job, len(jobPriorities))}
+	for i := range q.pending {
+		q.pending[i] = make(chan *job, maxPending)
+	}
+	for _, p := range []string{"low", "normal", "low", "high"} {
+		level, _ := priorityLevel(p)
+		q.pending[level] <- &job{Priority: p}
+	}
+	for _, want := range []string{"high", "normal", "low", "low"} {
+		j, ok := q.next(nil)
+		if !ok || j.Priority != want {
+			t.Fatalf("next() = %+v, %v, want a %s priority job", j, ok, want)
+		}
+	}
+
+	// a job queued while next waits is taken, whatever its priority
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.pending[len(q.pending)-1] <- &job{Priority: "low"}
+	}()
+	if j, ok := q.next(nil); !ok || j.Priority != "low" {
+		t.Errorf("next() = %+v, %v, want the low priority job", j, ok)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	if j, ok := q.next(done); ok {
+		t.Errorf("next() with done closed = %+v", j)
+	}
+}
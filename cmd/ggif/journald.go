@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+// journaldSocket is where journald takes entries in its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldMaxMessage caps messages, e.g. the output of a tool logged at
+// DEBUG, to what fits in one datagram.
+const journaldMaxMessage = 64 << 10
+
+// syslogSeverity is the syslog priority of a log level.
+var syslogSeverity = map[logging.Level]int{
+	logging.CRITICAL: 2,
+	logging.ERROR:    3,
+	logging.WARNING:  4,
+	logging.NOTICE:   5,
+	logging.INFO:     6,
+	logging.DEBUG:    7,
+}
+
+// journaldBackend writes records to journald with their priority, the
+// source file and line they were logged from and the module, so
+// `journalctl -t ggif -p warning` works.
+type journaldBackend struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournaldBackend() (logging.Backend, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald isn't running: %w", err)
+	}
+	return &journaldBackend{conn: conn}, nil
+}
+
+// journaldField adds a field to an entry, in the binary form when the value
+// spans lines.
+func journaldField(buf *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		fmt.Fprintf(buf, "%s=%s\n", name, value)
+		return
+	}
+	buf.WriteString(name + "\n")
+	binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.WriteString(value + "\n")
+}
+
+func (b *journaldBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	message := rec.Message()
+	if len(message) > journaldMaxMessage {
+		message = message[:journaldMaxMessage] + "..."
+	}
+	var buf bytes.Buffer
+	journaldField(&buf, "MESSAGE", message)
+	journaldField(&buf, "PRIORITY", strconv.Itoa(syslogSeverity[level]))
+	journaldField(&buf, "SYSLOG_IDENTIFIER", "ggif")
+	journaldField(&buf, "GGIF_MODULE", callerModule(calldepth+1))
+	if _, file, line, ok := runtime.Caller(calldepth + 1); ok {
+		journaldField(&buf, "CODE_FILE", filepath.Base(file))
+		journaldField(&buf, "CODE_LINE", strconv.Itoa(line))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err := b.conn.Write(buf.Bytes())
+	return err
+}
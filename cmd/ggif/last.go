@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// shareTargets are the destinations `ggif last --to` can send a result to.
+var shareTargets = map[string]func(c *cli.Context, e *historyEntry) (string, error){
+	"clipboard": shareClipboard,
+	"email":     shareEmail,
+	"gcs":       shareGCS,
+	"notion":    shareNotion,
+	"slack":     shareSlack,
+	"teams":     shareTeams,
+	"zulip":     shareZulip,
+}
+
+// convertTargets are the share targets a conversion already covers, which
+// the share setting can't name.
+var convertTargets = map[string]bool{"clipboard": true, "gcs": true}
+
+func knownShareTargets(value interface{}) error {
+	for _, name := range strings.Split(value.(string), ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := shareTargets[name]; !ok || convertTargets[name] {
+			var names []string
+			for _, known := range shareTargetNames() {
+				if !convertTargets[known] {
+					names = append(names, known)
+				}
+			}
+			return fmt.Errorf("unknown destination %q, use %s", name, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+func shareTargetNames() []string {
+	var names []string
+	for name := range shareTargets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// shareResult sends a finished conversion to the destinations in the share
+// setting, and by mail with --email.
+func shareResult(c *cli.Context, res *result) error {
+	names := strings.Split(c.String("share"), ",")
+	if c.Bool("email") {
+		names = append(names, "email")
+	}
+	done := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		share, ok := shareTargets[name]
+		if !ok || convertTargets[name] || done[name] {
+			continue
+		}
+		done[name] = true
+		if _, err := share(c, &historyEntry{result: *res}); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func shareClipboard(c *cli.Context, e *historyEntry) (string, error) {
+	if len(e.URLs) == 0 {
+		return "", fmt.Errorf("%s was never uploaded", e.Output)
+	}
+	if err := copyURL(e.URLs[0]); err != nil {
+		// the url is still printed
+		log.Warning(err)
+	}
+	return e.URLs[0], nil
+}
+
+// shareGCS uploads the local gif again, e.g. to the bucket of another
+// profile.
+func shareGCS(c *cli.Context, e *historyEntry) (string, error) {
+	if c.String("bucket") == "" {
+		return "", fmt.Errorf("no bucket configured, set one with `ggif config set bucket <name>`")
+	}
+	if !fileExists(e.Output) {
+		return "", fmt.Errorf("%s no longer exists", e.Output)
+	}
+	// the bucket even when an uploader plugin is configured
+	if err := setFlag(c, "uploader", "gcs"); err != nil {
+		return "", err
+	}
+	url, err := uploadFile(withProvenance(c.Context, &e.result), c, e.Output, filepath.Base(e.Output))
+	if err != nil {
+		return "", exitError(exitUpload, err)
+	}
+	if url == "" {
+		return "", fmt.Errorf("%s was not uploaded", e.Output)
+	}
+
+	res := e.result
+	res.URLs = []string{url}
+	recordHistory(c, &res)
+	return url, nil
+}
+
+// shareSlack posts the url to the incoming webhook in the slack-webhook
+// setting.
+func shareSlack(c *cli.Context, e *historyEntry) (string, error) {
+	hook, err := secretSetting(c, "slack-webhook")
+	if err != nil {
+		return "", err
+	}
+	if hook == "" {
+		return "", fmt.Errorf("no slack webhook configured, set $GGIF_SLACK_WEBHOOK or slack-webhook to a keyring: or exec: reference to it")
+	}
+	if len(e.URLs) == 0 {
+		return "", fmt.Errorf("%s was never uploaded", e.Output)
+	}
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "POST %s %s\n", redactURL(hook), e.URLs[0])
+		return e.URLs[0], nil
+	}
+
+	body, err := json.Marshal(map[string]string{"text": e.URLs[0]})
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(hook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", webhookError(hook, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("slack: %s", resp.Status)
+	}
+	return e.URLs[0], nil
+}
+
+// last prints and copies the url of the most recent upload, or sends the
+// most recent result somewhere else with --to.
+func last(c *cli.Context) error {
+	entries, err := readHistory(1, func(e *historyEntry) bool {
+		return len(e.URLs) > 0 || c.String("to") == "gcs" || c.String("to") == "email"
+	})
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("nothing has been uploaded yet")
+	}
+
+	to := c.String("to")
+	share, ok := shareTargets[to]
+	if !ok {
+		return fmt.Errorf("unknown destination %q, use one of %s", to, strings.Join(shareTargetNames(), ", "))
+	}
+
+	url, err := share(c, entries[0])
+	if err != nil {
+		return err
+	}
+	if to != "gcs" {
+		// uploadFile already printed the new url
+		fmt.Println(url)
+	}
+	return nil
+}
+
+var lastCommand = &cli.Command{
+	Name:  "last",
+	Usage: "print and copy the most recent url again, or send it elsewhere",
+	Description: `Examples:
+      ggif last
+      ggif last --to slack
+      ggif last --to email
+      ggif last --to notion
+      ggif last --to zulip
+      ggif --profile work last --to gcs`,
+	Action: last,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "to",
+			Value: "clipboard",
+			Usage: "where to send it: clipboard, email, notion, slack, teams, zulip or gcs",
+		},
+	},
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// estimateFrames returns about how many frames ffmpeg extracts from the clip
+// of videoFile and how many bytes each takes as a png, half the size of the
+// raw frame.  ok is false when the video can't be probed.
+func estimateFrames(ctx context.Context, c *cli.Context, videoFile string) (frames int64, frameSize uint64, ok bool) {
+	info, err := probe(ctx, videoFile)
+	if err != nil || info.Width == 0 || info.Duration == 0 {
+		return 0, 0, false
+	}
+	start, end, err := trimRange(c)
+	if err != nil {
+		return 0, 0, false
+	}
+	if end == 0 || end > info.Duration {
+		end = info.Duration
+	}
+	fps := info.FPS
+	if fps == 0 {
+		fps = 30
+	}
+	return int64((end - start) * fps), uint64(info.Width * info.Height * 3 / 2), true
+}
+
+// checkLimits fails before any frame is extracted when the clip would take
+// more frames than --max-frames, more space than --max-tmp-size, or more
+// than is free in the temp folder.
+func checkLimits(ctx context.Context, c *cli.Context, videoFile string, tmpDir string) error {
+	if c.Bool("dry-run") {
+		return nil
+	}
+	frames, frameSize, ok := estimateFrames(ctx, c, videoFile)
+	if !ok {
+		return nil
+	}
+	if max := c.Int("max-frames"); max > 0 && frames > int64(max) {
+		return fmt.Errorf("the clip has about %d frames, more than --max-frames %d, convert a shorter part with --start and --end", frames, max)
+	}
+	need := uint64(frames) * frameSize
+	maxSize, _ := parseSize(c.String("max-tmp-size"))
+	if maxSize > 0 && need > maxSize {
+		return fmt.Errorf("the frames need about %s, more than --max-tmp-size %s, convert a shorter part with --start and --end",
+			humanSize(int64(need)), c.String("max-tmp-size"))
+	}
+	if free, ok := diskFree(tmpDir); ok && free < need {
+		return fmt.Errorf("the frames need about %s in %s but only %s is free, point --tmp-dir at a bigger disk or convert a shorter part",
+			humanSize(int64(need)), tempDir(c), humanSize(int64(free)))
+	}
+	return nil
+}
+
+// frameCapArgs are the ffmpeg output arguments that stop each of n segments
+// at its share of --max-frames, should the estimate have been short.
+func frameCapArgs(c *cli.Context, n int) []string {
+	max := c.Int("max-frames")
+	if max <= 0 {
+		return nil
+	}
+	return []string{"-frames:v", strconv.Itoa((max + n - 1) / n)}
+}
+
+// ffmpegThreadArgs limits the threads ffmpeg decodes with to --ffmpeg-threads,
+// by default it takes one per core.
+func ffmpegThreadArgs(c *cli.Context) []string {
+	threads := c.Int("ffmpeg-threads")
+	if threads <= 0 {
+		return nil
+	}
+	return []string{"-threads", strconv.Itoa(threads)}
+}
+
+// parseSize parses a size like 500M or 2GB, in bytes when there is no unit.
+// "" is 0, no limit.
+func parseSize(s string) (uint64, error) {
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	shift := uint(0)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		}
+	}
+	if shift > 0 {
+		s = strings.TrimSpace(s[:len(s)-1])
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	// NaN and Inf parse too, and don't convert to a uint64
+	n *= float64(uint64(1) << shift)
+	if err != nil || !(n >= 0 && n < math.MaxUint64) {
+		return 0, fmt.Errorf("must be a size like 500M or 2G, got %q", orig)
+	}
+	return uint64(n), nil
+}
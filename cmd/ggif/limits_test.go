@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint64
+		ok   bool
+	}{
+		{"", 0, true},
+		{"1024", 1024, true},
+		{"500M", 500 << 20, true},
+		{"500m", 500 << 20, true},
+		{"2G", 2 << 30, true},
+		{"2GB", 2 << 30, true},
+		{"2GiB", 2 << 30, true},
+		{" 16 M ", 16 << 20, true},
+		{"1.5K", 1536, true},
+		{"1T", 1 << 40, true},
+		{"10B", 10, true},
+		{"M", 0, false},
+		{"-1M", 0, false},
+		{"ten", 0, false},
+		{"5X", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e30T", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseSize(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	stdlog "log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/urfave/cli/v2"
+)
+
+var log = logging.MustGetLogger("app")
+var format = logging.MustStringFormatter(
+	`%{color} %{shortfile} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
+)
+
+// fileFormat is format without the colors, for log files.
+var fileFormat = logging.MustStringFormatter(
+	`%{time:2006-01-02T15:04:05.000Z07:00} %{shortfile} ▶ %{level:.4s} %{id:03x} %{message}`,
+)
+
+// systemFormat is format for syslog, which keeps the time and level itself.
+var systemFormat = logging.MustStringFormatter(
+	`%{shortfile} %{message}`,
+)
+
+// logLevels is a parsed --log setting: a default level plus overrides for
+// single modules, e.g. "WARNING,watch=DEBUG,upload=INFO".  A module is the
+// source file a message is logged from, without .go.
+type logLevels struct {
+	level   logging.Level
+	modules map[string]logging.Level
+}
+
+func parseLogLevels(spec string) (*logLevels, error) {
+	levels := &logLevels{level: logging.ERROR, modules: make(map[string]logging.Level)}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		module, name := "", part
+		if i := strings.Index(part, "="); i >= 0 {
+			module, name = strings.TrimSpace(part[:i]), strings.TrimSpace(part[i+1:])
+		}
+		level, err := logging.LogLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		if module == "" {
+			levels.level = level
+		} else {
+			levels.modules[module] = level
+		}
+	}
+	return levels, nil
+}
+
+// max returns the most verbose level any module logs at.
+func (l *logLevels) max() logging.Level {
+	max := l.level
+	for _, level := range l.modules {
+		if level > max {
+			max = level
+		}
+	}
+	return max
+}
+
+// callerModule returns the name of the source file, without .go, the
+// record at calldepth was logged from.
+func callerModule(calldepth int) string {
+	_, file, _, ok := runtime.Caller(calldepth + 1)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(file), ".go")
+}
+
+// moduleBackend drops records below the level of the module they were
+// logged from.
+type moduleBackend struct {
+	backend logging.Backend
+	levels  *logLevels
+}
+
+func (b *moduleBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	min := b.levels.level
+	if len(b.levels.modules) > 0 {
+		if l, ok := b.levels.modules[callerModule(calldepth+1)]; ok {
+			min = l
+		}
+	}
+	if level > min {
+		return nil
+	}
+	return b.backend.Log(level, calldepth+1, rec)
+}
+
+// jsonBackend writes one json object per record, for log shippers.
+type jsonBackend struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (b *jsonBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	entry := map[string]interface{}{
+		"time":    rec.Time.Format(time.RFC3339Nano),
+		"level":   level.String(),
+		"module":  callerModule(calldepth + 1),
+		"id":      rec.ID,
+		"message": rec.Message(),
+	}
+	if _, file, line, ok := runtime.Caller(calldepth + 1); ok {
+		entry["caller"] = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err = b.out.Write(append(data, '\n'))
+	return err
+}
+
+// rotatingFile is a log file that is moved to .1, .2, ... once it grows
+// past maxSize, keeping at most keep old files.
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return r, r.open()
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+	for i := r.keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.keep > 0 {
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size+int64(len(p)) > r.maxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// logFile is kept open across calls to initLogging.
+var logFile *rotatingFile
+
+// systemLog is the syslog or journald backend of --log-to, kept across
+// calls to initLogging like logFile.
+var (
+	systemLog       logging.Backend
+	systemLogTarget string
+)
+
+// systemLogBackend connects to the system log named by --log-to.
+func systemLogBackend(target string) (logging.Backend, error) {
+	if systemLog != nil && systemLogTarget == target {
+		return systemLog, nil
+	}
+	var backend logging.Backend
+	var err error
+	switch target {
+	case "syslog":
+		backend, err = newSyslogBackend()
+	case "journald":
+		backend, err = newJournaldBackend()
+	default:
+		return nil, fmt.Errorf("unknown log target %q", target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	systemLog, systemLogTarget = backend, target
+	return backend, nil
+}
+
+// logOutput is where logs go without --log-file.  watch --tui takes them
+// over to show below its table.
+var logOutput io.Writer = os.Stderr
+
+const (
+	logFileMaxSize = 10 << 20
+	logFileKeep    = 3
+)
+
+// initLogging sets up the log backend from --log, --log-format, --log-file
+// and --log-to.  Logs go to stderr unless a file or the system log is
+// given, so stdout is left to the results.
+func initLogging(c *cli.Context) {
+	levels, err := parseLogLevels(c.String("log"))
+	if err != nil {
+		log.Fatalf("--log %q: %v", c.String("log"), err)
+	}
+	if c.Bool("quiet") {
+		// only fatal errors, stdout is left to the url
+		levels = &logLevels{level: logging.CRITICAL}
+	}
+
+	out := logOutput
+	if fname := c.String("log-file"); fname != "" {
+		if logFile == nil || logFile.path != fname {
+			logFile, err = openRotatingFile(fname, logFileMaxSize, logFileKeep)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+		out = logFile
+	}
+
+	var backend logging.Backend
+	switch {
+	case c.String("log-to") == "syslog" || c.String("log-to") == "journald":
+		backend, err = systemLogBackend(c.String("log-to"))
+		if err != nil {
+			log.Fatalf("--log-to %s: %v", c.String("log-to"), err)
+		}
+	case c.String("log-format") == "json":
+		backend = &jsonBackend{out: out}
+	case out == os.Stderr:
+		backend = logging.NewBackendFormatter(logging.NewLogBackend(out, "", stdlog.LstdFlags), format)
+	default:
+		backend = logging.NewBackendFormatter(logging.NewLogBackend(out, "", 0), fileFormat)
+	}
+
+	logging.SetBackend(&moduleBackend{backend: backend, levels: levels})
+	logging.SetLevel(levels.max(), "app")
+}
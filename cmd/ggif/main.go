@@ -1,27 +1,18 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
+	"context"
 	"os"
-	"os/exec"
-	"os/user"
+	"os/signal"
 	"path/filepath"
-	"time"
+	"runtime"
+	"syscall"
 
-	"github.com/atotto/clipboard"
-	"github.com/fsnotify/fsnotify"
-	"github.com/h2non/filetype"
 	"github.com/op/go-logging"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 )
 
-var log = logging.MustGetLogger("app")
-var format = logging.MustStringFormatter(
-	`%{color} %{shortfile} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`,
-)
-
 func printError(err error) {
 	if err != nil {
 		log.Error(err.Error())
@@ -34,159 +25,36 @@ func printOutput(outs []byte) {
 	}
 }
 
-func findNewestFile(dir string) string {
-	files, _ := ioutil.ReadDir(dir)
-	var newestFile string
-	var newestTime int64 = 0
-	for _, f := range files {
-		fname := filepath.Join(dir, f.Name())
-		fi, err := os.Stat(fname)
-		buf, _ := ioutil.ReadFile(fname)
-		if !filetype.IsVideo(buf) {
-			continue
-		}
-		if err != nil {
-			log.Error(err.Error())
-			continue
-		}
-		currTime := fi.ModTime().Unix()
-		if currTime > newestTime {
-			newestTime = currTime
-			newestFile = f.Name()
-		}
-	}
-	return filepath.Join(dir, newestFile)
-}
-
-func runCmd(name string, arg ...string) {
-	cmd := exec.Command(name, arg...)
-	log.Debug(cmd.Args)
-	output, err := cmd.CombinedOutput()
-	printOutput(output)
-	printError(err)
-}
-
-func createTmpDir() string {
-	dir, err := ioutil.TempDir("/tmp", "pngs")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	return dir
-}
-
-func initLogging(c *cli.Context) {
-	level, err := logging.LogLevel(c.String("log"))
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-	logging.SetLevel(level, "app")
-}
-
-func createGif(c *cli.Context, tmpDir string, outfn string) {
-	infn := filepath.Join(tmpDir, "*.png")
-
-	cmdin := fmt.Sprintf(
-		"gifski -W %d -r %d -Q %d -o %s %s",
-		c.Int("width"),
-		c.Int("frames"),
-		c.Int("quality"),
-		outfn,
-		infn,
-	)
-	runCmd("/bin/sh", "-c", cmdin)
-}
-
-func uploadGCP(bucket string, outfn string, outputFile string) {
-	if bucket == "" {
-		return
-	}
-
-	runCmd("gsutil", "cp", outfn, fmt.Sprintf("gs://%s", bucket))
-	url := fmt.Sprintf(
-		"https://storage.googleapis.com/%s/%s",
-		bucket,
-		outputFile,
-	)
-	fmt.Println(url)
-	clipboard.WriteAll(url)
-}
-
-func findConfigFile() string {
-	user, err := user.Current()
-	if err != nil {
-		log.Debug(err)
-	}
-	fname := filepath.Join(user.HomeDir, ".ggif.json")
-	if _, err := os.Stat(fname); err == nil {
-		return fname
-	}
-	return ""
-}
-
-func watch(c *cli.Context) {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer watcher.Close()
-
-	log.Debugf("Watching %s", c.String("src"))
-
-	done := make(chan bool)
+// interruptContext is cancelled on the first SIGINT or SIGTERM, which stops
+// running conversions and shuts servers down.  A second signal exits right
+// away, removing the temp folders the conversions had no time to.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
-					return
-				}
-				log.Debug("event:", event)
-				if event.Op&fsnotify.Create == fsnotify.Create {
-					log.Debug("modified file:", event.Name)
-					process(c, event.Name)
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
-				}
-				log.Debug("error:", err)
-			}
+		sig := <-sigs
+		log.Warningf("Got %s, stopping", sig)
+		cancel()
+		sig = <-sigs
+		removeOwnTempDirs()
+		code := exitFailure
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
 		}
+		os.Exit(code)
 	}()
-
-	err = watcher.Add(c.String("src"))
-	if err != nil {
-		log.Fatal(err)
-	}
-	<-done
+	return ctx
 }
 
-func process(c *cli.Context, videoFile string) {
-	if videoFile == "" {
-		log.Fatal("No file specified and no file found in config.Src, exiting")
-	}
-
-	tmpDir := createTmpDir()
-	defer os.RemoveAll(tmpDir)
-
-	tmpfn := filepath.Join(tmpDir, "frame%04d.png")
-	runCmd("ffmpeg", "-i", videoFile, tmpfn)
-
-	newName := time.Now().Unix()
-	outputFile := fmt.Sprintf("%d.gif", newName)
-	distDir := c.String("dist")
-	if distDir == "" {
-		distDir = c.String("src")
-	}
-	outfn := filepath.Join(distDir, outputFile)
-
-	createGif(c, tmpDir, outfn)
-	uploadGCP(c.String("bucket"), outfn, outputFile)
+func fileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
 }
 
 func main() {
 	logging.SetFormatter(format)
+	addToolDirs()
 	configFile := findConfigFile()
 
 	curDir, err := filepath.Abs(filepath.Dir(os.Args[0]))
@@ -196,77 +64,543 @@ func main() {
 
 	flags := []cli.Flag{
 		altsrc.NewStringFlag(&cli.StringFlag{
-			Name:  "log",
-			Value: "ERROR",
-			Usage: "log level for output",
+			Name:    "log",
+			EnvVars: []string{"GGIF_LOG"},
+			Value:   "ERROR",
+			Usage:   "log level for output, optionally per module: WARNING,watch=DEBUG",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "log-format",
+			EnvVars: []string{"GGIF_LOG_FORMAT"},
+			Value:   "text",
+			Usage:   "log format: text or json",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "log-file",
+			EnvVars: []string{"GGIF_LOG_FILE"},
+			Value:   "",
+			Usage:   "write logs to this file instead of stderr, rotated at 10 MB",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "log-to",
+			EnvVars: []string{"GGIF_LOG_TO"},
+			Value:   "stderr",
+			Usage:   "where logs go: stderr (or --log-file), syslog or journald, for running as a service",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "quality",
+			EnvVars: []string{"GGIF_QUALITY"},
+			Value:   100,
+			Usage:   "quality of gif (1-100)",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "frames",
+			EnvVars: []string{"GGIF_FRAMES"},
+			Value:   20,
+			Usage:   "framerate for gif",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "width",
+			EnvVars: []string{"GGIF_WIDTH"},
+			Value:   960,
+			Usage:   "width resolution for gif",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "jobs",
+			Aliases: []string{"j"},
+			EnvVars: []string{"GGIF_JOBS"},
+			Value:   runtime.NumCPU(),
+			Usage:   "videos to convert at once when given several or while watching",
 		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
-			Name:  "quality",
-			Value: 100,
-			Usage: "quality of gif (1-100)",
+			Name:    "segments",
+			EnvVars: []string{"GGIF_SEGMENTS"},
+			Value:   1,
+			Usage:   "split clips into up to this many segments of at least 10s and encode them in parallel",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "seek-mode",
+			EnvVars: []string{"GGIF_SEEK_MODE"},
+			Value:   "fast",
+			Usage:   "how --start is found: fast seeks the input, accurate decodes from the beginning to start on the exact frame",
 		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
-			Name:  "frames",
-			Value: 20,
-			Usage: "framerate for gif",
+			Name:    "max-frames",
+			EnvVars: []string{"GGIF_MAX_FRAMES"},
+			Value:   0,
+			Usage:   "refuse clips that would extract more frames than this, 0 for no limit",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "max-tmp-size",
+			EnvVars: []string{"GGIF_MAX_TMP_SIZE"},
+			Value:   "",
+			Usage:   "refuse clips whose frames would take more temp space than this, like 2G",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "max-gif-size",
+			EnvVars: []string{"GGIF_MAX_GIF_SIZE"},
+			Value:   "",
+			Usage:   "fail instead of uploading gifs bigger than this, like 10M",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "warn-gif-size",
+			EnvVars: []string{"GGIF_WARN_GIF_SIZE"},
+			Value:   "10M",
+			Usage:   "suggest smaller settings for gifs bigger than this, 0 to never",
 		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
-			Name:  "width",
-			Value: 960,
-			Usage: "width resolution for gif",
+			Name:    "ffmpeg-threads",
+			EnvVars: []string{"GGIF_FFMPEG_THREADS"},
+			Value:   0,
+			Usage:   "threads ffmpeg may use, 0 for one per core",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "proxy",
+			EnvVars: []string{"GGIF_PROXY"},
+			Value:   "",
+			Usage:   "http(s) proxy for uploads and downloads, like http://proxy:3128 (default: $HTTPS_PROXY)",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "ca-file",
+			EnvVars: []string{"GGIF_CA_FILE"},
+			Value:   "",
+			Usage:   "pem file of extra certificate authorities to trust, for private CAs; gsutil trusts only the ones in it",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "limit-rate",
+			EnvVars: []string{"GGIF_LIMIT_RATE"},
+			Value:   "",
+			Usage:   "upload no faster than this many bytes a second, like 2MB/s",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "upload-part-size",
+			EnvVars: []string{"GGIF_UPLOAD_PART_SIZE"},
+			Value:   "",
+			Usage:   "upload gifs bigger than this in parts of this size sent in parallel, like 16M",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    "upload-parallelism",
+			EnvVars: []string{"GGIF_UPLOAD_PARALLELISM"},
+			Value:   0,
+			Usage:   "how many parts of a gif to upload at once, 0 for the uploader's default; parts are 16M unless --upload-part-size says otherwise",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "tool-timeout",
+			EnvVars: []string{"GGIF_TOOL_TIMEOUT"},
+			Value:   "1h",
+			Usage:   "kill ffmpeg or gifski when a run takes longer than this, 0 for no limit",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "upload-timeout",
+			EnvVars: []string{"GGIF_UPLOAD_TIMEOUT"},
+			Value:   "10m",
+			Usage:   "kill gsutil or the uploader plugin when an upload takes longer than this, 0 for no limit",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "wait-for-url",
+			EnvVars: []string{"GGIF_WAIT_FOR_URL"},
+			Value:   "0",
+			Usage:   "after uploading, wait up to this long for the url to answer HEAD with 200 before printing and copying it, 0 to not check",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "src",
+			EnvVars: []string{"GGIF_SRC"},
+			Value:   curDir,
+			Usage:   "source folder for movie file",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "dist",
+			EnvVars: []string{"GGIF_DIST"},
+			Value:   "",
+			Usage:   "destination folder folder for gif file",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "tmp-dir",
+			EnvVars: []string{"GGIF_TMP_DIR"},
+			Value:   "",
+			Usage:   "folder for extracted frames and received videos, the system's temp folder by default",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "bucket",
+			EnvVars: []string{"GGIF_BUCKET"},
+			Value:   "",
+			Usage:   "google cloud storage bucket name",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "routes",
+			EnvVars: []string{"GGIF_ROUTES"},
+			Value:   "",
+			Usage:   "send each output to its own uploader:bucket, like gif=gcs:public,gif=gcs:archive,thumbnail=gcs:cdn",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "source-prefix",
+			EnvVars: []string{"GGIF_SOURCE_PREFIX"},
+			Value:   "sources/",
+			Usage:   "where --include-source puts recordings in the bucket, before the name of their gif",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "source-storage-class",
+			EnvVars: []string{"GGIF_SOURCE_STORAGE_CLASS"},
+			Value:   "",
+			Usage:   "storage class for recordings uploaded with --include-source, like nearline (default: the bucket's)",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
-			Name:  "src",
-			Value: curDir,
-			Usage: "source folder for movie file",
+			Name:    "gcs-credentials",
+			EnvVars: []string{"GGIF_GCS_CREDENTIALS", "GOOGLE_APPLICATION_CREDENTIALS"},
+			Value:   "",
+			Usage:   "service account key or workload identity federation config for gsutil, instead of its own login",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
-			Name:  "dist",
-			Value: "",
-			Usage: "destination folder folder for gif file",
+			Name:    "impersonate-service-account",
+			EnvVars: []string{"GGIF_IMPERSONATE_SERVICE_ACCOUNT", "CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT"},
+			Value:   "",
+			Usage:   "upload as this service account, which the credentials in use may act as",
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
-			Name:  "bucket",
-			Value: "",
-			Usage: "google cloud storage bucket name",
+			Name:    "smtp-server",
+			EnvVars: []string{"GGIF_SMTP_SERVER"},
+			Value:   "",
+			Usage:   "mail server --email and ggif last --to email send through, like smtp.example.com:587",
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "smtp-user",
+			EnvVars: []string{"GGIF_SMTP_USER"},
+			Value:   "",
+			Usage:   "user to log in to the mail server as",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "smtp-password",
+			EnvVars: []string{"GGIF_SMTP_PASSWORD"},
+			Value:   "",
+			Usage:   "password for the mail server, best a keyring: or exec: reference",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "email-from",
+			EnvVars: []string{"GGIF_EMAIL_FROM"},
+			Value:   "",
+			Usage:   "sender of the mails (default: smtp-user)",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "email-to",
+			EnvVars: []string{"GGIF_EMAIL_TO"},
+			Value:   "",
+			Usage:   "comma separated addresses to mail gifs to",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "email-max-attachment",
+			EnvVars: []string{"GGIF_EMAIL_MAX_ATTACHMENT"},
+			Value:   "10M",
+			Usage:   "attach gifs up to this size, bigger ones are mailed as their url",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "if-exists",
+			EnvVars: []string{"GGIF_IF_EXISTS"},
+			Value:   "rename",
+			Usage:   "what to do when the gif or bucket object already exists: skip, overwrite or rename",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "slack-webhook",
+			EnvVars: []string{"GGIF_SLACK_WEBHOOK"},
+			Value:   "",
+			Usage:   "slack incoming webhook url that ggif last --to slack posts to",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "teams-webhook",
+			EnvVars: []string{"GGIF_TEAMS_WEBHOOK"},
+			Value:   "",
+			Usage:   "Microsoft Teams incoming webhook url that ggif last --to teams posts to",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "zulip-site",
+			EnvVars: []string{"GGIF_ZULIP_SITE"},
+			Value:   "",
+			Usage:   "url of the Zulip organization ggif last --to zulip posts to",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "zulip-email",
+			EnvVars: []string{"GGIF_ZULIP_EMAIL"},
+			Value:   "",
+			Usage:   "email of the Zulip bot to post as",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "zulip-api-key",
+			EnvVars: []string{"GGIF_ZULIP_API_KEY"},
+			Value:   "",
+			Usage:   "api key of the Zulip bot",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "zulip-stream",
+			EnvVars: []string{"GGIF_ZULIP_STREAM"},
+			Value:   "",
+			Usage:   "Zulip stream to post gifs to",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "zulip-topic",
+			EnvVars: []string{"GGIF_ZULIP_TOPIC"},
+			Value:   "gifs",
+			Usage:   "topic of the Zulip stream to post gifs under",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "notion-token",
+			EnvVars: []string{"GGIF_NOTION_TOKEN"},
+			Value:   "",
+			Usage:   "token of the Notion integration that ggif last --to notion posts as",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "notion-page",
+			EnvVars: []string{"GGIF_NOTION_PAGE"},
+			Value:   "",
+			Usage:   "url or id of the Notion page gifs are added to, shared with the integration",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "share",
+			EnvVars: []string{"GGIF_SHARE"},
+			Value:   "",
+			Usage:   "also send each gif to these destinations of ggif last --to, like slack,notion",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "palette",
+			EnvVars: []string{"GGIF_PALETTE"},
+			Value:   "",
+			Usage:   "map gifs to these colors: mono, a list like #1d1d1b,#ff5a00,#ffffff, or a png or gif to take them from",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "dither",
+			EnvVars: []string{"GGIF_DITHER"},
+			Value:   "floyd-steinberg",
+			Usage:   "how gifs are dithered to --palette: floyd-steinberg or none",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "uploader",
+			EnvVars: []string{"GGIF_UPLOADER"},
+			Value:   "gcs",
+			Usage:   "where gifs are uploaded: gcs, or the name of a ggif-upload-<name> plugin on the PATH",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "metrics-addr",
+			EnvVars: []string{"GGIF_METRICS_ADDR"},
+			Value:   "",
+			Usage:   "serve /metrics, /healthz and /readyz on this address while watching or serving grpc, e.g. localhost:9100",
+		}),
+		&cli.StringFlag{
+			Name:    "load",
+			EnvVars: []string{"GGIF_CONFIG", "GGIF_LOAD"},
+			Value:   configFile,
+			Usage:   "location and file name of configuration file (json, yaml or toml)",
+		},
 		&cli.StringFlag{
-			Name:  "load",
-			Value: configFile,
-			Usage: "location and file name of configuration file",
+			Name:    "profile",
+			EnvVars: []string{"GGIF_PROFILE"},
+			Usage:   "named profile from the config file to apply",
+		},
+		&cli.BoolFlag{
+			Name:    "allow-project-secrets",
+			EnvVars: []string{"GGIF_ALLOW_PROJECT_SECRETS"},
+			Usage:   "resolve keyring: and exec: references in .ggif.* files found above the working directory",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "print the commands and urls that would be run and produced without running them",
+		},
+		&cli.StringFlag{
+			Name:  "start",
+			Usage: "start converting at this point of the video (seconds, m:ss or h:mm:ss)",
+		},
+		&cli.StringFlag{
+			Name:  "end",
+			Usage: "stop converting at this point of the video (seconds, m:ss or h:mm:ss)",
+		},
+		&cli.BoolFlag{
+			Name:    "interactive",
+			Aliases: []string{"i"},
+			Usage:   "pick the start and end points on a timeline with previews before converting",
 		},
 		&cli.BoolFlag{
-			Name:  "watch",
-			Value: false,
-			Usage: "watch src directory for new files",
+			Name:    "confirm",
+			EnvVars: []string{"GGIF_CONFIRM"},
+			Usage:   "show the size and destination and ask before uploading",
+		},
+		&cli.BoolFlag{
+			Name:    "no-clipboard",
+			EnvVars: []string{"GGIF_NO_CLIPBOARD"},
+			Usage:   "don't copy the url to the clipboard",
+		},
+		&cli.BoolFlag{
+			Name:    "open",
+			EnvVars: []string{"GGIF_OPEN"},
+			Usage:   "open the gif in the default viewer after converting",
+		},
+		&cli.BoolFlag{
+			Name:    "open-url",
+			EnvVars: []string{"GGIF_OPEN_URL"},
+			Usage:   "open the uploaded url in the browser after uploading",
+		},
+		&cli.BoolFlag{
+			Name:    "newest",
+			EnvVars: []string{"GGIF_NEWEST"},
+			Usage:   "without a file argument take the newest recording instead of asking",
+		},
+		&cli.BoolFlag{
+			Name:    "stream-upload",
+			EnvVars: []string{"GGIF_STREAM_UPLOAD"},
+			Usage:   "upload to the bucket while gifski is still writing the gif",
+		},
+		&cli.BoolFlag{
+			Name:    "no-cache",
+			EnvVars: []string{"GGIF_NO_CACHE"},
+			Usage:   "convert again even if the video was converted with the same settings before",
+		},
+		&cli.BoolFlag{
+			Name:    "preflight",
+			EnvVars: []string{"GGIF_PREFLIGHT"},
+			Usage:   "check that the bucket is reachable with the current credentials before converting",
+		},
+		&cli.BoolFlag{
+			Name:    "thumbnail",
+			EnvVars: []string{"GGIF_THUMBNAIL"},
+			Usage:   "also write a representative frame as a png next to the gif",
+		},
+		&cli.BoolFlag{
+			Name:    "shared-palette",
+			EnvVars: []string{"GGIF_SHARED_PALETTE"},
+			Usage:   "with --segments, give every segment the palette of the whole clip so colors don't shift where they meet",
+		},
+		&cli.BoolFlag{
+			Name:    "email",
+			EnvVars: []string{"GGIF_EMAIL"},
+			Usage:   "also mail each gif to email-to, attached or as its url",
+		},
+		&cli.BoolFlag{
+			Name:    "include-source",
+			EnvVars: []string{"GGIF_INCLUDE_SOURCE"},
+			Usage:   "also upload the recording, under --source-prefix, to keep the full quality version",
+		},
+		&cli.BoolFlag{
+			Name:    "provenance",
+			EnvVars: []string{"GGIF_PROVENANCE"},
+			Usage:   "store the name and sha256 of the recording as metadata of the uploaded object",
+		},
+		&cli.BoolFlag{
+			Name:    "strip-metadata",
+			EnvVars: []string{"GGIF_STRIP_METADATA"},
+			Value:   true,
+			Usage:   "remove comments, XMP, exif and timestamps from gifs and thumbnails, --strip-metadata=false to keep them",
+		},
+		&cli.BoolFlag{
+			Name:    "nice",
+			Aliases: []string{"background"},
+			EnvVars: []string{"GGIF_NICE"},
+			Usage:   "run ffmpeg, gifski and gsutil at low cpu and io priority",
+		},
+		&cli.BoolFlag{
+			Name:  "stdin",
+			Usage: "read newline separated paths of files to convert from stdin (same as a - argument)",
+		},
+		&cli.BoolFlag{
+			Name:    "quiet",
+			Aliases: []string{"q"},
+			EnvVars: []string{"GGIF_QUIET"},
+			Usage:   "no logging, only print the final url",
+		},
+		&cli.BoolFlag{
+			Name:    "json",
+			EnvVars: []string{"GGIF_JSON"},
+			Usage:   "print the result as a json object on stdout, logs stay on stderr",
+		},
+		&cli.BoolFlag{
+			Name:    "watch",
+			EnvVars: []string{"GGIF_WATCH"},
+			Value:   false,
+			Usage:   "watch src directory for new files",
+			Hidden:  true,
+		},
+		&cli.BoolFlag{
+			Name:    "clipboard",
+			EnvVars: []string{"GGIF_CLIPBOARD"},
+			Value:   false,
+			Usage:   "watch the clipboard for video file paths",
+			Hidden:  true,
 		},
 	}
 
 	app := &cli.App{
-		Name:   "ggif",
-		Usage:  "convert movies to gifs and upload them",
-		Flags:  flags,
-		Before: altsrc.InitInputSourceWithContext(flags, altsrc.NewJSONSourceFromFlagFunc("load")),
-		Action: func(c *cli.Context) error {
+		Name:        "ggif",
+		Usage:       "convert movies to gifs and upload them",
+		Version:     version,
+		Description: configReference(flags),
+		Flags:       flags,
+		Commands: []*cli.Command{
+			convertCommand,
+			recordCommand,
+			hotkeyCommand,
+			uploadCommand,
+			watchCommand,
+			configCommand,
+			initCommand,
+			doctorCommand,
+			estimateCommand,
+			historyCommand,
+			lastCommand,
+			pruneCommand,
+			logsCommand,
+			resumeCommand,
+			versionCommand,
+			updateCommand,
+			docsCommand,
+			serveCommand,
+			workerCommand,
+			grpcCommand,
+			nativeHostCommand,
+		},
+		Before: func(c *cli.Context) error {
+			// honor --log while the config file is loaded, then again
+			// once it may have changed the level
 			initLogging(c)
-			if c.Bool("watch") {
-				watch(c)
-			} else {
-				videoFile := ""
-				if c.Args().Len() >= 1 {
-					videoFile = c.Args().Get(0)
-				} else {
-					videoFile = findNewestFile(c.String("src"))
+			err := applyConfig(c, flags)
+			if err == nil {
+				err = checkSettings(c)
+			}
+			if err == nil {
+				err = applyNetworkSettings(c)
+			}
+			if err != nil {
+				switch c.Args().First() {
+				case configCommand.Name, doctorCommand.Name:
+					// let these fix or report a broken file
+					log.Warning(err)
+				default:
+					log.Critical(err)
+					os.Exit(exitConfig)
 				}
-				process(c, videoFile)
 			}
-
+			initLogging(c)
 			return nil
 		},
+		Action: func(c *cli.Context) error {
+			sweepBefore(c)
+			// --watch and --clipboard predate the subcommands
+			if c.Bool("clipboard") {
+				watchClipboard(c)
+				return nil
+			}
+			if c.Bool("watch") {
+				return watch(c)
+			}
+			return convert(c)
+		},
+		OnUsageError: usageError,
+		// errors are logged and turned into exit codes once Run returns
+		ExitErrHandler: func(c *cli.Context, err error) {},
 	}
+	setUsageErrors(app.Commands)
 
-	err = app.Run(os.Args)
+	err = app.RunContext(interruptContext(), os.Args)
+	// whatever background jobs still had
+	removeOwnTempDirs()
 	if err != nil {
-		log.Fatal(err.Error())
+		if msg := err.Error(); msg != "" {
+			log.Critical(msg)
+		}
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+var errTruncated = errors.New("truncated")
+
+// stripGIF drops the comment extensions and the application extensions
+// other than the loop count, where editors keep XMP and ICC profiles, from
+// a gif.  It reports whether anything was dropped.
+func stripGIF(data []byte) ([]byte, bool, error) {
+	if len(data) < 13 || !bytes.HasPrefix(data, []byte("GIF")) {
+		return nil, false, errTruncated
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+	out := append([]byte{}, data[:pos]...)
+	stripped := false
+
+	// subBlocks returns the end of the data sub-blocks starting at p
+	subBlocks := func(p int) (int, error) {
+		for {
+			if p >= len(data) {
+				return 0, errTruncated
+			}
+			size := int(data[p])
+			p++
+			if size == 0 {
+				return p, nil
+			}
+			p += size
+		}
+	}
+
+	for {
+		if pos >= len(data) {
+			return nil, false, errTruncated
+		}
+		start := pos
+		switch data[pos] {
+		case 0x21:
+			if pos+2 >= len(data) {
+				return nil, false, errTruncated
+			}
+			label := data[pos+1]
+			keep := label == 0xf9 || label == 0x01
+			if label == 0xff && pos+13 < len(data) {
+				app := string(data[pos+3 : pos+14])
+				keep = app == "NETSCAPE2.0" || app == "ANIMEXTS1.0"
+			}
+			end, err := subBlocks(pos + 2)
+			if err != nil {
+				return nil, false, err
+			}
+			if keep {
+				out = append(out, data[start:end]...)
+			} else {
+				stripped = true
+			}
+			pos = end
+		case 0x2c:
+			if pos+10 >= len(data) {
+				return nil, false, errTruncated
+			}
+			p := pos + 10
+			if flags := data[pos+9]; flags&0x80 != 0 {
+				p += 3 << (flags&0x07 + 1)
+			}
+			// the LZW code size, then the image data
+			end, err := subBlocks(p + 1)
+			if err != nil {
+				return nil, false, err
+			}
+			out = append(out, data[start:end]...)
+			pos = end
+		case 0x3b:
+			// anything after the trailer is dropped too
+			stripped = stripped || pos+1 < len(data)
+			return append(out, 0x3b), stripped, nil
+		default:
+			return nil, false, errTruncated
+		}
+	}
+}
+
+// pngSignature starts every png.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// strippedChunks are the png chunks that carry text, timestamps and exif.
+var strippedChunks = map[string]bool{
+	"tEXt": true, "zTXt": true, "iTXt": true, "tIME": true, "eXIf": true,
+}
+
+// stripPNG drops the text, time and exif chunks from a png and reports
+// whether there were any.
+func stripPNG(data []byte) ([]byte, bool, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, false, errTruncated
+	}
+	out := append([]byte{}, pngSignature...)
+	stripped := false
+	for pos := len(pngSignature); pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, false, errTruncated
+		}
+		// length, type, data and crc
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end > len(data) || end < pos {
+			return nil, false, errTruncated
+		}
+		if strippedChunks[string(data[pos+4:pos+8])] {
+			stripped = true
+		} else {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, stripped, nil
+}
+
+// stripped returns the contents of the gif or png fname without metadata,
+// and whether there was any.
+func stripped(fname string) ([]byte, bool, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, false, err
+	}
+	strip := stripGIF
+	// by the contents, a thumbnail being written has a partial name
+	if bytes.HasPrefix(data, []byte(pngSignature)) {
+		strip = stripPNG
+	}
+	out, changed, err := strip(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't strip the metadata from %s: %v", fname, err)
+	}
+	return out, changed, nil
+}
+
+// stripMetadata removes comments, XMP, exif and timestamps from the gif or
+// png fname in place.  gifs straight from gifski have none, but whatever
+// made a gif given to ggif upload may have added some.
+func stripMetadata(fname string) error {
+	data, changed, err := stripped(fname)
+	if err != nil || !changed {
+		return err
+	}
+	log.Debugf("Stripped the metadata from %s", fname)
+	tmp := partialName(fname)
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fname)
+}
+
+// strippedCopy returns a copy of the gif fname without its metadata, in a
+// temporary folder that cleanup removes, or fname itself when it has none
+// or --strip-metadata is off.
+func strippedCopy(c *cli.Context, fname string) (string, func(), error) {
+	cleanup := func() {}
+	if !c.Bool("strip-metadata") {
+		return fname, cleanup, nil
+	}
+	data, changed, err := stripped(fname)
+	if err != nil || !changed {
+		return fname, cleanup, err
+	}
+	dir, err := makeTempDir(tempDir(c), "strip")
+	if err != nil {
+		return "", cleanup, err
+	}
+	copyfn := filepath.Join(dir, filepath.Base(fname))
+	if err := ioutil.WriteFile(copyfn, data, 0644); err != nil {
+		os.RemoveAll(dir)
+		return "", cleanup, err
+	}
+	return copyfn, func() { os.RemoveAll(dir) }, nil
+}
+
+// strippedSource returns a copy of the recording fname without the
+// creation time, location, device and other tags recorders write, in a
+// temporary folder that cleanup removes, for --include-source.  ffmpeg
+// copies the streams as they are, without encoding them again.  With
+// --strip-metadata=false it is fname itself.
+func strippedSource(ctx context.Context, c *cli.Context, fname string) (string, func(), error) {
+	cleanup := func() {}
+	if !c.Bool("strip-metadata") {
+		return fname, cleanup, nil
+	}
+	var dir string
+	if c.Bool("dry-run") {
+		dir = filepath.Join(tempDir(c), fmt.Sprintf("ggif-%d-sourceXXXXXX", os.Getpid()))
+	} else {
+		var err error
+		if dir, err = makeTempDir(tempDir(c), "source"); err != nil {
+			return "", cleanup, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+	}
+	copyfn := filepath.Join(dir, filepath.Base(fname))
+	var err error
+	if isCastFile(fname) {
+		if !c.Bool("dry-run") {
+			err = stripCast(fname, copyfn)
+		}
+	} else {
+		err = runCmd(ctx, c, "ffmpeg", "-i", fname,
+			"-map", "0", "-map_metadata", "-1", "-map_chapters", "-1",
+			"-c", "copy", "-y", copyfn)
+	}
+	if err != nil {
+		cleanup()
+		return "", func() {}, fmt.Errorf("can't strip the metadata from %s: %v", fname, err)
+	}
+	return copyfn, cleanup, nil
+}
+
+// castTags are the keys of an asciinema header that say when, where and
+// with what a terminal was recorded, rather than how to play it.
+var castTags = []string{"timestamp", "env", "command"}
+
+// stripCast copies the asciinema recording fname to copyfn without the
+// castTags in its header.
+func stripCast(fname string, copyfn string) error {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	header, rest := data, []byte(nil)
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		header, rest = data[:i], data[i:]
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(header, &fields); err != nil {
+		return fmt.Errorf("bad header: %v", err)
+	}
+	for _, key := range castTags {
+		delete(fields, key)
+	}
+	if header, err = json.Marshal(fields); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(copyfn, append(header, rest...), 0644)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripCast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ggif-cast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	events := "\n[0.5, \"o\", \"$ ls\\r\\n\"]\n[1.0, \"o\", \"demo.gif\\r\\n\"]\n"
+	fname := filepath.Join(dir, "in.cast")
+	header := `{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000, "env": {"SHELL": "/bin/zsh", "TERM": "xterm"}, "command": "ssh prod", "title": "demo"}`
+	if err := ioutil.WriteFile(fname, []byte(header+events), 0644); err != nil {
+		t.Fatal(err)
+	}
+	copyfn := filepath.Join(dir, "out.cast")
+	if err := stripCast(fname, copyfn); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(copyfn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.IndexByte(data, '\n')
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data[:i], &fields); err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	for _, key := range castTags {
+		if _, ok := fields[key]; ok {
+			t.Errorf("%s was kept", key)
+		}
+	}
+	for _, key := range []string{"version", "width", "height", "title"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("%s was dropped", key)
+		}
+	}
+	if got := string(data[i:]); got != events {
+		t.Errorf("events = %q, want %q", got, events)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	conversionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ggif_conversions_total",
+		Help: "Videos processed, by outcome: done, failed or skipped.",
+	}, []string{"status"})
+	failuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ggif_failures_total",
+		Help: "Failed conversions by the stage that failed: input, cast, frames, gif, upload or source.",
+	}, []string{"stage"})
+	stageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ggif_stage_duration_seconds",
+		Help:    "Seconds spent in each stage of a conversion: cast, frames, gif, upload, source and total.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"stage"})
+	outputBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ggif_output_bytes",
+		Help:    "Size of the gifs written.",
+		Buckets: prometheus.ExponentialBuckets(64<<10, 2, 11),
+	})
+	uploadBytesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ggif_upload_bytes_total",
+		Help: "Bytes copied to the bucket.",
+	})
+)
+
+// observeResult counts a finished conversion in the metrics and remembers
+// successes for /readyz.
+func observeResult(res *result) {
+	switch {
+	case res.Error != "":
+		conversionsTotal.WithLabelValues("failed").Inc()
+		stage := res.stage
+		if stage == "" {
+			stage = "input"
+		}
+		failuresTotal.WithLabelValues(stage).Inc()
+	case res.Skipped:
+		conversionsTotal.WithLabelValues("skipped").Inc()
+	default:
+		conversionsTotal.WithLabelValues("done").Inc()
+		outputBytes.Observe(float64(res.Size))
+		markSuccess()
+	}
+	for stage, seconds := range res.Durations {
+		stageSeconds.WithLabelValues(stage).Observe(seconds)
+	}
+}
+
+// serveMonitoring serves /metrics, /healthz and /readyz on --metrics-addr
+// in the background, for modes that don't already run an http server.
+func serveMonitoring(c *cli.Context) {
+	addr := c.String("metrics-addr")
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	handleHealth(mux, c, nil)
+	go func() {
+		log.Noticef("Serving metrics and health checks on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Errorf("Metrics: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// nativeHostName is the name browser extensions connect to with
+// chrome.runtime.connectNative("com.neurosnap.ggif").
+const nativeHostName = "com.neurosnap.ggif"
+
+// maxNativeMessage is the largest message browsers send to a native host.
+// Recordings bigger than that arrive as several chunk messages.
+const maxNativeMessage = 64 << 20
+
+// nativeMessage is a message from the extension:
+//
+//	{"type": "ping"}
+//	{"type": "chunk", "data": "<base64>"}
+//	{"type": "convert", "name": "tab.webm", "video": "<base64>"}
+//	{"type": "convert", "path": "/home/me/Downloads/tab.webm"}
+//
+// convert takes the video from the message, a path, or the chunks sent
+// before it.
+type nativeMessage struct {
+	Type  string `json:"type"`
+	Name  string `json:"name,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Video []byte `json:"video,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+// nativeReply is a message to the extension.  A convert is answered with
+// a stage message as each stage starts, then a result or an error.
+type nativeReply struct {
+	Type    string  `json:"type"` // pong, stage, result or error
+	Version string  `json:"version,omitempty"`
+	Stage   string  `json:"stage,omitempty"`
+	Result  *result `json:"result,omitempty"`
+	URL     string  `json:"url,omitempty"`
+	Error   string  `json:"error,omitempty"`
+	Code    int     `json:"code,omitempty"`
+}
+
+// readNativeMessage reads one message in the browsers' framing: its length
+// as a 32 bit integer in native byte order, then that much json.
+func readNativeMessage(r io.Reader, msg *nativeMessage) error {
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return err
+	}
+	if size > maxNativeMessage {
+		return fmt.Errorf("message of %s is larger than %s", humanSize(int64(size)), humanSize(maxNativeMessage))
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return err
+	}
+	*msg = nativeMessage{}
+	return json.Unmarshal(data, msg)
+}
+
+func writeNativeMessage(w io.Writer, reply nativeReply) error {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(data))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// nativeHost answers messages from a browser extension on stdin and stdout
+// until the browser closes the connection.
+func nativeHost(c *cli.Context) error {
+	if c.Bool("install") {
+		return installNativeHost(c)
+	}
+	// stdout belongs to the protocol, and there is nobody to confirm
+	if err := serverMode(c); err != nil {
+		return err
+	}
+	if err := setFlag(c, "confirm", "false"); err != nil {
+		return err
+	}
+	log.Infof("Native messaging host started by %s", c.Args().First())
+
+	dir, err := makeTempDir(tempDir(c), "native")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var chunks *os.File
+	send := func(reply nativeReply) error {
+		return writeNativeMessage(os.Stdout, reply)
+	}
+	for {
+		var msg nativeMessage
+		err := readNativeMessage(os.Stdin, &msg)
+		if err == io.EOF || c.Context.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch msg.Type {
+		case "ping":
+			err = send(nativeReply{Type: "pong", Version: version})
+		case "chunk":
+			if chunks == nil {
+				if chunks, err = ioutil.TempFile(dir, "chunks"); err != nil {
+					return err
+				}
+			}
+			_, err = chunks.Write(msg.Data)
+		case "convert":
+			videoFile := msg.Path
+			switch {
+			case len(msg.Video) > 0:
+				videoFile, err = saveVideo(dir, msg.Name, bytes.NewReader(msg.Video))
+			case chunks != nil:
+				chunks.Close()
+				// ffmpeg goes by the extension
+				videoFile = chunks.Name() + filepath.Ext(msg.Name)
+				err = os.Rename(chunks.Name(), videoFile)
+				chunks = nil
+			}
+			if err != nil {
+				err = send(nativeReply{Type: "error", Error: err.Error(), Code: exitCode(err)})
+			} else {
+				err = nativeConvert(c, videoFile, send)
+			}
+			if videoFile != msg.Path {
+				os.Remove(videoFile)
+			}
+		default:
+			err = send(nativeReply{Type: "error", Error: fmt.Sprintf("unknown message type %q", msg.Type), Code: exitUsage})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nativeConvert converts a video for the extension, returning an error
+// only when the reply can't be sent.
+func nativeConvert(c *cli.Context, videoFile string, send func(nativeReply) error) error {
+	if videoFile == "" || !isRecording(videoFile) {
+		return send(nativeReply{Type: "error", Error: "send a video, a path or chunks", Code: exitUsage})
+	}
+	log.Infof("Converting %s for the browser", videoFile)
+	res, err := processWith(c.Context, c, videoFile, func(stage string) {
+		send(nativeReply{Type: "stage", Stage: stage})
+	})
+	if err != nil {
+		log.Errorf("%s: %v", videoFile, err)
+		return send(nativeReply{Type: "error", Result: res, Error: err.Error(), Code: exitCode(err)})
+	}
+	reply := nativeReply{Type: "result", Result: res}
+	if len(res.URLs) > 0 {
+		reply.URL = res.URLs[0]
+	}
+	return send(reply)
+}
+
+// nativeHostDirs are the folders browsers look for native host manifests
+// in for the current user, by browser.
+func nativeHostDirs() (map[string]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	switch runtime.GOOS {
+	case "linux":
+		return map[string]string{
+			"chrome":   filepath.Join(home, ".config", "google-chrome", "NativeMessagingHosts"),
+			"chromium": filepath.Join(home, ".config", "chromium", "NativeMessagingHosts"),
+			"firefox":  filepath.Join(home, ".mozilla", "native-messaging-hosts"),
+		}, nil
+	case "darwin":
+		support := filepath.Join(home, "Library", "Application Support")
+		return map[string]string{
+			"chrome":   filepath.Join(support, "Google", "Chrome", "NativeMessagingHosts"),
+			"chromium": filepath.Join(support, "Chromium", "NativeMessagingHosts"),
+			"firefox":  filepath.Join(support, "Mozilla", "NativeMessagingHosts"),
+		}, nil
+	case "windows":
+		// anywhere will do, the registry points the browsers at it
+		dir, err := dataDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(dir, "NativeMessagingHosts")
+		return map[string]string{
+			"chrome":   filepath.Join(dir, "chrome"),
+			"chromium": filepath.Join(dir, "chromium"),
+			"firefox":  filepath.Join(dir, "firefox"),
+		}, nil
+	}
+	return nil, fmt.Errorf("installing the native host is not supported on %s", runtime.GOOS)
+}
+
+// nativeHostKeys are the registry keys, under HKEY_CURRENT_USER, where
+// browsers on windows look up the manifest of a native host.
+var nativeHostKeys = map[string]string{
+	"chrome":   `Software\Google\Chrome\NativeMessagingHosts\`,
+	"chromium": `Software\Chromium\NativeMessagingHosts\`,
+	"firefox":  `Software\Mozilla\NativeMessagingHosts\`,
+}
+
+// nativeHostWrapper returns the name and contents of the script the
+// manifest points at, which starts ggif as a native host.
+func nativeHostWrapper(dir string, exe string) (string, string) {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(dir, nativeHostName+".bat"),
+			fmt.Sprintf("@echo off\r\n\"%s\" native-host %%*\r\n", exe)
+	}
+	return filepath.Join(dir, nativeHostName+".sh"),
+		fmt.Sprintf("#!/bin/sh\nexec %s native-host \"$@\"\n", shellQuote(exe))
+}
+
+// installNativeHost registers ggif with the browsers named by --browser
+// for the extensions given with --extension-id.  Browsers start the host
+// with the calling extension as its argument, so the manifest points at a
+// script that runs ggif native-host.
+func installNativeHost(c *cli.Context) error {
+	ids := c.StringSlice("extension-id")
+	if len(ids) == 0 {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif native-host --install --extension-id <id>"))
+	}
+	dirs, err := nativeHostDirs()
+	if err != nil {
+		return exitError(exitUsage, err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var chromeOrigins, firefoxIDs []string
+	for _, id := range ids {
+		// firefox add-on ids look like an email address or a {uuid}
+		if strings.Contains(id, "@") || strings.HasPrefix(id, "{") {
+			firefoxIDs = append(firefoxIDs, id)
+		} else {
+			chromeOrigins = append(chromeOrigins, "chrome-extension://"+id+"/")
+		}
+	}
+
+	browsers := c.StringSlice("browser")
+	for _, browser := range browsers {
+		dir, ok := dirs[browser]
+		if !ok {
+			return exitError(exitUsage, fmt.Errorf("unknown browser %q, known: chrome, chromium, firefox", browser))
+		}
+		manifest := map[string]interface{}{
+			"name":        nativeHostName,
+			"description": "ggif converts recordings to gifs and uploads them",
+			"type":        "stdio",
+		}
+		if browser == "firefox" {
+			if len(firefoxIDs) == 0 {
+				continue
+			}
+			manifest["allowed_extensions"] = firefoxIDs
+		} else {
+			if len(chromeOrigins) == 0 {
+				continue
+			}
+			manifest["allowed_origins"] = chromeOrigins
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		script, wrapper := nativeHostWrapper(dir, exe)
+		if err := ioutil.WriteFile(script, []byte(wrapper), 0755); err != nil {
+			return err
+		}
+		manifest["path"] = script
+		data, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, nativeHostName+".json")
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		if runtime.GOOS == "windows" {
+			key := `HKCU\` + nativeHostKeys[browser] + nativeHostName
+			if err := runCmd(c.Context, c, "reg", "add", key, "/ve", "/t", "REG_SZ", "/d", path, "/f"); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Installed %s for %s\n", path, browser)
+	}
+	return nil
+}
+
+var nativeHostCommand = &cli.Command{
+	Name:  "native-host",
+	Usage: "talk to the ggif browser extension over native messaging",
+	Description: `Browsers start this with the calling extension as the argument; it reads
+   recordings from the extension on stdin, converts and uploads them, and
+   answers with the url on stdout, in the chrome and firefox native
+   messaging protocol.
+
+   Messages are json objects with a type:
+      {"type": "ping"}                                   answered with pong
+      {"type": "chunk", "data": "<base64>"}              part of a recording
+      {"type": "convert", "name": "tab.webm", "video": "<base64>"}
+      {"type": "convert", "path": "/path/to/tab.webm"}
+   A convert without video or path converts the chunks sent before it.  It
+   is answered with a stage message per stage and then a result, with the
+   url, or an error.
+
+   Examples:
+      ggif native-host --install --extension-id abcdefghijklmnopabcdefghijklmnop
+      ggif native-host --install --browser firefox --extension-id ggif@example.com`,
+	ArgsUsage: "[origin]",
+	Action:    nativeHost,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "install",
+			Usage: "register ggif with the browsers instead of running as a host",
+		},
+		&cli.StringSliceFlag{
+			Name:  "extension-id",
+			Usage: "id of an extension allowed to connect, repeatable",
+		},
+		&cli.StringSliceFlag{
+			Name:  "browser",
+			Value: cli.NewStringSlice("chrome", "chromium", "firefox"),
+			Usage: "browsers to register with: chrome, chromium, firefox",
+		},
+	},
+}
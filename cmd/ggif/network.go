@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+)
+
+// proxyURL parses the proxy setting, or else $HTTPS_PROXY, nil when
+// neither is set.
+func proxyURL(c *cli.Context) (*url.URL, error) {
+	proxy := c.String("proxy")
+	if proxy == "" {
+		proxy = os.Getenv("HTTPS_PROXY")
+	}
+	if proxy == "" {
+		proxy = os.Getenv("https_proxy")
+	}
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Hostname() == "" {
+		// like curl, a bare host:port is fine
+		if u, err = url.Parse("http://" + proxy); err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("bad proxy %q, want a url like http://proxy.example.com:3128", proxy)
+		}
+	}
+	return u, nil
+}
+
+// applyNetworkSettings makes the proxy and ca-file settings apply to every
+// connection ggif makes: its own http clients, which all use the default
+// transport, and the tools it runs, which inherit the proxy through the
+// environment.
+func applyNetworkSettings(c *cli.Context) error {
+	if proxy := c.String("proxy"); proxy != "" {
+		if _, err := proxyURL(c); err != nil {
+			return err
+		}
+		for _, key := range []string{"HTTPS_PROXY", "HTTP_PROXY", "https_proxy", "http_proxy"} {
+			os.Setenv(key, proxy)
+		}
+	}
+
+	caFile := c.String("ca-file")
+	if caFile == "" {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("can't read the ca-file: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// windows before go 1.18 can't hand out its pool
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in the ca-file %s", caFile)
+	}
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return nil
+}
+
+// defaultPartSize is the part size of uploads with --upload-parallelism
+// and no --upload-part-size.
+const defaultPartSize = 16 << 20
+
+// uploadPartSize is --upload-part-size, or defaultPartSize when only
+// --upload-parallelism is set, 0 for uploads in one piece.
+func uploadPartSize(c *cli.Context) uint64 {
+	if size, _ := parseSize(c.String("upload-part-size")); size > 0 {
+		return size
+	}
+	if c.Int("upload-parallelism") > 0 {
+		return defaultPartSize
+	}
+	return 0
+}
+
+// parallelUploadArgs turns on gsutil's parallel composite uploads with
+// --upload-part-size or --upload-parallelism: bigger gifs are cut into
+// parts of that size, sent --upload-parallelism at a time and put back
+// together in the bucket.
+func parallelUploadArgs(c *cli.Context) []string {
+	var opts []string
+	if size := uploadPartSize(c); size > 0 {
+		parts := strconv.FormatUint(size, 10)
+		opts = append(opts,
+			"-o", "GSUtil:parallel_composite_upload_threshold="+parts,
+			"-o", "GSUtil:parallel_composite_upload_component_size="+parts)
+	}
+	if n := c.Int("upload-parallelism"); n > 0 {
+		// threads rather than processes, which windows doesn't have
+		opts = append(opts,
+			"-o", "GSUtil:parallel_process_count=1",
+			"-o", "GSUtil:parallel_thread_count="+strconv.Itoa(n))
+	}
+	return opts
+}
+
+// gsutilArgs passes the proxy, ca-file, google credential and parallel
+// upload settings to gsutil, which takes them from its boto config rather
+// than the environment.
+func gsutilArgs(c *cli.Context, arg ...string) []string {
+	opts := append(gcsCredentialArgs(c), parallelUploadArgs(c)...)
+	if u, err := proxyURL(c); err == nil && u != nil {
+		opts = append(opts, "-o", "Boto:proxy="+u.Hostname())
+		if port := u.Port(); port != "" {
+			opts = append(opts, "-o", "Boto:proxy_port="+port)
+		}
+		// no proxy credentials on a command line anyone can read with ps,
+		// gsutil finds them in its boto config
+	}
+	if caFile := c.String("ca-file"); caFile != "" {
+		// unlike applyNetworkSettings this replaces gsutil's bundle rather
+		// than adding to it, see the ca-file usage
+		opts = append(opts, "-o", "Boto:ca_certificates_file="+caFile)
+	}
+	return append(opts, arg...)
+}
+
+// redactURL keeps only the scheme and host of a url whose path is a
+// secret, like that of an incoming webhook, for dry runs and errors.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "<secret>"
+	}
+	return u.Scheme + "://" + u.Host + "/<secret>"
+}
+
+// webhookError is err from posting to hook, without the url net/http puts
+// in it.
+func webhookError(hook string, err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s %s: %w", urlErr.Op, redactURL(hook), urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://hooks.slack.com/services/T000/B000/XXXX", "https://hooks.slack.com/<secret>"},
+		{"https://x.webhook.office.com/webhookb2/a@b/IncomingWebhook/c/d", "https://x.webhook.office.com/<secret>"},
+		{"https://prod.westus.logic.azure.com:443/workflows/1/triggers/manual/paths/invoke?sig=s3cret", "https://prod.westus.logic.azure.com:443/<secret>"},
+		{"not a url", "<secret>"},
+		{"", "<secret>"},
+	}
+	for _, tt := range tests {
+		if got := redactURL(tt.in); got != tt.want {
+			t.Errorf("redactURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	hook := "https://hooks.slack.com/services/T000/B000/XXXX"
+	err := webhookError(hook, &url.Error{Op: "Post", URL: hook, Err: errors.New("connection refused")})
+	if strings.Contains(err.Error(), "XXXX") {
+		t.Errorf("the webhook leaked: %v", err)
+	}
+	if !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("the cause was lost: %v", err)
+	}
+}
+
+func TestParallelUploadArgs(t *testing.T) {
+	tests := []struct {
+		partSize    string
+		parallelism int
+		want        string
+	}{
+		{"", 0, ""},
+		{"1M", 0, "-o GSUtil:parallel_composite_upload_threshold=1048576 -o GSUtil:parallel_composite_upload_component_size=1048576"},
+		{"", 4, "-o GSUtil:parallel_composite_upload_threshold=16777216 -o GSUtil:parallel_composite_upload_component_size=16777216 -o GSUtil:parallel_process_count=1 -o GSUtil:parallel_thread_count=4"},
+		{"1M", 4, "-o GSUtil:parallel_composite_upload_threshold=1048576 -o GSUtil:parallel_composite_upload_component_size=1048576 -o GSUtil:parallel_process_count=1 -o GSUtil:parallel_thread_count=4"},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("upload-part-size", tt.partSize, "")
+		set.Int("upload-parallelism", tt.parallelism, "")
+		c := cli.NewContext(nil, set, nil)
+		if got := strings.Join(parallelUploadArgs(c), " "); got != tt.want {
+			t.Errorf("parallelUploadArgs(%q, %d) = %q, want %q", tt.partSize, tt.parallelism, got, tt.want)
+		}
+	}
+}
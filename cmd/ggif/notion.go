@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// notionVersion is the version of the Notion API the requests are written
+// against.
+const notionVersion = "2022-06-28"
+
+// notionAPI is where the Notion API is served.
+const notionAPI = "https://api.notion.com/v1"
+
+var notionIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// notionPageID returns the id of the page in the notion-page setting, which
+// may be the id itself, dashed or not, or the url of the page.
+func notionPageID(page string) (string, error) {
+	// in a url the id ends the last part of the path, after the title
+	if i := strings.IndexAny(page, "?#"); i >= 0 {
+		page = page[:i]
+	}
+	id := strings.ReplaceAll(path.Base(page), "-", "")
+	if len(id) > 32 {
+		id = id[len(id)-32:]
+	}
+	if !notionIDPattern.MatchString(id) {
+		return "", fmt.Errorf("bad notion-page %q, want the url or id of a page", page)
+	}
+	return id, nil
+}
+
+// shareNotion appends the url as an embed block to the page in the
+// notion-page setting, captioned with the name of the recording.
+func shareNotion(c *cli.Context, e *historyEntry) (string, error) {
+	token, err := secretSetting(c, "notion-token")
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", fmt.Errorf("no notion token configured, set $GGIF_NOTION_TOKEN or notion-token to a keyring: or exec: reference to it")
+	}
+	if c.String("notion-page") == "" {
+		return "", fmt.Errorf("no notion page configured, set one with `ggif config set notion-page <url>`")
+	}
+	page, err := notionPageID(c.String("notion-page"))
+	if err != nil {
+		return "", err
+	}
+	if len(e.URLs) == 0 {
+		return "", fmt.Errorf("%s was never uploaded", e.Output)
+	}
+	endpoint := fmt.Sprintf("%s/blocks/%s/children", notionAPI, page)
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "PATCH %s %s\n", endpoint, e.URLs[0])
+		return e.URLs[0], nil
+	}
+
+	caption := []map[string]interface{}{
+		{"type": "text", "text": map[string]string{"content": filepath.Base(e.Source)}},
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"children": []map[string]interface{}{{
+			"object": "block",
+			"type":   "embed",
+			"embed":  map[string]interface{}{"url": e.URLs[0], "caption": caption},
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Notion-Version", notionVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// the api explains itself, e.g. that the page isn't shared with
+		// the integration
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return "", fmt.Errorf("notion: %s: %s", resp.Status, apiErr.Message)
+		}
+		return "", fmt.Errorf("notion: %s", resp.Status)
+	}
+	return e.URLs[0], nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// obsPreset are the settings --obs applies unless they are set some other
+// way: small, choppy gifs of screen captures read fine and stay shareable.
+var obsPreset = map[string]string{
+	"width":   "640",
+	"frames":  "15",
+	"quality": "90",
+}
+
+// readINI reads the sections of an ini file, as OBS writes them, into
+// maps of their keys.
+func readINI(fname string) (map[string]map[string]string, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	// OBS on windows starts its files with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	sections := make(map[string]map[string]string)
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = line[1 : len(line)-1]
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 {
+				continue
+			}
+			if sections[section] == nil {
+				sections[section] = make(map[string]string)
+			}
+			sections[section][strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return sections, scanner.Err()
+}
+
+// obsConfigDirs are the folders OBS keeps its config in, the flatpak one
+// included.
+func obsConfigDirs() []string {
+	var dirs []string
+	if dir, err := os.UserConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(dir, "obs-studio"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".var", "app", "com.obsproject.Studio", "config", "obs-studio"))
+	}
+	return dirs
+}
+
+// obsRecordingDir returns the folder OBS saves recordings to, from the
+// output settings of the profile in use.
+func obsRecordingDir() (string, error) {
+	for _, dir := range obsConfigDirs() {
+		var profile string
+		// user.ini took over from global.ini in OBS 31
+		for _, name := range []string{"user.ini", "global.ini"} {
+			global, err := readINI(filepath.Join(dir, name))
+			if err == nil && global["Basic"]["ProfileDir"] != "" {
+				profile = global["Basic"]["ProfileDir"]
+				break
+			}
+		}
+		if profile == "" {
+			continue
+		}
+
+		fname := filepath.Join(dir, "basic", "profiles", profile, "basic.ini")
+		basic, err := readINI(fname)
+		if err != nil {
+			return "", fmt.Errorf("can't read the OBS profile: %v", err)
+		}
+		path := basic["SimpleOutput"]["FilePath"]
+		if basic["Output"]["Mode"] == "Advanced" {
+			path = basic["AdvOut"]["RecFilePath"]
+			if basic["AdvOut"]["RecType"] == "FFmpeg" {
+				path = basic["AdvOut"]["FFFilePath"]
+			}
+		}
+		if path == "" {
+			return "", fmt.Errorf("%s sets no recording folder", fname)
+		}
+		return filepath.FromSlash(path), nil
+	}
+	return "", fmt.Errorf("no OBS config found in %s", strings.Join(obsConfigDirs(), " or "))
+}
+
+// setupOBS points the watcher at the OBS recording folder, applies the
+// preset and archives the converted recordings in the folder's ggif
+// subfolder, unless --archive says otherwise.
+func setupOBS(c *cli.Context) error {
+	dir, err := obsRecordingDir()
+	if err != nil {
+		return err
+	}
+	log.Infof("Watching the OBS recording folder %s", dir)
+	if err := setFlag(c, "src", dir); err != nil {
+		return err
+	}
+	if !c.IsSet("archive") {
+		if err := setFlag(c, "archive", filepath.Join(dir, "ggif")); err != nil {
+			return err
+		}
+	}
+	for key, value := range obsPreset {
+		if _, ok := settingSources[key]; ok {
+			// given with a flag, in the environment or a config file
+			continue
+		}
+		if err := setFlag(c, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveSource moves a converted recording, and its sidecar, into the
+// archive folder, which is relative to the folder the recording is in
+// unless it is absolute.
+func archiveSource(c *cli.Context, videoFile string) error {
+	dir := c.String("archive")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(videoFile), dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, filepath.Base(videoFile))
+	if fileExists(dest) {
+		return fmt.Errorf("can't archive %s, %s exists", videoFile, dest)
+	}
+	if err := os.Rename(videoFile, dest); err != nil {
+		return err
+	}
+	if sidecar := sidecarName(videoFile); fileExists(sidecar) {
+		return os.Rename(sidecar, sidecarName(dest))
+	}
+	return nil
+}
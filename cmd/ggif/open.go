@@ -0,0 +1,33 @@
+package main
+
+import (
+	"runtime"
+
+	"github.com/urfave/cli/v2"
+)
+
+// openCommand returns the command that hands a file or url to the desktop's
+// default application.
+func openCommand(target string) (string, []string) {
+	switch runtime.GOOS {
+	case "darwin":
+		return "open", []string{target}
+	case "windows":
+		// the empty argument is the window title start expects first
+		return "cmd", []string{"/c", "start", "", target}
+	}
+	return "xdg-open", []string{target}
+}
+
+// openResult launches the gif and/or its url when --open or --open-url are
+// given, so the result can be checked before it is shared.
+func openResult(c *cli.Context, res *result) {
+	if c.Bool("open") {
+		name, args := openCommand(res.Output)
+		printError(runCmd(c.Context, c, name, args...))
+	}
+	if c.Bool("open-url") && len(res.URLs) > 0 {
+		name, args := openCommand(res.URLs[0])
+		printError(runCmd(c.Context, c, name, args...))
+	}
+}
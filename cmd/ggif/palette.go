@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// monoPalette is the palette setting "mono".
+var monoPalette = color.Palette{color.Black, color.White}
+
+// parseHexColor parses #rgb or #rrggbb, the # being optional.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(s), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("bad color %q, want #rrggbb", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+// imagePalette takes the colors of a swatch or palette image.
+func imagePalette(fname string) (color.Palette, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	var palette color.Palette
+	seen := make(map[color.RGBA]bool)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A == 0 || seen[c] {
+				continue
+			}
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	return palette, nil
+}
+
+// parsePalette parses the palette setting: mono, a list of colors like
+// #1d1d1b,#ff5a00,#fff, or a png or gif whose colors make the palette.
+// The transparent color gifs need for unchanged pixels is added at the end.
+func parsePalette(s string) (color.Palette, error) {
+	var palette color.Palette
+	switch {
+	case s == "":
+		return nil, nil
+	case s == "mono":
+		palette = append(palette, monoPalette...)
+	case fileExists(s):
+		var err error
+		if palette, err = imagePalette(s); err != nil {
+			return nil, err
+		}
+	default:
+		for _, part := range strings.Split(s, ",") {
+			c, err := parseHexColor(part)
+			if err != nil {
+				return nil, fmt.Errorf("%v, or mono, or the path of a png or gif", err)
+			}
+			palette = append(palette, c)
+		}
+	}
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("%s has no colors", s)
+	}
+	if len(palette) > sharedPaletteColors {
+		return nil, fmt.Errorf("%s has %d colors, at most %d fit in a gif next to the transparent one", s, len(palette), sharedPaletteColors)
+	}
+	return append(palette, color.RGBA{}), nil
+}
+
+func paletteValue(value interface{}) error {
+	_, err := parsePalette(value.(string))
+	return err
+}
+
+// ditherGif dithers every frame of g to palette with Floyd-Steinberg.
+// gifski's frames only hold the pixels that changed, which can't be
+// dithered on their own, so the frames are put together as they would be
+// shown and each one's region is dithered from that.  The frames keep
+// their bounds, disposal and unchanged pixels, which stay transparent.
+func ditherGif(g *gif.GIF, palette color.Palette) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	clear := uint8(len(palette) - 1)
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		r := frame.Bounds()
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(r)
+			draw.Draw(previous, r, canvas, r.Min, draw.Src)
+		}
+		draw.Draw(canvas, r, frame, r.Min, draw.Over)
+
+		dithered := image.NewPaletted(r, palette)
+		draw.FloydSteinberg.Draw(dithered, r, canvas, r.Min)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if _, _, _, a := frame.At(x, y).RGBA(); a == 0 {
+					dithered.SetColorIndex(x, y, clear)
+				}
+			}
+		}
+		g.Image[i] = dithered
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			draw.Draw(canvas, r, previous, r.Min, draw.Src)
+		}
+	}
+}
+
+// applyPalette maps the gif to the colors of the palette setting, dithered
+// unless dither is none, for on-brand gifs with few colors.  Without a
+// palette the gif is left alone.
+func applyPalette(ctx context.Context, c *cli.Context, path string) error {
+	palette, err := parsePalette(c.String("palette"))
+	if err != nil {
+		return err
+	}
+	if palette == nil || c.Bool("dry-run") {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	g, err := gif.DecodeAll(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("palette: %v", err)
+	}
+	if c.String("dither") == "none" {
+		for _, img := range g.Image {
+			usePalette(img, palette)
+		}
+	} else {
+		ditherGif(g, palette)
+	}
+	// written once as the global color table
+	g.Config.ColorModel = palette
+	g.BackgroundIndex = 0
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return err
+	}
+	tmp := partialName(path)
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestDitherGif(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0xff}
+	clear := color.RGBA{}
+	first := image.NewPaletted(image.Rect(0, 0, 8, 8), color.Palette{gray})
+	// the second frame only changes one pixel of its region
+	second := image.NewPaletted(image.Rect(2, 2, 6, 6), color.Palette{clear, color.White})
+	second.SetColorIndex(3, 3, 1)
+	g := &gif.GIF{
+		Image:    []*image.Paletted{first, second},
+		Delay:    []int{10, 10},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious},
+		Config:   image.Config{Width: 8, Height: 8},
+	}
+
+	palette := append(append(color.Palette{}, monoPalette...), clear)
+	ditherGif(g, palette)
+
+	if len(g.Disposal) != 2 || g.Disposal[1] != gif.DisposalPrevious {
+		t.Errorf("disposal = %v, want it kept", g.Disposal)
+	}
+	if got := g.Image[1].Bounds(); got != second.Bounds() {
+		t.Errorf("second frame bounds = %v, want %v", got, second.Bounds())
+	}
+
+	// a mid gray dithers to both black and white
+	seen := map[uint8]bool{}
+	for _, index := range g.Image[0].Pix {
+		seen[index] = true
+	}
+	if !seen[0] || !seen[1] || seen[2] {
+		t.Errorf("first frame uses colors %v, want black and white only", seen)
+	}
+
+	out := g.Image[1]
+	for y := 2; y < 6; y++ {
+		for x := 2; x < 6; x++ {
+			index := out.ColorIndexAt(x, y)
+			switch {
+			case x == 3 && y == 3 && index != 1:
+				t.Errorf("the changed pixel is %d, want white", index)
+			case (x != 3 || y != 3) && index != 2:
+				t.Errorf("unchanged pixel %d,%d is %d, want transparent", x, y, index)
+			}
+		}
+	}
+}
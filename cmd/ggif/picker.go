@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pickerLimit is how many recordings the picker lists at once.
+const pickerLimit = 15
+
+// isTerminal reports whether f is attached to a terminal rather than a pipe
+// or file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// recentVideos lists the recordings in dir, newest first.
+func recentVideos(dir string) []os.FileInfo {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+
+	var videos []os.FileInfo
+	for _, f := range files {
+		if isRecording(filepath.Join(dir, f.Name())) {
+			videos = append(videos, f)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].ModTime().After(videos[j].ModTime())
+	})
+	return videos
+}
+
+// fuzzyMatch reports whether the letters of pattern appear in name in order,
+// ignoring case.
+func fuzzyMatch(pattern string, name string) bool {
+	name = strings.ToLower(name)
+	for _, r := range strings.ToLower(pattern) {
+		i := strings.IndexRune(name, r)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(string(r)):]
+	}
+	return true
+}
+
+func humanSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// pickVideo lets the user choose one of the recordings in dir: enter picks
+// the newest, a number picks that line and anything else filters the list.
+// It returns "" when there is nothing to pick or the user quits.
+func pickVideo(dir string) string {
+	videos := recentVideos(dir)
+	if len(videos) == 0 {
+		return ""
+	}
+
+	filter := ""
+	for {
+		var shown []os.FileInfo
+		for _, v := range videos {
+			if fuzzyMatch(filter, v.Name()) {
+				shown = append(shown, v)
+			}
+			if len(shown) == pickerLimit {
+				break
+			}
+		}
+
+		fmt.Fprintln(os.Stderr)
+		for i, v := range shown {
+			length := ""
+			if info, err := probe(context.Background(), filepath.Join(dir, v.Name())); err == nil && info.Duration > 0 {
+				length = (time.Duration(info.Duration) * time.Second).String()
+			}
+			fmt.Fprintf(os.Stderr, "%3d) %-40s %10s %8s  %s\n",
+				i+1, v.Name(), humanSize(v.Size()), length, v.ModTime().Format("Jan 2 15:04"))
+		}
+		if len(shown) == 0 {
+			fmt.Fprintf(os.Stderr, "  nothing matches %q\n", filter)
+		}
+
+		fmt.Fprint(os.Stderr, "Pick a recording (enter for 1, a number, text to filter, q to quit): ")
+		answer, ok := <-readStdin()
+		answer = strings.TrimSpace(answer)
+		switch {
+		case !ok || answer == "q":
+			return ""
+		case answer == "" && len(shown) > 0:
+			return filepath.Join(dir, shown[0].Name())
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(shown) {
+			return filepath.Join(dir, shown[n-1].Name())
+		}
+		filter = answer
+	}
+}
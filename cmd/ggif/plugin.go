@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// pluginPrefix names uploader plugins: --uploader imgur runs
+// ggif-upload-imgur from the PATH.
+const pluginPrefix = "ggif-upload-"
+
+// pluginRequest is the json an uploader plugin reads from stdin.  The
+// plugin uploads file and prints its url as the first line of stdout;
+// printing nothing means it chose not to upload, e.g. because if_exists is
+// skip and the name is taken.  A non-zero exit fails the upload, with the
+// last line of stderr as the reason.
+type pluginRequest struct {
+	// File is the absolute path of the gif.
+	File string `json:"file"`
+	// Name is the file name ggif would like at the destination.
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+	IfExists string `json:"if_exists"`
+	// Bucket is the bucket setting, for plugins that have a use for it.
+	Bucket string `json:"bucket,omitempty"`
+	// LimitRate is the --limit-rate setting in bytes per second, which the
+	// plugin should keep its upload under; 0 is no limit.
+	LimitRate uint64 `json:"limit_rate,omitempty"`
+	// PartSize and Parallelism are --upload-part-size and
+	// --upload-parallelism, for plugins that can send a big gif in parts
+	// at once; 0 leaves it to the plugin.
+	PartSize    uint64 `json:"part_size,omitempty"`
+	Parallelism int    `json:"parallelism,omitempty"`
+	// CAFile is the --ca-file setting, extra certificate authorities the
+	// plugin should trust.
+	CAFile string `json:"ca_file,omitempty"`
+	// Provenance is the recording the gif was made from, with
+	// --provenance, for plugins to store as object metadata.
+	Provenance *provenance `json:"provenance,omitempty"`
+	// StorageClass is --source-storage-class for recordings uploaded with
+	// --include-source.
+	StorageClass string `json:"storage_class,omitempty"`
+}
+
+// usesGCS reports whether gifs go to google cloud storage rather than
+// through a plugin.
+func usesGCS(c *cli.Context) bool {
+	name := c.String("uploader")
+	return name == "" || name == "gcs"
+}
+
+// uploaderTool is the program uploads need: gsutil or the plugin.
+func uploaderTool(c *cli.Context) string {
+	if usesGCS(c) {
+		return "gsutil"
+	}
+	return pluginPrefix + c.String("uploader")
+}
+
+// checkUploader makes sure uploads have somewhere to go.
+func checkUploader(c *cli.Context) error {
+	if usesGCS(c) && c.String("bucket") == "" {
+		return exitError(exitConfig, fmt.Errorf("no bucket configured, set one with `ggif config set bucket <name>`"))
+	}
+	return nil
+}
+
+// uploadPlugin hands the gif to the ggif-upload-<name> plugin and returns
+// the url it printed.
+func uploadPlugin(ctx context.Context, c *cli.Context, outfn string, outputFile string) (string, error) {
+	plugin := uploaderTool(c)
+	if !confirmUpload(c, outfn, plugin) {
+		log.Warningf("Not uploading %s", outfn)
+		return "", nil
+	}
+
+	req := pluginRequest{
+		File:         outfn,
+		Name:         outputFile,
+		IfExists:     c.String("if-exists"),
+		Bucket:       c.String("bucket"),
+		LimitRate:    uploadRate(c),
+		PartSize:     uploadPartSize(c),
+		Parallelism:  c.Int("upload-parallelism"),
+		CAFile:       c.String("ca-file"),
+		Provenance:   uploadProvenance(ctx, c),
+		StorageClass: uploadStorageClass(ctx),
+	}
+	if abs, err := filepath.Abs(outfn); err == nil {
+		req.File = abs
+	}
+	if fi, err := os.Stat(outfn); err == nil {
+		req.Size = fi.Size()
+	}
+	req.SHA256, _ = fileSHA256(outfn)
+	dest := fmt.Sprintf("%s:%s/%s", c.String("uploader"), c.String("bucket"), outputFile)
+	req.File = keepForResume(c, req.File, dest)
+	input, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "%s <<< %s\n", plugin, shellQuote(string(input)))
+		return "", nil
+	}
+
+	// until it finishes, ggif resume can run it again
+	rememberUpload(c, pendingUpload{File: req.File, Dest: dest, Plugin: plugin, Request: input, Started: time.Now()})
+	url, err := runPlugin(ctx, c, plugin, input)
+	if err != nil {
+		return "", fmt.Errorf("%w, run `ggif resume` to try the upload again", err)
+	}
+	forgetUpload(c, dest)
+	if url == "" {
+		log.Warningf("%s did not upload %s", plugin, outfn)
+	}
+	return url, nil
+}
+
+// runPlugin runs an uploader plugin with input as its request and returns
+// the url it printed.
+func runPlugin(ctx context.Context, c *cli.Context, plugin string, input []byte) (string, error) {
+	cmd := exec.Command(plugin)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	log.Debug(cmd.Args)
+	err := runTool(ctx, cmd, toolTimeout(c, true))
+	output := stdout.Bytes()
+	printOutput(stderr.Bytes())
+	logCommand(ctx, cmd.Args, append(stderr.Bytes(), output...), err)
+	if err != nil {
+		if isTimeout(err) || ctx.Err() != nil {
+			return "", fmt.Errorf("%s: %w", plugin, err)
+		}
+		if last := lastLine(stderr.String()); last != "" {
+			return "", fmt.Errorf("%s: %w: %s", plugin, err, last)
+		}
+		return "", fmt.Errorf("%s: %w", plugin, err)
+	}
+
+	return strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0]), nil
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
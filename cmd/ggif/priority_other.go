@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os/exec"
+
+// lowPriority makes cmd run under nice, and ionice where there is one, so
+// it only gets the cpu and disk time nothing else wants.
+func lowPriority(cmd *exec.Cmd) {
+	var prefix []string
+	if _, err := exec.LookPath("ionice"); err == nil {
+		// lowest best-effort level, the idle class needs root on old kernels
+		prefix = append(prefix, "ionice", "-c", "2", "-n", "7")
+	}
+	nice, err := exec.LookPath("nice")
+	if err != nil {
+		return
+	}
+	cmd.Path = nice
+	cmd.Args = append(append([]string{"nice", "-n", "10"}, prefix...), cmd.Args...)
+}
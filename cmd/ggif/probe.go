@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeInfo is what ffprobe tells us about a video.
+type probeInfo struct {
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Duration float64 `json:"duration"`
+	// FPS is the average frame rate, 0 when ffprobe doesn't know it.
+	FPS float64 `json:"fps,omitempty"`
+}
+
+// probedFile identifies a file as it was when it was probed.
+type probedFile struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// maxProbed bounds how many answers probe remembers.
+const maxProbed = 256
+
+var (
+	probedMu sync.Mutex
+	probed   = make(map[probedFile]*probeInfo)
+)
+
+// probe asks ffprobe for the dimensions and frame rate of the first video
+// stream and the duration of the file.  The answer is remembered until the
+// file changes, as a conversion needs it at several stages.
+func probe(ctx context.Context, fname string) (*probeInfo, error) {
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	key := probedFile{fname, fi.Size(), fi.ModTime()}
+	probedMu.Lock()
+	info, ok := probed[key]
+	probedMu.Unlock()
+	if ok {
+		return info, nil
+	}
+
+	info, err = runProbe(ctx, fname)
+	if err != nil {
+		return nil, err
+	}
+	probedMu.Lock()
+	if len(probed) >= maxProbed {
+		// servers see a new file per request
+		probed = make(map[probedFile]*probeInfo)
+	}
+	probed[key] = info
+	probedMu.Unlock()
+	return info, nil
+}
+
+func runProbe(ctx context.Context, fname string) (*probeInfo, error) {
+	out, err := exec.CommandContext(
+		ctx,
+		"ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,avg_frame_rate:format=duration",
+		"-of", "json",
+		fname,
+	).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed struct {
+		Streams []struct {
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, err
+	}
+
+	info := &probeInfo{}
+	if len(parsed.Streams) > 0 {
+		info.Width = parsed.Streams[0].Width
+		info.Height = parsed.Streams[0].Height
+		info.FPS = parseRate(parsed.Streams[0].AvgFrameRate)
+	}
+	info.Duration, _ = strconv.ParseFloat(parsed.Format.Duration, 64)
+	return info, nil
+}
+
+// checkVideo fails when fname is not a video ffmpeg can decode: ffprobe
+// has to find a video stream in it or, when ffprobe isn't installed, its
+// header has to look like a video container.  asciinema recordings pass.
+func checkVideo(ctx context.Context, fname string) error {
+	if isCastFile(fname) {
+		// played into a video by renderPartial
+		return nil
+	}
+	info, err := probe(ctx, fname)
+	switch {
+	case err != nil && isMissingTool(err):
+		if !isVideoFile(fname) {
+			return fmt.Errorf("%s is not a video file", fname)
+		}
+	case err != nil:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if last := lastLine(string(exitErr.Stderr)); last != "" {
+				return fmt.Errorf("%s is not a video ffmpeg can read: %s", fname, strings.TrimPrefix(last, fname+": "))
+			}
+		}
+		return fmt.Errorf("%s is not a video ffmpeg can read: %v", fname, err)
+	case info.Width == 0:
+		return fmt.Errorf("%s has no video stream", fname)
+	}
+	return nil
+}
+
+// parseRate parses a frame rate as ffprobe prints it, e.g. 30000/1001.
+func parseRate(rate string) float64 {
+	num, den := rate, "1"
+	if i := strings.Index(rate, "/"); i >= 0 {
+		num, den = rate[:i], rate[i+1:]
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+)
+
+// provenance ties an uploaded gif to the recording it was made from.
+type provenance struct {
+	// Source is the file name of the recording, without its folder.
+	Source       string `json:"source"`
+	SourceSHA256 string `json:"source_sha256"`
+	// SHA256 is the checksum of the gif, empty when it is uploaded while
+	// still being written.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+type provenanceKey struct{}
+
+// withProvenance makes the source of res known to the uploaders called
+// with the returned context.
+func withProvenance(ctx context.Context, res *result) context.Context {
+	if res.SourceSHA256 == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, provenanceKey{}, &provenance{
+		Source:       filepath.Base(res.Source),
+		SourceSHA256: res.SourceSHA256,
+		SHA256:       res.SHA256,
+	})
+}
+
+// uploadProvenance returns what uploaders should attach to the object with
+// --provenance, nil without it or when the gif's source is unknown.
+func uploadProvenance(ctx context.Context, c *cli.Context) *provenance {
+	if !c.Bool("provenance") {
+		return nil
+	}
+	p, _ := ctx.Value(provenanceKey{}).(*provenance)
+	return p
+}
+
+// provenanceHeaders are the gsutil options that store the provenance as
+// custom object metadata, readable with gsutil stat.
+func provenanceHeaders(ctx context.Context, c *cli.Context) []string {
+	p := uploadProvenance(ctx, c)
+	if p == nil {
+		return nil
+	}
+	headers := []string{
+		"-h", "x-goog-meta-ggif-source:" + url.PathEscape(p.Source),
+		"-h", "x-goog-meta-ggif-source-sha256:" + p.SourceSHA256,
+	}
+	if p.SHA256 != "" {
+		headers = append(headers, "-h", "x-goog-meta-ggif-sha256:"+p.SHA256)
+	}
+	return headers
+}
+
+// sourceOf returns the history entry of the conversion that made the gif
+// with the checksum sum, nil when ggif didn't make it.
+func sourceOf(sum string) *historyEntry {
+	if sum == "" {
+		return nil
+	}
+	entries, err := readHistory(1, func(e *historyEntry) bool {
+		return e.SHA256 == sum && e.SourceSHA256 != ""
+	})
+	if err != nil {
+		log.Warningf("Could not read history: %v", err)
+		return nil
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return entries[0]
+}
+
+// withGifProvenance is withProvenance for a gif given to ggif rather than
+// made in this run, looked up in the history by its checksum sum.
+func withGifProvenance(ctx context.Context, c *cli.Context, sum string) context.Context {
+	if !c.Bool("provenance") {
+		return ctx
+	}
+	e := sourceOf(sum)
+	if e == nil {
+		return ctx
+	}
+	return withProvenance(ctx, &result{Source: e.Source, SourceSHA256: e.SourceSHA256, SHA256: sum})
+}
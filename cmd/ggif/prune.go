@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// parseAge parses an age like 30d, 2w or 36h.
+func parseAge(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	for suffix, unit := range units {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, suffix)); err == nil && strings.HasSuffix(s, suffix) && n >= 0 {
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("bad age %q, want something like 30d, 2w or 36h", s)
+	}
+	return d, nil
+}
+
+// pruneCandidate is a gif ggif made, with the time it was last converted
+// or uploaded.
+type pruneCandidate struct {
+	output string
+	last   time.Time
+}
+
+// pruneCandidates returns the gifs in the history that are still there,
+// newest first.  Gifs given to ggif upload are the user's own and left
+// alone.
+func pruneCandidates() ([]pruneCandidate, error) {
+	seen := make(map[string]bool)
+	var candidates []pruneCandidate
+	_, err := readHistory(0, func(e *historyEntry) bool {
+		if e.Output == "" || e.Source == e.Output || seen[e.Output] {
+			return false
+		}
+		// the newest entry for a gif comes first
+		seen[e.Output] = true
+		if fileExists(e.Output) {
+			candidates = append(candidates, pruneCandidate{e.Output, e.Time})
+		}
+		return false
+	})
+	return candidates, err
+}
+
+// removeFile removes fname, or only says so with --dry-run, and returns
+// how much space it took.
+func removeFile(c *cli.Context, fname string) (int64, error) {
+	var size int64
+	err := filepath.Walk(fname, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "would remove %s (%s)\n", fname, humanSize(size))
+		return size, nil
+	}
+	if err := os.RemoveAll(fname); err != nil {
+		return 0, err
+	}
+	log.Infof("Removed %s", fname)
+	return size, nil
+}
+
+// pruneTemp removes the folders in the temp area older than maxAge that
+// ggif made, unless the process that made them is still running.
+func pruneTemp(c *cli.Context, maxAge time.Duration) (int, int64, error) {
+	root := tempDir(c)
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	var removed int
+	var freed int64
+	for _, fi := range entries {
+		name := fi.Name()
+		if !fi.IsDir() || time.Since(fi.ModTime()) < maxAge {
+			continue
+		}
+		if pid, ok := tempDirOwner(name); ok {
+			// a long conversion or a watch still using it
+			if pid == os.Getpid() || processAlive(pid) {
+				continue
+			}
+		} else if !strings.HasPrefix(name, "pngs") {
+			continue
+		}
+		size, err := removeFile(c, filepath.Join(root, name))
+		if err != nil {
+			return removed, freed, err
+		}
+		removed++
+		freed += size
+	}
+	return removed, freed, nil
+}
+
+func prune(c *cli.Context) error {
+	if !c.IsSet("older-than") && !c.IsSet("keep") {
+		return exitError(exitUsage, fmt.Errorf("say what to keep with --older-than, --keep or both"))
+	}
+	maxAge := time.Duration(0)
+	if c.IsSet("older-than") {
+		var err error
+		if maxAge, err = parseAge(c.String("older-than")); err != nil {
+			return exitError(exitUsage, err)
+		}
+	}
+	keep := c.Int("keep")
+	if keep < 0 {
+		return exitError(exitUsage, fmt.Errorf("--keep must be at least 0, got %d", keep))
+	}
+
+	candidates, err := pruneCandidates()
+	if err != nil {
+		return err
+	}
+	var removed int
+	var freed int64
+	for i, cand := range candidates {
+		if i < keep || time.Since(cand.last) < maxAge {
+			continue
+		}
+		for _, fname := range []string{cand.output, thumbnailName(cand.output)} {
+			if !fileExists(fname) {
+				continue
+			}
+			size, err := removeFile(c, fname)
+			if err != nil {
+				return err
+			}
+			freed += size
+		}
+		removed++
+	}
+	summary := fmt.Sprintf("%d gif(s)", removed)
+
+	if c.Bool("temp") {
+		tempAge := maxAge
+		if tempAge == 0 {
+			tempAge = tempPruneAge
+		}
+		n, size, err := pruneTemp(c, tempAge)
+		if err != nil {
+			return err
+		}
+		freed += size
+		summary += fmt.Sprintf(" and %d temp folder(s)", n)
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Printf("Would remove %s, freeing %s\n", summary, humanSize(freed))
+	} else {
+		fmt.Printf("Removed %s, freed %s\n", summary, humanSize(freed))
+	}
+	return nil
+}
+
+var pruneCommand = &cli.Command{
+	Name:  "prune",
+	Usage: "remove old gifs ggif made, and optionally old temp folders",
+	Description: `Only gifs in the history, and their thumbnails, are removed; gifs given to
+   ggif upload and everything else in the output folder stay.  The history
+   itself is kept.  With both --older-than and --keep, gifs are removed when
+   they are older and not among the newest.
+
+   Examples:
+      ggif prune --older-than 30d --keep 50
+      ggif prune --keep 100 --temp
+      ggif --dry-run prune --older-than 2w`,
+	Action: prune,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "older-than",
+			Usage: "remove gifs last converted or uploaded longer ago than this, like 30d, 2w or 36h",
+		},
+		&cli.IntFlag{
+			Name:  "keep",
+			Usage: "always keep this many of the newest gifs",
+		},
+		&cli.BoolFlag{
+			Name:  "temp",
+			Usage: "also remove ggif's folders in the temp area older than --older-than, or a day, unless the ggif using them is still running",
+		},
+	},
+}
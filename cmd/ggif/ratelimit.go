@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// parseRateLimit parses a rate like 2MB/s, or a plain size, into bytes a
+// second.
+func parseRateLimit(s string) (uint64, error) {
+	trimmed := strings.TrimSpace(s)
+	if i := strings.LastIndex(trimmed, "/"); i >= 0 && strings.EqualFold(trimmed[i:], "/s") {
+		trimmed = trimmed[:i]
+	}
+	rate, err := parseSize(trimmed)
+	if err != nil {
+		return 0, fmt.Errorf("must be a rate like 500K/s or 2MB/s, got %q", s)
+	}
+	return rate, nil
+}
+
+// uploadRate is --limit-rate in bytes a second, 0 for no limit.
+func uploadRate(c *cli.Context) uint64 {
+	// checked by checkSettings
+	rate, _ := parseRateLimit(c.String("limit-rate"))
+	return rate
+}
+
+// rateLimiter reads no faster than rate bytes a second on average.
+type rateLimiter struct {
+	r     io.Reader
+	rate  uint64
+	start time.Time
+	read  uint64
+}
+
+// limitRate returns r limited to rate bytes a second, or r itself when rate
+// is 0.
+func limitRate(r io.Reader, rate uint64) io.Reader {
+	if rate == 0 {
+		return r
+	}
+	return &rateLimiter{r: r, rate: rate}
+}
+
+func (l *rateLimiter) Read(p []byte) (int, error) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	// a tenth of a second's worth at a time keeps the rate smooth
+	if chunk := l.rate / 10; chunk > 0 && uint64(len(p)) > chunk {
+		p = p[:chunk]
+	}
+	n, err := l.r.Read(p)
+	l.read += uint64(n)
+	due := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if wait := due - time.Since(l.start); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
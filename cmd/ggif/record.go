@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// region is a part of the screen, in pixels.
+type region struct {
+	X, Y, W, H int
+}
+
+// parseRegion parses a region in X11 geometry form, WxH+X+Y, as slop
+// prints it.
+func parseRegion(s string) (*region, error) {
+	var r region
+	if _, err := fmt.Sscanf(s, "%dx%d+%d+%d", &r.W, &r.H, &r.X, &r.Y); err != nil || r.W <= 0 || r.H <= 0 {
+		return nil, fmt.Errorf("bad region %q, want WxH+X+Y like 1280x720+0+0", s)
+	}
+	return &r, nil
+}
+
+// selectRegion lets the user drag out the part of the screen to record,
+// with slurp on wayland and slop on X11.
+func selectRegion(ctx context.Context) (*region, error) {
+	var cmd *exec.Cmd
+	switch {
+	case runtime.GOOS == "darwin":
+		// screencapture -i only hands back the image, not where it was
+		return nil, fmt.Errorf("selecting a region is not supported on macOS, give it with --region")
+	case runtime.GOOS == "windows":
+		return nil, fmt.Errorf("selecting a region is not supported on windows, give it with --region or --window")
+	case os.Getenv("WAYLAND_DISPLAY") != "":
+		cmd = exec.CommandContext(ctx, "slurp", "-f", "%wx%h+%x+%y")
+	default:
+		// a click selects a whole window
+		cmd = exec.CommandContext(ctx, "slop", "-f", "%g")
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if isMissingTool(err) {
+			return nil, exitError(exitDependency, fmt.Errorf("%s is needed to select a region: %w", cmd.Args[0], err))
+		}
+		// both fail when the selection is cancelled with escape
+		return nil, fmt.Errorf("no region selected")
+	}
+	return parseRegion(strings.TrimSpace(string(out)))
+}
+
+// recorder returns the command that records the screen into outfn:
+// wf-recorder on wayland, which ffmpeg can't grab, and otherwise ffmpeg
+// with the grabber of the platform.
+func recorder(c *cli.Context, outfn string, area *region) (*exec.Cmd, error) {
+	fps := strconv.Itoa(c.Int("fps"))
+	window := c.String("window")
+	// lossless and quick to encode, gifski only ever sees the frames
+	encode := []string{"-c:v", "libx264", "-preset", "ultrafast", "-qp", "0", outfn}
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			if window != "" {
+				return nil, fmt.Errorf("--window is not supported on wayland, use --region")
+			}
+			args := []string{"-r", fps, "-f", outfn}
+			if area != nil {
+				args = append(args, "-g", fmt.Sprintf("%d,%d %dx%d", area.X, area.Y, area.W, area.H))
+			}
+			return exec.Command("wf-recorder", args...), nil
+		}
+		display := os.Getenv("DISPLAY")
+		if display == "" {
+			return nil, fmt.Errorf("no display to record, neither $DISPLAY nor $WAYLAND_DISPLAY is set")
+		}
+		args := []string{"-y", "-f", "x11grab", "-framerate", fps}
+		if window != "" {
+			args = append(args, "-window_id", window)
+		}
+		if area != nil {
+			args = append(args, "-video_size", fmt.Sprintf("%dx%d", area.W, area.H))
+			display += fmt.Sprintf("+%d,%d", area.X, area.Y)
+		}
+		args = append(args, "-i", display)
+		return exec.Command("ffmpeg", append(args, encode...)...), nil
+	case "darwin":
+		if window != "" {
+			return nil, fmt.Errorf("--window is not supported on macOS, use --region")
+		}
+		args := []string{
+			"-y", "-f", "avfoundation", "-framerate", fps, "-capture_cursor", "1",
+			"-i", fmt.Sprintf("Capture screen %d:none", c.Int("screen")),
+		}
+		if area != nil {
+			// avfoundation has no region of its own
+			args = append(args, "-vf", fmt.Sprintf("crop=%d:%d:%d:%d", area.W, area.H, area.X, area.Y))
+		}
+		return exec.Command("ffmpeg", append(args, encode...)...), nil
+	case "windows":
+		args := []string{"-y", "-f", "gdigrab", "-framerate", fps}
+		if area != nil {
+			args = append(args,
+				"-offset_x", strconv.Itoa(area.X), "-offset_y", strconv.Itoa(area.Y),
+				"-video_size", fmt.Sprintf("%dx%d", area.W, area.H),
+			)
+		}
+		input := "desktop"
+		if window != "" {
+			input = "title=" + window
+		}
+		args = append(args, "-i", input)
+		return exec.Command("ffmpeg", append(args, encode...)...), nil
+	}
+	return nil, fmt.Errorf("recording the screen is not supported on %s", runtime.GOOS)
+}
+
+// recordingName is where a new recording is saved: in the src folder, so
+// it can be converted again like any other recording.
+func recordingName(c *cli.Context, ext string) string {
+	return filepath.Join(c.String("src"), "ggif-"+time.Now().Format("20060102-150405")+ext)
+}
+
+// recordingDuration is --duration in seconds, or 0 to record until enter
+// is pressed.
+func recordingDuration(c *cli.Context) (float64, error) {
+	var duration float64
+	if s := c.String("duration"); s != "" {
+		var err error
+		if duration, err = parseTimestamp(s); err != nil {
+			return 0, exitError(exitUsage, err)
+		}
+	}
+	if duration == 0 && !isTerminal(os.Stdin) {
+		return 0, exitError(exitUsage, fmt.Errorf("without a terminal to press enter in, --duration is needed to stop recording"))
+	}
+	return duration, nil
+}
+
+// waitRecording waits for enter to be pressed or duration to be up, and
+// returns true when it is time to stop the recorder.  When the recorder
+// exits by itself first, or the recording is interrupted, it returns false
+// with the error of the recorder, from done, or of the context.
+func waitRecording(c *cli.Context, done <-chan error, duration float64, target string) (bool, error) {
+	var enter <-chan string
+	if isTerminal(os.Stdin) {
+		enter = readStdin()
+	}
+	var timer <-chan time.Time
+	if duration > 0 {
+		timer = time.After(time.Duration(duration * float64(time.Second)))
+	}
+	if !c.Bool("quiet") {
+		if duration > 0 {
+			fmt.Fprintf(os.Stderr, "Recording %s to %s, press enter to stop early\n", formatTimestamp(duration), target)
+		} else {
+			fmt.Fprintf(os.Stderr, "Recording to %s, press enter to stop\n", target)
+		}
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return false, err
+		case <-c.Context.Done():
+			return false, c.Context.Err()
+		case _, ok := <-enter:
+			if !ok {
+				// stdin at its end is no key press
+				enter = nil
+				continue
+			}
+		case <-timer:
+		}
+		return true, nil
+	}
+}
+
+// captureScreen records until enter is pressed or --duration is up, and
+// returns the recording.
+func captureScreen(c *cli.Context) (string, error) {
+	var area *region
+	if s := c.String("region"); s != "" {
+		var err error
+		if area, err = parseRegion(s); err != nil {
+			return "", exitError(exitUsage, err)
+		}
+	}
+	if c.Bool("select") {
+		if area != nil || c.String("window") != "" {
+			return "", exitError(exitUsage, fmt.Errorf("--select picks the region, leave out --region and --window"))
+		}
+		var err error
+		if area, err = selectRegion(c.Context); err != nil {
+			return "", exitError(exitUsage, err)
+		}
+	}
+	duration, err := recordingDuration(c)
+	if err != nil {
+		return "", err
+	}
+
+	outfn := recordingName(c, ".mp4")
+	cmd, err := recorder(c, outfn, area)
+	if err != nil {
+		return "", exitError(exitUsage, err)
+	}
+	if c.Bool("dry-run") {
+		fmt.Fprintln(os.Stderr, shellJoin(cmd.Args))
+		return outfn, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(outfn), 0755); err != nil {
+		return "", exitError(exitUsage, err)
+	}
+
+	// ffmpeg finishes the file when told to quit on stdin, wf-recorder on
+	// an interrupt
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	cmd.Stderr = &output
+	log.Debug(cmd.Args)
+	if err := cmd.Start(); err != nil {
+		return "", exitError(exitDependency, fmt.Errorf("%s: %w", cmd.Args[0], err))
+	}
+	stop := func() {
+		if cmd.Args[0] == "wf-recorder" {
+			cmd.Process.Signal(os.Interrupt)
+		} else {
+			io.WriteString(stdin, "q")
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	if ok, err := waitRecording(c, done, duration, outfn); !ok {
+		if c.Context.Err() != nil {
+			cmd.Process.Kill()
+			<-done
+			os.Remove(outfn)
+			return "", err
+		}
+		// the recorder quit by itself, there is nothing to convert
+		if err == nil {
+			err = fmt.Errorf("stopped before it was asked to")
+		}
+		os.Remove(outfn)
+		if last := lastLine(output.String()); last != "" {
+			return "", exitError(exitDependency, fmt.Errorf("%s: %w: %s", cmd.Args[0], err, last))
+		}
+		return "", exitError(exitDependency, fmt.Errorf("%s: %w", cmd.Args[0], err))
+	}
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-done
+	}
+	if !fileExists(outfn) {
+		return "", exitError(exitDependency, fmt.Errorf("%s wrote no recording: %s", cmd.Args[0], lastLine(output.String())))
+	}
+	return outfn, nil
+}
+
+// record records the screen, the terminal, an android device or an iOS
+// simulator, and converts and uploads the recording.
+func record(c *cli.Context) error {
+	capture := captureScreen
+	var sources int
+	for source, fn := range map[string]func(*cli.Context) (string, error){
+		"terminal": captureTerminal,
+		"android":  captureAndroid,
+		"ios-sim":  captureSimulator,
+	} {
+		if c.Bool(source) {
+			capture = fn
+			sources++
+		}
+	}
+	if sources > 1 {
+		return exitError(exitUsage, fmt.Errorf("record one of --terminal, --android and --ios-sim"))
+	}
+	if sources == 1 && (c.String("region") != "" || c.String("window") != "" || c.Bool("select")) {
+		return exitError(exitUsage, fmt.Errorf("--region, --window and --select are for screen recordings, not --terminal, --android or --ios-sim"))
+	}
+	videoFile, err := capture(c)
+	if err != nil || c.Bool("dry-run") {
+		return err
+	}
+	res, err := process(c.Context, c, videoFile)
+	printResult(c, res)
+	return err
+}
+
+var recordCommand = &cli.Command{
+	Name:  "record",
+	Usage: "record the screen, then convert and upload the recording",
+	Description: `Records with ffmpeg (x11grab on X11, avfoundation on macOS, gdigrab on
+   Windows) or wf-recorder on wayland until enter is pressed or --duration is
+   up.  With --terminal, asciinema records a shell in the terminal until it
+   exits; with --android, adb screenrecord records a device for up to 3
+   minutes; with --ios-sim, simctl records the booted simulator.  The
+   recording is saved in the src folder and converted like any
+   other.
+
+   Examples:
+      ggif record
+      ggif record --duration 10 --region 1280x720+0+0
+      ggif record --select
+      ggif record --terminal
+      ggif record --android --duration 30
+      ggif record --ios-sim
+      ggif record --window "Untitled - Notepad"`,
+	Before: sweepBefore,
+	Action: record,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "duration",
+			Usage: "stop after this long, in seconds or mm:ss",
+		},
+		&cli.StringFlag{
+			Name:  "region",
+			Usage: "record only this part of the screen, WxH+X+Y",
+		},
+		&cli.BoolFlag{
+			Name:  "select",
+			Usage: "drag out the region to record first, with slurp on wayland or slop on X11",
+		},
+		&cli.StringFlag{
+			Name:  "window",
+			Usage: "record only this window: its title on Windows, its id on X11",
+		},
+		&cli.BoolFlag{
+			Name:  "terminal",
+			Usage: "record a shell in this terminal with asciinema instead of the screen",
+		},
+		&cli.BoolFlag{
+			Name:  "android",
+			Usage: "record the screen of an android device connected with adb instead",
+		},
+		&cli.StringFlag{
+			Name:    "device",
+			EnvVars: []string{"ANDROID_SERIAL"},
+			Usage:   "serial of the android device to record, when several are connected",
+		},
+		&cli.BoolFlag{
+			Name:  "ios-sim",
+			Usage: "record an iOS simulator with xcrun simctl instead, on macOS",
+		},
+		&cli.StringFlag{
+			Name:  "simulator",
+			Value: "booted",
+			Usage: "udid of the simulator to record, when several are booted",
+		},
+		&cli.IntFlag{
+			Name:  "screen",
+			Usage: "screen to record on macOS, when there are several",
+		},
+		&cli.IntFlag{
+			Name:  "fps",
+			Value: 30,
+			Usage: "frames per second to record at",
+		},
+		&cli.BoolFlag{
+			Name:  "no-upload",
+			Usage: "only write the gif locally",
+		},
+	},
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/gif"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// result describes one conversion.  It is printed as json with --json.
+type result struct {
+	Source string `json:"source"`
+	Input  struct {
+		Width    int     `json:"width"`
+		Height   int     `json:"height"`
+		Duration float64 `json:"duration"`
+		Size     int64   `json:"size"`
+	} `json:"input"`
+	Output string   `json:"output"`
+	Width  int      `json:"width"`
+	Height int      `json:"height"`
+	Frames int      `json:"frames"`
+	Size   int64    `json:"size"`
+	SHA256 string   `json:"sha256,omitempty"`
+	URLs   []string `json:"urls"`
+	// SourceSHA256 is the checksum of the recording, for telling later
+	// which one a gif was made from.
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	// Thumbnail is the png of a representative frame written with --thumbnail.
+	Thumbnail string `json:"thumbnail,omitempty"`
+	// ThumbnailURL is where the thumbnail was uploaded, when the routes
+	// setting sends thumbnails somewhere.
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	// SourceURL is where the recording was uploaded with --include-source.
+	SourceURL string `json:"source_url,omitempty"`
+	// Skipped is set when --if-exists skip left an existing gif alone.
+	Skipped bool `json:"skipped,omitempty"`
+	// Cached is set when the gif of an earlier conversion of the same
+	// video with the same settings was reused.
+	Cached bool `json:"cached,omitempty"`
+	// Job is the id of the watch, server or worker job that made the gif,
+	// for looking at its log with ggif logs.
+	Job string `json:"job,omitempty"`
+	// Error says why the conversion failed.
+	Error string `json:"error,omitempty"`
+	// Durations holds the seconds spent in each stage: frames, gif, upload
+	// and total.
+	Durations map[string]float64 `json:"durations"`
+
+	onStage func(stage string)
+	// stage is the stage running or last run, for telling which one failed.
+	stage string
+}
+
+// timeStage runs fn and records how long it took under name.
+func (r *result) timeStage(name string, fn func() error) error {
+	r.stage = name
+	if r.onStage != nil {
+		r.onStage(name)
+	}
+	start := time.Now()
+	err := fn()
+	r.Durations[name] = time.Since(start).Seconds()
+	return err
+}
+
+// fileSHA256 returns the hex encoded sha256 of a file's contents.
+func fileSHA256(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func printJSON(v interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Error(err)
+	}
+}
+
+// printResult prints a result as json when --json is given, otherwise a
+// summary on stderr; the url (or path) was already printed along the way.
+func printResult(c *cli.Context, res *result) {
+	switch {
+	case c.Bool("json"):
+		printJSON(res)
+	case !c.Bool("quiet") && !c.Bool("dry-run") && !res.Skipped && res.Error == "":
+		printReport(os.Stderr, res)
+	}
+}
+
+// gifSize reads the dimensions from a gif's header.
+func gifSize(fname string) (int, int) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	cfg, err := gif.DecodeConfig(f)
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// printReport prints what went into and came out of a conversion.
+func printReport(w io.Writer, res *result) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	in := res.Input
+	fmt.Fprintf(tw, "input\t%s\t%dx%d, %s, %s\n",
+		res.Source, in.Width, in.Height, formatTimestamp(in.Duration), humanSize(in.Size))
+	fmt.Fprintf(tw, "output\t%s\t%dx%d, %d frames, %s\n",
+		res.Output, res.Width, res.Height, res.Frames, humanSize(res.Size))
+	if res.Thumbnail != "" {
+		fmt.Fprintf(tw, "thumb\t%s\t\n", res.Thumbnail)
+	}
+	if res.Size > 0 && in.Size > 0 {
+		fmt.Fprintf(tw, "ratio\t%.1fx\t\n", float64(in.Size)/float64(res.Size))
+	}
+
+	var stages []string
+	for _, stage := range []string{"cast", "frames", "gif", "upload", "source", "total"} {
+		if secs, ok := res.Durations[stage]; ok {
+			stages = append(stages, fmt.Sprintf("%s %.1fs", stage, secs))
+		}
+	}
+	fmt.Fprintf(tw, "time\t%s\t\n", strings.Join(stages, ", "))
+	for _, url := range res.URLs {
+		fmt.Fprintf(tw, "url\t%s\t\n", url)
+	}
+}
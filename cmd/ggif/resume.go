@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	bolt "go.etcd.io/bbolt"
+)
+
+var uploadsBucket = []byte("uploads")
+
+// pendingUpload is an upload that was started and has not finished, kept
+// in the history database so ggif resume can run it again.  gsutil keeps
+// how far a resumable upload got in its tracker files and carries on from
+// there when the same file is copied to the same object again; plugins get
+// the same request again and start over unless they keep state of their
+// own.
+type pendingUpload struct {
+	File string `json:"file"`
+	Dest string `json:"dest"`
+	URL  string `json:"url,omitempty"`
+	// Args are the gsutil arguments of the copy, without those of the
+	// network and credential settings, which are added when it runs.
+	Args []string `json:"args,omitempty"`
+	// Plugin is the uploader plugin that ran the upload, with Request as
+	// its input, instead of gsutil.
+	Plugin  string          `json:"plugin,omitempty"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Started time.Time       `json:"started"`
+}
+
+// keptUploadsDir holds copies of the files of unfinished uploads that were
+// in a temp folder, until the uploads finish.
+func keptUploadsDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "uploads"), nil
+}
+
+// keepForResume returns the file to upload fn to dest from so ggif resume
+// can still find it: fn, or a copy in the data folder when fn is in a temp
+// folder that goes away with the conversion, like the stripped copy of a
+// recording or the gif of a serve job.
+func keepForResume(c *cli.Context, fn string, dest string) string {
+	abs, err := filepath.Abs(fn)
+	if c.Bool("dry-run") || err != nil || !insideDir(abs, tempDir(c)) {
+		return fn
+	}
+	dir, err := keptUploadsDir()
+	if err == nil {
+		err = os.MkdirAll(dir, 0755)
+	}
+	// named after dest, so resuming sends the same file gsutil tracked
+	sum := sha256.Sum256([]byte(dest))
+	kept := filepath.Join(dir, hex.EncodeToString(sum[:8])+filepath.Ext(fn))
+	if err == nil {
+		err = copyFile(abs, kept)
+	}
+	if err != nil {
+		log.Warningf("Could not keep %s to resume its upload: %v", fn, err)
+		return fn
+	}
+	return kept
+}
+
+// withUploads runs fn on the uploads bucket of the history database.
+func withUploads(fn func(b *bolt.Bucket) error, write bool) error {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	db, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if !write {
+		return db.View(func(tx *bolt.Tx) error {
+			return fn(tx.Bucket(uploadsBucket))
+		})
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(uploadsBucket))
+	})
+}
+
+// rememberUpload records an upload about to start.  Failing to record it
+// is logged, never fatal.
+func rememberUpload(c *cli.Context, p pendingUpload) {
+	if c.Bool("dry-run") {
+		return
+	}
+	err := withUploads(func(b *bolt.Bucket) error {
+		data, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(p.Dest), data)
+	}, true)
+	if err != nil {
+		log.Warningf("Could not record the upload to %s: %v", p.Dest, err)
+	}
+}
+
+// forgetUpload drops the record of the upload to dest once it finished,
+// and the copy of its file kept for it.
+func forgetUpload(c *cli.Context, dest string) {
+	if c.Bool("dry-run") {
+		return
+	}
+	var p pendingUpload
+	err := withUploads(func(b *bolt.Bucket) error {
+		if data := b.Get([]byte(dest)); data != nil {
+			json.Unmarshal(data, &p)
+		}
+		return b.Delete([]byte(dest))
+	}, true)
+	if err != nil {
+		log.Warningf("Could not update the uploads to resume: %v", err)
+		return
+	}
+	if dir, err := keptUploadsDir(); err == nil && p.File != "" && insideDir(p.File, dir) {
+		os.Remove(p.File)
+	}
+}
+
+// pendingUploads returns the uploads that never finished, oldest first.
+func pendingUploads() ([]pendingUpload, error) {
+	var uploads []pendingUpload
+	err := withUploads(func(b *bolt.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			var p pendingUpload
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			uploads = append(uploads, p)
+			return nil
+		})
+	}, false)
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Started.Before(uploads[j].Started)
+	})
+	return uploads, err
+}
+
+func listUploads(uploads []pendingUpload) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "STARTED\tFILE\tDEST")
+	for _, p := range uploads {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Started.Format("2006-01-02 15:04"), p.File, p.Dest)
+	}
+}
+
+func resumeUploads(c *cli.Context) error {
+	uploads, err := pendingUploads()
+	if err != nil {
+		return err
+	}
+	if len(uploads) == 0 {
+		if !c.Bool("quiet") {
+			fmt.Fprintln(os.Stderr, "No interrupted uploads")
+		}
+		return nil
+	}
+	switch {
+	case c.Bool("list"):
+		listUploads(uploads)
+		return nil
+	case c.Bool("discard"):
+		for _, p := range uploads {
+			forgetUpload(c, p.Dest)
+		}
+		return nil
+	}
+
+	failed := 0
+	for _, p := range uploads {
+		if !fileExists(p.File) {
+			log.Warningf("%s is gone, not resuming its upload to %s", p.File, p.Dest)
+			forgetUpload(c, p.Dest)
+			continue
+		}
+		log.Infof("Resuming the upload of %s to %s", p.File, p.Dest)
+		url := p.URL
+		if p.Plugin != "" {
+			url, err = runPlugin(c.Context, c, p.Plugin, p.Request)
+		} else {
+			err = runCmd(c.Context, c, "gsutil", gsutilArgs(c, p.Args...)...)
+		}
+		if err != nil {
+			printError(fmt.Errorf("%s: %v", p.Dest, err))
+			failed++
+			continue
+		}
+		res := &result{Source: p.File, Output: p.File, URLs: []string{}}
+		if fi, err := os.Stat(p.File); err == nil {
+			res.Size = fi.Size()
+		}
+		forgetUpload(c, p.Dest)
+		if url == "" {
+			continue
+		}
+		announceURL(c, url)
+		res.URLs = append(res.URLs, url)
+		recordHistory(c, res)
+	}
+	if failed > 0 {
+		return exitError(exitUpload, fmt.Errorf("%d of %d uploads failed again, run `ggif resume` later", failed, len(uploads)))
+	}
+	return nil
+}
+
+var resumeCommand = &cli.Command{
+	Name:  "resume",
+	Usage: "carry on with uploads that were interrupted",
+	Description: `Uploads that failed or were cut off, by a dropped network, a laptop
+   going to sleep or Ctrl-C, are remembered.  ggif resume runs them again:
+   gsutil carries on from where an upload of a gif over 8 MB stopped
+   instead of sending the whole gif again, while gifs streamed with
+   --stream-upload are sent whole once written, and uploader plugins get
+   the same request again.  Uploads slowed with --limit-rate can't be
+   resumed.  Files that were in a temp folder are kept in the data folder
+   until their upload is done.
+
+   Examples:
+      ggif resume
+      ggif resume --list`,
+	Action: resumeUploads,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "list",
+			Usage: "only list the interrupted uploads",
+		},
+		&cli.BoolFlag{
+			Name:  "discard",
+			Usage: "forget the interrupted uploads instead of resuming them",
+		},
+	},
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestKeepForResume(t *testing.T) {
+	data, err := ioutil.TempDir("", "ggif-test-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(data)
+	tmp, err := ioutil.TempDir("", "ggif-test-tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	t.Setenv("XDG_DATA_HOME", data)
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("tmp-dir", tmp, "")
+	set.Bool("dry-run", false, "")
+	c := cli.NewContext(nil, set, nil)
+
+	outside := filepath.Join(data, "dist.gif")
+	if err := ioutil.WriteFile(outside, []byte("GIF89a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if fn := keepForResume(c, outside, "gs://bk/dist.gif"); fn != outside {
+		t.Errorf("keepForResume copied %s, which is not in a temp folder, to %s", outside, fn)
+	}
+
+	inside := filepath.Join(tmp, "job", "a.gif")
+	os.MkdirAll(filepath.Dir(inside), 0755)
+	if err := ioutil.WriteFile(inside, []byte("GIF89a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := "gs://bk/a.gif"
+	kept := keepForResume(c, inside, dest)
+	dir, _ := keptUploadsDir()
+	if !insideDir(kept, dir) || filepath.Ext(kept) != ".gif" {
+		t.Fatalf("keepForResume(%s) = %s, want a gif in %s", inside, kept, dir)
+	}
+	if again := keepForResume(c, inside, dest); again != kept {
+		t.Errorf("the same upload was kept as %s and %s", kept, again)
+	}
+	if b, err := ioutil.ReadFile(kept); err != nil || string(b) != "GIF89a" {
+		t.Errorf("kept copy holds %q, %v", b, err)
+	}
+
+	rememberUpload(c, pendingUpload{File: kept, Dest: dest})
+	uploads, err := pendingUploads()
+	if err != nil || len(uploads) != 1 || uploads[0].File != kept {
+		t.Fatalf("pendingUploads() = %+v, %v", uploads, err)
+	}
+	forgetUpload(c, dest)
+	if fileExists(kept) {
+		t.Errorf("forgetUpload left %s behind", kept)
+	}
+	if uploads, _ := pendingUploads(); len(uploads) != 0 {
+		t.Errorf("pendingUploads() after forgetUpload = %+v", uploads)
+	}
+}
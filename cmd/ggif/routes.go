@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// outputKinds are the outputs of a conversion the routes setting can send
+// somewhere.  Without a route gifs, and recordings with --include-source,
+// go to the uploader and bucket settings and thumbnails stay local.
+var outputKinds = []string{"gif", "thumbnail", "source"}
+
+// destination is an uploader and the bucket it uploads to, written
+// uploader:bucket as in the cache.
+type destination struct {
+	uploader string
+	bucket   string
+}
+
+func (d destination) String() string {
+	return d.uploader + ":" + d.bucket
+}
+
+func parseDestination(s string) (destination, error) {
+	parts := strings.SplitN(s, ":", 2)
+	d := destination{uploader: parts[0]}
+	if len(parts) == 2 {
+		d.bucket = parts[1]
+	}
+	if d.uploader == "" {
+		return d, fmt.Errorf("bad destination %q, want uploader:bucket like gcs:my-gifs", s)
+	}
+	if d.uploader == "gcs" && d.bucket == "" {
+		return d, fmt.Errorf("destination %q needs a bucket, like gcs:my-gifs", s)
+	}
+	return d, nil
+}
+
+// parseRoutes parses routes like gif=gcs:public,gif=gcs:archive,
+// thumbnail=gcs:cdn into the destinations of each kind of output.
+func parseRoutes(s string) (map[string][]destination, error) {
+	routes := make(map[string][]destination)
+	for _, route := range strings.Split(s, ",") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+		i := strings.Index(route, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("bad route %q, want kind=uploader:bucket like gif=gcs:my-gifs", route)
+		}
+		kind := strings.TrimSpace(route[:i])
+		if err := oneOf(outputKinds...)(kind); err != nil {
+			return nil, fmt.Errorf("bad route %q: %v", route, err)
+		}
+		d, err := parseDestination(strings.TrimSpace(route[i+1:]))
+		if err != nil {
+			return nil, err
+		}
+		routes[kind] = append(routes[kind], d)
+	}
+	return routes, nil
+}
+
+func routesValue(value interface{}) error {
+	_, err := parseRoutes(value.(string))
+	return err
+}
+
+// destinationContext returns a context in which the uploader and bucket
+// settings are those of d, leaving c alone for the uploads running next to
+// it.
+func destinationContext(c *cli.Context, d destination, clipboard bool) *cli.Context {
+	set := flag.NewFlagSet(d.String(), flag.ContinueOnError)
+	set.String("uploader", d.uploader, "")
+	set.String("bucket", d.bucket, "")
+	// only the url of the first destination ends up on the clipboard
+	set.Bool("no-clipboard", !clipboard || c.Bool("no-clipboard"), "")
+	return cli.NewContext(c.App, set, c)
+}
+
+// routeContexts returns a context for each destination the kind of output
+// goes to.  Gifs without a route go where the uploader and bucket settings
+// say.
+func routeContexts(c *cli.Context, kind string) []*cli.Context {
+	routes, err := parseRoutes(c.String("routes"))
+	if err != nil {
+		// checked when the settings were loaded
+		log.Warning(err)
+	}
+	if len(routes[kind]) == 0 {
+		if kind == "gif" || kind == "source" && c.Bool("include-source") {
+			return []*cli.Context{c}
+		}
+		return nil
+	}
+	contexts := make([]*cli.Context, len(routes[kind]))
+	for i, d := range routes[kind] {
+		contexts[i] = destinationContext(c, d, kind == "gif" && i == 0)
+	}
+	return contexts
+}
+
+// existsAtAny reports whether any of the gcs destinations of contexts
+// already has an object by that name.  Uploader plugins can't be asked.
+func existsAtAny(ctx context.Context, contexts []*cli.Context, name string) bool {
+	for _, dc := range contexts {
+		bucket := dc.String("bucket")
+		if dc.Bool("no-upload") || !usesGCS(dc) || bucket == "" {
+			continue
+		}
+		if objectExists(ctx, dc, bucket, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// routed reports whether the routes setting says where kind goes.
+func routed(c *cli.Context, kind string) bool {
+	routes, _ := parseRoutes(c.String("routes"))
+	return len(routes[kind]) > 0
+}
+
+// uploadThumbnail sends the thumbnail of res to the destinations routed
+// for thumbnails, named after the gif.
+func uploadThumbnail(ctx context.Context, c *cli.Context, res *result, outputFile string) error {
+	if res.Thumbnail == "" || !fileExists(res.Thumbnail) && !c.Bool("dry-run") {
+		return nil
+	}
+	for _, dc := range routeContexts(c, "thumbnail") {
+		url, err := uploadTo(ctx, dc, res.Thumbnail, thumbnailName(outputFile))
+		if err != nil {
+			return fmt.Errorf("thumbnail: %w", err)
+		}
+		if url != "" && res.ThumbnailURL == "" {
+			res.ThumbnailURL = url
+		}
+	}
+	return nil
+}
+
+type storageClassKey struct{}
+
+// uploadStorageClass is the storage class uploads with ctx ask for, "" for
+// the bucket's default.
+func uploadStorageClass(ctx context.Context) string {
+	class, _ := ctx.Value(storageClassKey{}).(string)
+	return class
+}
+
+// storageClassArgs are the gsutil cp options for the storage class of ctx.
+func storageClassArgs(ctx context.Context) []string {
+	if class := uploadStorageClass(ctx); class != "" {
+		return []string{"-s", class}
+	}
+	return nil
+}
+
+// sourceName is the name the recording of the gif outputFile is uploaded
+// under: the gif's name with the recording's extension, after
+// --source-prefix.
+func sourceName(c *cli.Context, videoFile string, outputFile string) string {
+	base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
+	return c.String("source-prefix") + base + strings.ToLower(filepath.Ext(videoFile))
+}
+
+// uploadSource sends the recording the gif was made from to the
+// destinations routed for sources, or next to the gif, so the full quality
+// version is kept.  Unless --strip-metadata=false, what is uploaded is a
+// copy without the recording's metadata.
+func uploadSource(ctx context.Context, c *cli.Context, res *result, outputFile string) error {
+	ctx = context.WithValue(ctx, storageClassKey{}, c.String("source-storage-class"))
+	uploadfn, cleanup, err := strippedSource(ctx, c, res.Source)
+	if err != nil {
+		return fmt.Errorf("recording: %w", err)
+	}
+	defer cleanup()
+	for _, dc := range routeContexts(c, "source") {
+		url, err := uploadTo(ctx, dc, uploadfn, sourceName(c, res.Source, outputFile))
+		if err != nil {
+			return fmt.Errorf("recording: %w", err)
+		}
+		if url != "" && res.SourceURL == "" {
+			res.SourceURL = url
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestRoutesValue(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"", true},
+		{"gif=gcs:public", true},
+		{"gif=gcs:public, gif=gcs:archive ,thumbnail=gcs:cdn", true},
+		{"source=s3:recordings", true},
+		{"gif=imgur", true},
+		{"gif=gcs:public,", true},
+		{"gif", false},
+		{"gif=", false},
+		{"gif=gcs", false},
+		{"gif=gcs:", false},
+		{"video=gcs:public", false},
+		{"gif=:bucket", false},
+	}
+	for _, tt := range tests {
+		if err := routesValue(tt.in); (err == nil) != tt.ok {
+			t.Errorf("routesValue(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+		}
+	}
+}
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := parseRoutes("gif=gcs:public,gif=gcs:archive,thumbnail=imgur")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(routes["gif"]); got != 2 {
+		t.Fatalf("%d gif routes, want 2", got)
+	}
+	if got := routes["gif"][1].String(); got != "gcs:archive" {
+		t.Errorf("second gif route = %s, want gcs:archive", got)
+	}
+	if d := routes["thumbnail"][0]; d.uploader != "imgur" || d.bucket != "" {
+		t.Errorf("thumbnail route = %+v", d)
+	}
+	if len(routes["source"]) != 0 {
+		t.Errorf("unexpected source routes %v", routes["source"])
+	}
+}
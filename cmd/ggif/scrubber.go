@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	timelineWidth  = 60
+	thumbnailWidth = 64
+	// terminal cells are about twice as tall as they are wide
+	thumbnailHeight = 20
+	asciiRamp       = " .:-=+*#%@"
+)
+
+// asciiThumbnail renders the frame at secs as ascii art by having ffmpeg
+// scale it down to one gray byte per character.
+func asciiThumbnail(videoFile string, secs float64) (string, error) {
+	out, err := exec.Command(
+		"ffmpeg", "-v", "error",
+		"-ss", strconv.FormatFloat(secs, 'f', 2, 64),
+		"-i", videoFile,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d,format=gray", thumbnailWidth, thumbnailHeight),
+		"-f", "rawvideo", "-",
+	).Output()
+	if err != nil {
+		return "", err
+	}
+	if len(out) < thumbnailWidth*thumbnailHeight {
+		return "", fmt.Errorf("no frame at %s", formatTimestamp(secs))
+	}
+
+	var b strings.Builder
+	for y := 0; y < thumbnailHeight; y++ {
+		for x := 0; x < thumbnailWidth; x++ {
+			v := int(out[y*thumbnailWidth+x])
+			b.WriteByte(asciiRamp[v*(len(asciiRamp)-1)/255])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String(), nil
+}
+
+// timeline draws the clip as a bar with the kept range as '=', the in and
+// out points as '[' and ']' and the cursor as '|'.
+func timeline(duration float64, in float64, out float64, cursor float64) string {
+	col := func(secs float64) int {
+		i := int(secs / duration * float64(timelineWidth-1))
+		if i < 0 {
+			return 0
+		}
+		if i > timelineWidth-1 {
+			return timelineWidth - 1
+		}
+		return i
+	}
+
+	bar := []byte(strings.Repeat("-", timelineWidth))
+	for i := col(in); i <= col(out); i++ {
+		bar[i] = '='
+	}
+	bar[col(in)] = '['
+	bar[col(out)] = ']'
+	bar[col(cursor)] = '|'
+	return string(bar)
+}
+
+// scrub lets the user move through the video and pick in and out points,
+// then sets --start and --end.  It returns false when the user quits.
+func scrub(c *cli.Context, videoFile string) (bool, error) {
+	info, err := probe(c.Context, videoFile)
+	if err != nil {
+		return false, fmt.Errorf("can't read %s: %v", videoFile, err)
+	}
+	duration := info.Duration
+	if duration <= 0 {
+		return false, fmt.Errorf("can't tell how long %s is", videoFile)
+	}
+
+	in, out, err := trimRange(c)
+	if err != nil {
+		return false, err
+	}
+	if out == 0 {
+		out = duration
+	}
+	cursor := in
+	step := duration / 20
+
+	for {
+		thumb, err := asciiThumbnail(videoFile, cursor)
+		if err != nil {
+			thumb = fmt.Sprintf("(no preview: %v)\n", err)
+		}
+		fmt.Fprint(os.Stderr, "\n"+thumb)
+		fmt.Fprintf(os.Stderr, "%s %s\n", timeline(duration, in, out, cursor), formatTimestamp(duration))
+		fmt.Fprintf(os.Stderr, "at %s  in %s  out %s  (%s kept)\n",
+			formatTimestamp(cursor), formatTimestamp(in), formatTimestamp(out), formatTimestamp(out-in))
+		fmt.Fprint(os.Stderr, "[time] jump, +/-[secs] step, i set in, o set out, enter convert, q quit: ")
+
+		line, ok := <-readStdin()
+		if !ok {
+			return false, nil
+		}
+		cmd := strings.TrimSpace(line)
+
+		switch {
+		case cmd == "":
+			if err := setFlag(c, "start", strconv.FormatFloat(in, 'f', 2, 64)); err != nil {
+				return false, err
+			}
+			if out < duration {
+				if err := setFlag(c, "end", strconv.FormatFloat(out, 'f', 2, 64)); err != nil {
+					return false, err
+				}
+			}
+			return true, nil
+		case cmd == "q":
+			return false, nil
+		case cmd == "i":
+			in = cursor
+			if out <= in {
+				out = duration
+			}
+		case cmd == "o":
+			out = cursor
+			if in >= out {
+				in = 0
+			}
+		case strings.HasPrefix(cmd, "+") || strings.HasPrefix(cmd, "-"):
+			delta := step
+			if len(cmd) > 1 {
+				if delta, err = parseTimestamp(cmd[1:]); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					continue
+				}
+			}
+			if cmd[0] == '-' {
+				delta = -delta
+			}
+			cursor += delta
+		default:
+			secs, err := parseTimestamp(cmd)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				continue
+			}
+			cursor = secs
+		}
+
+		if cursor < 0 {
+			cursor = 0
+		}
+		if cursor > duration {
+			cursor = duration
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+// The settings in sensitiveSettings can refer to a secret instead of
+// holding it in plain text, whether they are set in a config file, a flag
+// or the environment:
+//
+//	"keyring:<service>/<account>"  read from the OS keyring (Keychain,
+//	                               Secret Service or Windows' PasswordVault)
+//	"exec:<command> [args...]"     run a credential helper and use its
+//	                               first line of output
+const (
+	keyringPrefix = "keyring:"
+	execPrefix    = "exec:"
+)
+
+// isSecretRef reports whether a config value refers to a secret.
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, keyringPrefix) || strings.HasPrefix(value, execPrefix)
+}
+
+// resolveSecret returns the secret a config value refers to, or the value
+// itself when it is not a secret reference.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, keyringPrefix):
+		ref := strings.TrimPrefix(value, keyringPrefix)
+		i := strings.LastIndex(ref, "/")
+		if i <= 0 || i == len(ref)-1 {
+			return "", fmt.Errorf("keyring reference %q should look like keyring:<service>/<account>", value)
+		}
+		return keyringGet(ref[:i], ref[i+1:])
+	case strings.HasPrefix(value, execPrefix):
+		args := strings.Fields(strings.TrimPrefix(value, execPrefix))
+		if len(args) == 0 {
+			return "", fmt.Errorf("credential helper %q has no command", value)
+		}
+		return secretOutput(exec.Command(args[0], args[1:]...))
+	}
+	return value, nil
+}
+
+// keyringGet looks a secret up in the OS keyring using the tools each
+// platform ships with, so no cgo or extra dependencies are needed.
+func keyringGet(service string, account string) (string, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w")
+	case "windows":
+		script := fmt.Sprintf(
+			"[void][Windows.Security.Credentials.PasswordVault,Windows.Security.Credentials,ContentType=WindowsRuntime];"+
+				"$c=(New-Object Windows.Security.Credentials.PasswordVault).Retrieve('%s','%s');"+
+				"$c.RetrievePassword();$c.Password",
+			strings.ReplaceAll(service, "'", "''"),
+			strings.ReplaceAll(account, "'", "''"),
+		)
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	default:
+		cmd = exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	}
+	secret, err := secretOutput(cmd)
+	if err != nil {
+		return "", fmt.Errorf("keyring lookup of %s/%s failed: %v", service, account, err)
+	}
+	return secret, nil
+}
+
+// secretOutput runs cmd and returns the first line it printed.  The output
+// is never logged.
+func secretOutput(cmd *exec.Cmd) (string, error) {
+	log.Debug(cmd.Args[0], "(secret lookup)")
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%s: %s", cmd.Args[0], strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("%s: %v", cmd.Args[0], err)
+	}
+	secret := strings.SplitN(string(out), "\n", 2)[0]
+	secret = strings.TrimRight(secret, "\r")
+	if secret == "" {
+		return "", fmt.Errorf("%s returned nothing", cmd.Args[0])
+	}
+	return secret, nil
+}
+
+// sensitiveSettings are the settings that hold a credential.  Only they can
+// refer to a secret, and they are shown as <secret> even when they are set
+// in plain text.
+var sensitiveSettings = map[string]bool{
+	"smtp-password": true,
+	"notion-token":  true,
+	"zulip-api-key": true,
+	// the url is the credential
+	"slack-webhook": true,
+	"teams-webhook": true,
+}
+
+// checkSecretRefs makes sure the secret references among the config values
+// are for sensitive settings.  A project config file comes with whatever
+// repo the working directory is in, so its references would run commands
+// the user never looked at; they are refused unless allowProject is set.
+// Nothing is looked up here, see secretSetting.
+func checkSecretRefs(m *configMap, allowProject bool) error {
+	for _, key := range sortedKeys(m.values) {
+		s, ok := m.values[key].(string)
+		if !ok || !isSecretRef(s) {
+			continue
+		}
+		if !sensitiveSettings[key] {
+			var names []string
+			for name := range sensitiveSettings {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s: %q can't refer to a secret, only %s can", m.origin(key), key, strings.Join(names, ", "))
+		}
+		if m.project[key] && !allowProject {
+			return fmt.Errorf("%s: %q refers to a secret, which a project config may only do with --allow-project-secrets", m.origin(key), key)
+		}
+	}
+	return nil
+}
+
+// resolvedSecrets caches the secrets looked up so far by reference, so a
+// keyring prompt or credential helper runs at most once per process.
+var (
+	resolvedSecretsMu sync.Mutex
+	resolvedSecrets   = make(map[string]string)
+)
+
+// secretSetting returns the effective value of the sensitive setting name,
+// looking up the secret it refers to the first time it is needed.  Only
+// the commands that use a credential ever run a credential helper, and a
+// reference given by flag or environment works as well as one in a config
+// file.
+func secretSetting(c *cli.Context, name string) (string, error) {
+	value := c.String(name)
+	if !isSecretRef(value) {
+		return value, nil
+	}
+	resolvedSecretsMu.Lock()
+	defer resolvedSecretsMu.Unlock()
+	if secret, ok := resolvedSecrets[value]; ok {
+		return secret, nil
+	}
+	secret, err := resolveSecret(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", name, err)
+	}
+	resolvedSecrets[value] = secret
+	return secret, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestCheckSecretRefs(t *testing.T) {
+	tests := []struct {
+		key     string
+		project bool
+		allow   bool
+		wantErr string
+	}{
+		{"smtp-password", false, false, ""},
+		{"smtp-password", true, false, "--allow-project-secrets"},
+		{"smtp-password", true, true, ""},
+		{"bucket", false, false, "can't refer to a secret"},
+	}
+	for _, tt := range tests {
+		m := &configMap{
+			file:    "config.json",
+			values:  map[string]interface{}{tt.key: "exec:false"},
+			project: map[string]bool{tt.key: tt.project},
+		}
+		err := checkSecretRefs(m, tt.allow)
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkSecretRefs(%s, project %v, allow %v) = %v, want %q", tt.key, tt.project, tt.allow, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSecretSetting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs echo")
+	}
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"exec:echo hunter2", "hunter2"},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("smtp-password", tt.value, "")
+		c := cli.NewContext(nil, set, nil)
+		got, err := secretSetting(c, "smtp-password")
+		if err != nil || got != tt.want {
+			t.Errorf("secretSetting(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/lzw"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+)
+
+// minSegmentLength is the shortest part of a video worth encoding on its
+// own; shorter clips are split into fewer segments.
+const minSegmentLength = 10.0
+
+// segmentCount returns how many segments to encode videoFile's clip in,
+// with their start and length.  1 means no splitting.
+func segmentCount(ctx context.Context, c *cli.Context, videoFile string) (int, float64, float64) {
+	n := c.Int("segments")
+	if n <= 1 {
+		return 1, 0, 0
+	}
+	start, end, err := trimRange(c)
+	if err != nil {
+		return 1, 0, 0
+	}
+	if end == 0 {
+		info, err := probe(ctx, videoFile)
+		if err != nil || info.Duration <= start {
+			return 1, 0, 0
+		}
+		end = info.Duration
+	}
+	length := end - start
+	if max := int(length / minSegmentLength); n > max {
+		n = max
+	}
+	if n <= 1 {
+		return 1, 0, 0
+	}
+	return n, start, length
+}
+
+// forSegments runs fn for each of n segments in parallel, cancelling the
+// others once one fails.  Dry runs go one by one so the printed commands
+// stay in order.
+func forSegments(ctx context.Context, c *cli.Context, n int, fn func(ctx context.Context, i int) error) error {
+	if c.Bool("dry-run") {
+		for i := 0; i < n; i++ {
+			if err := fn(ctx, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var first error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := fn(ctx, i); err != nil {
+				// the failure, not the cancellations it causes
+				once.Do(func() {
+					first = err
+					cancel()
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+	return first
+}
+
+// renderSegments is renderWhole for a clip split into n segments: their
+// frames are extracted and encoded in parallel, then the gifs are joined.
+func renderSegments(ctx context.Context, c *cli.Context, res *result, videoFile string, tmpDir string, outfn string, n int, start float64, length float64) error {
+	segLength := length / float64(n)
+	dirs := make([]string, n)
+	parts := make([]string, n)
+	for i := range dirs {
+		dirs[i] = filepath.Join(tmpDir, fmt.Sprintf("segment%02d", i))
+		parts[i] = dirs[i] + ".gif"
+		if !c.Bool("dry-run") {
+			if err := os.Mkdir(dirs[i], 0755); err != nil {
+				return exitError(exitFailure, err)
+			}
+		}
+	}
+
+	paletteFile := ""
+	if c.Bool("shared-palette") {
+		paletteFile = filepath.Join(tmpDir, "palette.png")
+	}
+
+	err := res.timeStage("frames", func() error {
+		if paletteFile != "" {
+			if err := runCmd(ctx, c, "ffmpeg", paletteArgs(c, videoFile, start, length, paletteFile)...); err != nil {
+				return err
+			}
+		}
+		return forSegments(ctx, c, n, func(ctx context.Context, i int) error {
+			segStart := start + float64(i)*segLength
+			inputArgs := clipArgs(c, videoFile, segStart, segLength)
+			args := frameArgs(ctx, c, res, inputArgs, segStart, n, dirs[i], i == 0)
+			return runCmd(ctx, c, "ffmpeg", args...)
+		})
+	})
+	if err != nil {
+		return exitError(exitConvert, err)
+	}
+	for _, dir := range dirs {
+		if frames, err := filepath.Glob(filepath.Join(dir, "*.png")); err == nil {
+			res.Frames += len(frames)
+		}
+	}
+
+	err = res.timeStage("gif", func() error {
+		err := forSegments(ctx, c, n, func(ctx context.Context, i int) error {
+			return createGif(ctx, c, dirs[i], parts[i])
+		})
+		if err != nil || c.Bool("dry-run") {
+			return err
+		}
+		var palette color.Palette
+		if paletteFile != "" {
+			if palette, err = readPalette(paletteFile); err != nil {
+				return err
+			}
+		}
+		return joinGifs(parts, outfn, palette)
+	})
+	if err != nil {
+		return exitError(exitConvert, err)
+	}
+	return nil
+}
+
+// sharedPaletteColors is how many colors the shared palette has, leaving
+// room for the transparent one gifski's frames use for unchanged pixels.
+const sharedPaletteColors = 255
+
+// paletteArgs are the ffmpeg arguments that write the palette of the whole
+// clip to paletteFile as a png, for --shared-palette.
+func paletteArgs(c *cli.Context, videoFile string, start float64, length float64, paletteFile string) []string {
+	filter := fmt.Sprintf("fps=%d,scale=%d:-1,palettegen=max_colors=%d:reserve_transparent=0",
+		c.Int("frames"), c.Int("width"), sharedPaletteColors)
+	args := clipArgs(c, videoFile, start, length)
+	return append(args, "-vf", filter, "-y", paletteFile)
+}
+
+// readPalette reads the colors of a palette ffmpeg wrote and adds a
+// transparent one at the end.
+func readPalette(paletteFile string) (color.Palette, error) {
+	f, err := os.Open(paletteFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("palette: %v", err)
+	}
+
+	var palette color.Palette
+	seen := make(map[color.RGBA]bool)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			if c.A == 0 || seen[c] || len(palette) == sharedPaletteColors {
+				continue
+			}
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	if len(palette) == 0 {
+		return nil, fmt.Errorf("palette: ffmpeg found no colors")
+	}
+	return append(palette, color.RGBA{}), nil
+}
+
+// usePalette maps the pixels of img to the closest colors of palette, whose
+// last color is the transparent one.
+func usePalette(img *image.Paletted, palette color.Palette) {
+	opaque := palette[:len(palette)-1]
+	table := make([]uint8, len(img.Palette))
+	for i, c := range img.Palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			table[i] = uint8(len(opaque))
+		} else {
+			table[i] = uint8(opaque.Index(c))
+		}
+	}
+	for i, index := range img.Pix {
+		if int(index) < len(table) {
+			img.Pix[i] = table[index]
+		}
+	}
+	img.Palette = palette
+}
+
+// joinGifs writes the frames of the gifs in parts, one after the other, to
+// outfn.  They are copied a frame at a time, so a long clip is never all in
+// memory.  Each frame keeps its own colors, unless palette is given: then
+// all of them are mapped to it, so the segments don't shift in color where
+// they meet.
+func joinGifs(parts []string, outfn string, palette color.Palette) error {
+	out, err := os.Create(outfn)
+	if err != nil {
+		return err
+	}
+	j := &gifJoiner{w: bufio.NewWriter(out), palette: palette}
+	for _, part := range parts {
+		if err := j.add(part); err != nil {
+			out.Close()
+			return fmt.Errorf("%s: %v", filepath.Base(part), err)
+		}
+	}
+	j.w.WriteByte(0x3b)
+	if err := j.w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// gifJoiner writes the frames of several gifs of the same size as one.
+type gifJoiner struct {
+	w       *bufio.Writer
+	palette color.Palette
+	// global is the color table of the joined gif, which frames of the
+	// later parts that use a different one of their own get as a local one
+	global  []byte
+	started bool
+}
+
+// colorTableBits is the size field of a gif color table with n colors: it
+// has 2<<bits of them.
+func colorTableBits(n int) uint {
+	bits := uint(0)
+	for 2<<bits < n {
+		bits++
+	}
+	return bits
+}
+
+// add copies the frames of the gif part, and its header and loop count
+// should it be the first.
+func (j *gifJoiner) add(part string) error {
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:3]) != "GIF" {
+		return errTruncated
+	}
+	var table []byte
+	if flags := header[10]; flags&0x80 != 0 {
+		table = make([]byte, 3<<(flags&0x07+1))
+		if _, err := io.ReadFull(r, table); err != nil {
+			return errTruncated
+		}
+	}
+	first := !j.started
+	if first {
+		j.started = true
+		j.global = table
+		if j.palette != nil {
+			bits := colorTableBits(len(j.palette))
+			j.global = make([]byte, 3<<(bits+1))
+			for i, c := range j.palette {
+				r, g, b, _ := c.RGBA()
+				j.global[3*i], j.global[3*i+1], j.global[3*i+2] = byte(r>>8), byte(g>>8), byte(b>>8)
+			}
+			header[10] = 0x80 | 0x70 | byte(bits)
+			header[11] = 0
+		}
+		copy(header, "GIF89a")
+		j.w.Write(header)
+		j.w.Write(j.global)
+	}
+
+	// the transparent index of the next frame, from its graphic control
+	// extension
+	transparent := -1
+	for {
+		kind, err := r.ReadByte()
+		if err != nil {
+			return errTruncated
+		}
+		switch kind {
+		case 0x21:
+			label, err := r.ReadByte()
+			if err != nil {
+				return errTruncated
+			}
+			data, err := readSubBlocks(r)
+			if err != nil {
+				return err
+			}
+			switch {
+			case label == 0xf9 && len(data) >= 6 && data[0] == 4:
+				transparent = -1
+				if data[1]&0x01 != 0 {
+					transparent = int(data[4])
+					if j.palette != nil {
+						data[4] = byte(len(j.palette) - 1)
+					}
+				}
+			case label == 0xff && first:
+				// the loop count
+			default:
+				continue
+			}
+			j.w.Write([]byte{0x21, label})
+			j.w.Write(data)
+		case 0x2c:
+			if err := j.addFrame(r, table, transparent); err != nil {
+				return err
+			}
+			transparent = -1
+		case 0x3b:
+			return nil
+		default:
+			return errTruncated
+		}
+	}
+}
+
+// addFrame copies the frame whose image descriptor r is at, after the 0x2c
+// that starts it.  table is the global color table of its gif.
+func (j *gifJoiner) addFrame(r *bufio.Reader, table []byte, transparent int) error {
+	desc := make([]byte, 9)
+	if _, err := io.ReadFull(r, desc); err != nil {
+		return errTruncated
+	}
+	if flags := desc[8]; flags&0x80 != 0 {
+		table = make([]byte, 3<<(flags&0x07+1))
+		if _, err := io.ReadFull(r, table); err != nil {
+			return errTruncated
+		}
+	} else if table == nil {
+		return fmt.Errorf("a frame has no colors")
+	}
+
+	if j.palette == nil {
+		// only the color table may need to change
+		if bytes.Equal(table, j.global) {
+			desc[8] &^= 0x87
+			j.w.Write([]byte{0x2c})
+			j.w.Write(desc)
+		} else {
+			desc[8] = desc[8]&^0x07 | 0x80 | byte(colorTableBits(len(table)/3))
+			j.w.Write([]byte{0x2c})
+			j.w.Write(desc)
+			j.w.Write(table)
+		}
+		litWidth, err := r.ReadByte()
+		if err != nil {
+			return errTruncated
+		}
+		data, err := readSubBlocks(r)
+		if err != nil {
+			return err
+		}
+		j.w.WriteByte(litWidth)
+		_, err = j.w.Write(data)
+		return err
+	}
+
+	// map the colors of the frame to the palette, like usePalette
+	opaque := j.palette[:len(j.palette)-1]
+	mapping := make([]byte, len(table)/3)
+	for i := range mapping {
+		if i == transparent {
+			mapping[i] = byte(len(opaque))
+			continue
+		}
+		mapping[i] = byte(opaque.Index(color.RGBA{table[3*i], table[3*i+1], table[3*i+2], 0xff}))
+	}
+	litWidth, err := r.ReadByte()
+	if err != nil {
+		return errTruncated
+	}
+	if litWidth < 2 || litWidth > 8 {
+		return fmt.Errorf("bad LZW code size %d", litWidth)
+	}
+	width := int(binary.LittleEndian.Uint16(desc[4:]))
+	height := int(binary.LittleEndian.Uint16(desc[6:]))
+	pix := make([]byte, width*height)
+	br := &blockReader{r: r}
+	lr := lzw.NewReader(br, lzw.LSB, int(litWidth))
+	if _, err := io.ReadFull(lr, pix); err != nil {
+		lr.Close()
+		return fmt.Errorf("frame: %v", err)
+	}
+	lr.Close()
+	// encoders may leave padding after the pixels
+	if _, err := io.Copy(ioutil.Discard, br); err != nil {
+		return err
+	}
+	for i, index := range pix {
+		if int(index) >= len(mapping) {
+			return fmt.Errorf("a frame has a color that isn't in its table")
+		}
+		pix[i] = mapping[index]
+	}
+
+	desc[8] &^= 0x87
+	j.w.Write([]byte{0x2c})
+	j.w.Write(desc)
+	litWidth = byte(colorTableBits(len(j.palette)) + 1)
+	if litWidth < 2 {
+		litWidth = 2
+	}
+	j.w.WriteByte(litWidth)
+	bw := &blockWriter{w: j.w}
+	lw := lzw.NewWriter(bw, lzw.LSB, int(litWidth))
+	if _, err := lw.Write(pix); err != nil {
+		return err
+	}
+	if err := lw.Close(); err != nil {
+		return err
+	}
+	return bw.close()
+}
+
+// readSubBlocks reads gif data sub-blocks, as they are with their sizes and
+// the empty block that ends them.
+func readSubBlocks(r *bufio.Reader) ([]byte, error) {
+	var data []byte
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return nil, errTruncated
+		}
+		data = append(data, size)
+		if size == 0 {
+			return data, nil
+		}
+		start := len(data)
+		data = append(data, make([]byte, size)...)
+		if _, err := io.ReadFull(r, data[start:]); err != nil {
+			return nil, errTruncated
+		}
+	}
+}
+
+// blockReader reads the data of gif sub-blocks as one stream.
+type blockReader struct {
+	r    *bufio.Reader
+	left int
+	done bool
+}
+
+func (b *blockReader) Read(p []byte) (int, error) {
+	for b.left == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		size, err := b.r.ReadByte()
+		if err != nil {
+			return 0, errTruncated
+		}
+		b.left = int(size)
+		b.done = size == 0
+	}
+	if len(p) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= n
+	if err == io.EOF {
+		err = errTruncated
+	}
+	return n, err
+}
+
+// blockWriter writes a stream as gif sub-blocks; close writes the last one
+// and the empty block after it.
+type blockWriter struct {
+	w   *bufio.Writer
+	buf [255]byte
+	n   int
+}
+
+func (b *blockWriter) Write(p []byte) (int, error) {
+	for i, c := range p {
+		b.buf[b.n] = c
+		b.n++
+		if b.n == len(b.buf) {
+			if err := b.flush(); err != nil {
+				return i, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (b *blockWriter) flush() error {
+	b.w.WriteByte(byte(b.n))
+	_, err := b.w.Write(b.buf[:b.n])
+	b.n = 0
+	return err
+}
+
+func (b *blockWriter) close() error {
+	if b.n > 0 {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	return b.w.WriteByte(0)
+}
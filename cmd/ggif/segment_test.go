@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testGif writes a gif of frames 4x2 pixels, each filled with the index of
+// its color, to fname.  global puts the colors in the global color table
+// instead of in each frame's own.
+func testGif(t *testing.T, fname string, colors color.Palette, frames []uint8, global bool) {
+	g := &gif.GIF{LoopCount: 0}
+	for i, index := range frames {
+		img := image.NewPaletted(image.Rect(0, 0, 4, 2), colors)
+		for p := range img.Pix {
+			img.Pix[p] = index
+		}
+		g.Image = append(g.Image, img)
+		g.Delay = append(g.Delay, 10+i)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	if global {
+		g.Config = image.Config{ColorModel: colors, Width: 4, Height: 2}
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinGifs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ggif-join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	parts := []string{filepath.Join(dir, "a.gif"), filepath.Join(dir, "b.gif")}
+	clear := color.RGBA{}
+	testGif(t, parts[0], color.Palette{red, green, clear}, []uint8{0, 1, 2}, true)
+	testGif(t, parts[1], color.Palette{blue, red}, []uint8{0, 1, 0}, false)
+	want := []color.Color{red, green, clear, blue, red, blue}
+
+	for _, palette := range []color.Palette{nil, {blue, green, red, clear}} {
+		outfn := filepath.Join(dir, "out.gif")
+		if err := joinGifs(parts, outfn, palette); err != nil {
+			t.Fatalf("palette %v: %v", palette, err)
+		}
+		f, err := os.Open(outfn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		g, err := gif.DecodeAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("palette %v: joined gif: %v", palette, err)
+		}
+		if len(g.Image) != len(want) {
+			t.Fatalf("palette %v: %d frames, want %d", palette, len(g.Image), len(want))
+		}
+		for i, img := range g.Image {
+			r, gr, b, a := img.At(1, 1).RGBA()
+			wr, wg, wb, wa := want[i].RGBA()
+			if a != wa || a != 0 && (r != wr || gr != wg || b != wb) {
+				t.Errorf("palette %v: frame %d is %v, want %v", palette, i, img.At(1, 1), want[i])
+			}
+			if palette != nil && len(img.Palette) != len(palette) {
+				t.Errorf("palette %v: frame %d has %d colors", palette, i, len(img.Palette))
+			}
+		}
+		if g.Delay[4] != 11 {
+			t.Errorf("palette %v: delays %v", palette, g.Delay)
+		}
+	}
+}
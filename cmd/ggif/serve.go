@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/urfave/cli/v2"
+)
+
+// maxVideoSize caps uploaded and downloaded videos.
+const maxVideoSize = 1 << 30
+
+// httpStatus maps the exit code of a failed conversion to a response status.
+func httpStatus(err error) int {
+	switch exitCode(err) {
+	case exitUsage:
+		return http.StatusBadRequest
+	case exitConvert:
+		return http.StatusUnprocessableEntity
+	case exitUpload:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// saveVideo copies r into a new file in dir, keeping the extension of name
+// so ffmpeg can tell the format.
+func saveVideo(dir string, name string, r io.Reader) (string, error) {
+	f, err := ioutil.TempFile(dir, "video*"+filepath.Ext(name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, maxVideoSize+1))
+	if err != nil {
+		return "", err
+	}
+	if n > maxVideoSize {
+		return "", exitError(exitUsage, fmt.Errorf("video is larger than %s", humanSize(maxVideoSize)))
+	}
+	return f.Name(), nil
+}
+
+// sharedAddressSpace is 100.64.0.0/10, the range carrier-grade NAT and
+// tailnets hand out, which IsPrivate leaves out.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP reports whether ip is an address out on the internet rather
+// than one of this machine, its network or a cloud metadata service.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		sharedAddressSpace.Contains(ip))
+}
+
+// checkFetchAddr refuses connections to addresses that aren't public, so a
+// posted url can't reach into the network the server sits in.
+func checkFetchAddr(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("refusing to fetch from %s, it is not a public address", host)
+	}
+	return nil
+}
+
+// fetchClient returns the client videos are fetched with.  Every connection
+// it makes is checked, so neither a redirect nor a dns answer that changes
+// after the url was checked can point it at a private address.  It doesn't
+// go through the proxy, which would make the connections instead.
+func fetchClient() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkFetchAddr,
+	}).DialContext
+	return &http.Client{
+		Timeout:   10 * time.Minute,
+		Transport: t,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirected to a %s url", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// fetchVideo downloads the video at src into dir.  Only http and https
+// urls of public hosts are fetched.
+func fetchVideo(ctx context.Context, dir string, src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return "", exitError(exitUsage, fmt.Errorf("url must be http or https: %q", src))
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return "", exitError(exitUsage, err)
+	}
+	for _, addr := range addrs {
+		if !publicIP(addr.IP) {
+			return "", exitError(exitUsage, fmt.Errorf("refusing to fetch %s, %s is not a public address", src, addr.IP))
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return "", exitError(exitUsage, err)
+	}
+	resp, err := fetchClient().Do(req)
+	if err != nil {
+		return "", exitError(exitUsage, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", exitError(exitUsage, fmt.Errorf("%s: %s", src, resp.Status))
+	}
+	return saveVideo(dir, path.Base(u.Path), resp.Body)
+}
+
+// receiveVideo stores the video of a request, sent either as the "video"
+// field of a multipart form or as {"url": "..."} to fetch it from.
+func receiveVideo(w http.ResponseWriter, r *http.Request, dir string) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, maxVideoSize+1<<20)
+		file, header, err := r.FormFile("video")
+		if err != nil {
+			return "", exitError(exitUsage, fmt.Errorf("video field: %v", err))
+		}
+		defer file.Close()
+		return saveVideo(dir, header.Filename, file)
+	case "application/json":
+		var req struct {
+			URL string `json:"url"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			return "", exitError(exitUsage, err)
+		}
+		return fetchVideo(r.Context(), dir, req.URL)
+	}
+	return "", exitError(exitUsage, fmt.Errorf("send a multipart form with a video field or json with a url"))
+}
+
+// convertHandler queues a posted video like POST /jobs but waits for the
+// conversion and responds with the result, urls included.  Clients that
+// accept image/gif get the gif instead.
+func convertHandler(c *cli.Context, q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("use POST"))
+			return
+		}
+		if strings.Contains(r.Header.Get("Accept"), "image/gif") {
+			serveGif(w, r, c, q)
+			return
+		}
+
+		// someone is waiting for the answer
+		queued, ok := queueVideo(q, w, r, "high")
+		if !ok {
+			return
+		}
+		state, ok := q.wait(r.Context(), queued.ID)
+		if !ok {
+			// nobody is left to hand the gif to
+			if j, ok := q.lookup(queued.ID); ok {
+				q.abort(j)
+			}
+			return
+		}
+		switch {
+		case state.Result == nil:
+			// failed before there was anything to report, or cancelled
+			err := state.err
+			if err == nil {
+				err = fmt.Errorf("job %s ended without a result", state.ID)
+			}
+			log.Errorf("job %s: %v", state.ID, err)
+			writeError(w, httpStatus(err), err)
+		case state.err != nil:
+			log.Errorf("job %s: %v", state.ID, state.err)
+			writeJSON(w, httpStatus(state.err), state.Result)
+		default:
+			writeJSON(w, http.StatusOK, state.Result)
+		}
+	}
+}
+
+// loopbackAddr reports whether a listen address only takes connections
+// from this machine.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// tokenMatches reports whether an authorization header carries the bearer
+// token, in constant time so the token can't be guessed a byte at a time.
+func tokenMatches(auth string, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) == 1
+}
+
+// requireToken rejects requests without the bearer token, when one is set.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tokenMatches(r.Header.Get("Authorization"), token) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or wrong token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serverMode sets the flags for answering requests: results go into the
+// responses, not to stdout or the clipboard.
+func serverMode(c *cli.Context) error {
+	for _, name := range []string{"json", "no-clipboard"} {
+		if err := setFlag(c, name, "true"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func serve(c *cli.Context) error {
+	if err := serverMode(c); err != nil {
+		return err
+	}
+
+	if c.Int("workers") < 0 {
+		return exitError(exitUsage, fmt.Errorf("--workers must be 0 or more"))
+	}
+	jobs := newJobQueue(c, c.Int("workers"))
+	mux := http.NewServeMux()
+	mux.Handle("/convert", convertHandler(c, jobs))
+	mux.Handle("/jobs", jobsHandler(jobs))
+	mux.Handle("/jobs/", jobsHandler(jobs))
+	mux.Handle("/work", workHandler(jobs))
+	mux.Handle("/work/", workHandler(jobs))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	addr := c.String("addr")
+	token := c.String("token")
+	root := http.NewServeMux()
+	handleHealth(root, c, jobs)
+	root.Handle("/", requireToken(token, mux))
+	if token == "" && !loopbackAddr(addr) {
+		log.Warningf("Serving on %s without --token, anyone who can reach it can upload to your bucket", addr)
+	}
+	log.Noticef("Listening on %s", addr)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           root,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-c.Context.Done()
+		// in-flight requests end as their jobs are cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	log.Noticef("Stopped listening on %s", addr)
+	return nil
+}
+
+var serveCommand = &cli.Command{
+	Name:  "serve",
+	Usage: "run an http api that converts posted videos and responds with the urls",
+	Description: `POST /convert with a multipart form holding the video in a "video"
+   field, or with json naming a url to fetch it from.  The response is the
+   same json as ggif --json prints.
+
+   POST /jobs takes the same request but answers right away with a job id.
+   GET /jobs/{id} returns the job's status, stage, progress and, once done,
+   its result; GET /jobs/{id}/events streams them as server-sent events.
+   DELETE /jobs/{id} cancels a job, as does hanging up on POST /convert.
+   Jobs are taken most urgent first: both queue at high priority and take
+   ?priority=high, normal or low, to let other jobs go first.  Up to 256
+   jobs can wait at each priority.
+
+   POST /convert with "Accept: image/gif" responds with the gif itself,
+   which is neither kept nor uploaded.  It is converted on this machine
+   rather than queued, and needs --workers above 0.
+   Jobs are converted here and by any ggif worker pointed at this server;
+   here, at most --workers conversions run at once, gifs streamed back
+   included.
+
+   GET /metrics serves prometheus metrics.  GET /healthz and GET /readyz
+   are for liveness and readiness probes and need no token; /readyz answers
+   503 while ffmpeg, gifski or gsutil is missing.
+
+   Examples:
+      ggif serve --addr :8080 --token s3cret
+      curl -H 'Authorization: Bearer s3cret' -F video=@demo.mov localhost:8080/convert
+      curl -H 'Authorization: Bearer s3cret' -H 'Content-Type: application/json' \
+         -d '{"url": "https://example.com/demo.mp4"}' localhost:8080/convert
+      curl -H 'Authorization: Bearer s3cret' -F video=@demo.mov localhost:8080/jobs
+      curl -N -H 'Authorization: Bearer s3cret' localhost:8080/jobs/<id>/events`,
+	Before: sweepBefore,
+	Action: serve,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "addr",
+			EnvVars: []string{"GGIF_ADDR"},
+			Value:   "localhost:8080",
+			Usage:   "address to listen on",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			EnvVars: []string{"GGIF_TOKEN"},
+			Usage:   "require this bearer token on every request",
+		},
+		&cli.IntFlag{
+			Name:    "workers",
+			EnvVars: []string{"GGIF_WORKERS"},
+			Value:   runtime.NumCPU(),
+			Usage:   "conversions to run here at once, 0 to leave them all to ggif worker",
+		},
+	},
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// sidecarSuffix is added to the name of a recording for the name of its
+// sidecar, like recording.mov.ggif.json.
+const sidecarSuffix = ".ggif.json"
+
+func sidecarName(videoFile string) string {
+	return videoFile + sidecarSuffix
+}
+
+// caption is text drawn over the gif from one point of the recording to
+// another, to the end when to is 0.
+type caption struct {
+	text string
+	from float64
+	to   float64
+}
+
+type captionsKey struct{}
+
+// captionsOf returns the captions of the recording ctx converts.
+func captionsOf(ctx context.Context) []caption {
+	captions, _ := ctx.Value(captionsKey{}).([]caption)
+	return captions
+}
+
+// settingString turns a value decoded from json into what it would be
+// on the command line.
+func settingString(value interface{}) string {
+	if i, ok := toInt(value); ok {
+		return strconv.Itoa(i)
+	}
+	return fmt.Sprint(value)
+}
+
+// parseCaptions reads the captions of a sidecar: a list of objects with the
+// text and the from and to timestamps of the recording it is shown between.
+func parseCaptions(fname string, value interface{}) ([]caption, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: \"captions\" should be a list, got %T", fname, value)
+	}
+	captions := make([]caption, len(list))
+	for i, item := range list {
+		fields, _ := item.(map[string]interface{})
+		text, _ := fields["text"].(string)
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("%s: caption %d has no text", fname, i+1)
+		}
+		captions[i].text = text
+		for _, t := range []struct {
+			name string
+			secs *float64
+		}{{"from", &captions[i].from}, {"to", &captions[i].to}} {
+			value, ok := fields[t.name]
+			if !ok {
+				continue
+			}
+			secs, err := parseTimestamp(settingString(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: caption %d %s: %v", fname, i+1, t.name, err)
+			}
+			*t.secs = secs
+		}
+		if captions[i].to != 0 && captions[i].to <= captions[i].from {
+			return nil, fmt.Errorf("%s: caption %d ends before it starts", fname, i+1)
+		}
+	}
+	return captions, nil
+}
+
+// profileSettings returns the settings of a profile of the config files,
+// for a sidecar that picks one.
+func profileSettings(c *cli.Context, fname string, profile string) (map[string]interface{}, error) {
+	m, err := readConfigMap(c, "load")
+	if err != nil {
+		return nil, err
+	}
+	settings := m.profileKeys(profile)
+	if settings == nil {
+		return nil, fmt.Errorf("%s: profile %q not found", fname, profile)
+	}
+	pm := &configMap{
+		file:    m.origin("profiles"),
+		values:  make(map[string]interface{}, len(settings)),
+		project: make(map[string]bool, len(settings)),
+	}
+	for key, value := range settings {
+		pm.values[key] = value
+		pm.project[key] = m.project["profiles."+profile]
+	}
+	if err := checkSecretRefs(pm, c.Bool("allow-project-secrets")); err != nil {
+		return nil, err
+	}
+	return pm.values, nil
+}
+
+// sidecarKeys are what a sidecar may have: settings that only change how
+// the gif looks, the start and end of the clip, a profile and captions.
+// Whoever can drop a file into a watched folder can write a sidecar, so
+// where gifs go, what runs and what is shared stay with the config.
+var sidecarKeys = []string{"quality", "frames", "width", "palette", "dither", "start", "end", "profile", "captions"}
+
+func isSidecarKey(key string) bool {
+	for _, k := range sidecarKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// readSidecar reads the sidecar of videoFile into the settings it changes
+// and its captions.  A sidecar can have the sidecarKeys, a profile being
+// one of the config files whose settings then apply.  It returns nil
+// settings when there is no sidecar.
+func readSidecar(c *cli.Context, videoFile string) (map[string]interface{}, []caption, error) {
+	fname := sidecarName(videoFile)
+	if !fileExists(fname) {
+		return nil, nil, nil
+	}
+	values, err := readConfigFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// a sidecar can sit in a shared or synced folder, it never gets to run
+	// a credential helper
+	for _, key := range sortedKeys(values) {
+		if !isSidecarKey(key) {
+			return nil, nil, fmt.Errorf("%s: %q can't be set in a sidecar, only %s", fname, key, strings.Join(sidecarKeys, ", "))
+		}
+		if s, ok := values[key].(string); ok && isSecretRef(s) {
+			return nil, nil, fmt.Errorf("%s: %q can't refer to a secret in a sidecar", fname, key)
+		}
+	}
+
+	settings := make(map[string]interface{})
+	if value, ok := values["profile"]; ok {
+		profile, _ := value.(string)
+		if settings, err = profileSettings(c, fname, profile); err != nil {
+			return nil, nil, err
+		}
+		delete(values, "profile")
+	}
+	var captions []caption
+	if value, ok := values["captions"]; ok {
+		if captions, err = parseCaptions(fname, value); err != nil {
+			return nil, nil, err
+		}
+		delete(values, "captions")
+	}
+	// trim points are flags rather than settings, they are only ever
+	// right for one recording
+	for _, key := range []string{"start", "end"} {
+		if value, ok := values[key]; ok {
+			if _, err := parseTimestamp(settingString(value)); err != nil {
+				return nil, nil, fmt.Errorf("%s: %q %v", fname, key, err)
+			}
+			settings[key] = value
+			delete(values, key)
+		}
+	}
+
+	errs := validateConfig(fname, values, appFlags(c))
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, err := range errs {
+			msgs[i] = err.Error()
+		}
+		return nil, nil, errors.New(strings.Join(msgs, "\n"))
+	}
+	for key, value := range values {
+		settings[key] = value
+	}
+	return settings, captions, nil
+}
+
+// sidecarContext returns a context with the settings of the sidecar of
+// videoFile, if it has one, and ctx with its captions.  c is left alone
+// for the recordings converted next to it.
+func sidecarContext(ctx context.Context, c *cli.Context, videoFile string) (context.Context, *cli.Context, error) {
+	settings, captions, err := readSidecar(c, videoFile)
+	if err != nil || settings == nil {
+		return ctx, c, err
+	}
+	log.Infof("Using %s", sidecarName(videoFile))
+
+	set := flag.NewFlagSet(sidecarName(videoFile), flag.ContinueOnError)
+	for _, key := range sortedKeys(settings) {
+		value := settingString(settings[key])
+		set.String(key, value, "")
+		set.Set(key, value)
+	}
+	if len(captions) > 0 {
+		ctx = context.WithValue(ctx, captionsKey{}, captions)
+	}
+	return ctx, cli.NewContext(c.App, set, c), nil
+}
+
+// processWithSidecar is processWith for watch mode, where recordings can
+// come with a sidecar.
+func processWithSidecar(ctx context.Context, c *cli.Context, videoFile string, onStage func(stage string)) (*result, error) {
+	ctx, sc, err := sidecarContext(ctx, c, videoFile)
+	if err != nil {
+		res := &result{
+			Source:    videoFile,
+			URLs:      []string{},
+			Durations: make(map[string]float64),
+			Error:     err.Error(),
+		}
+		if l := jobLogOf(ctx); l != nil {
+			res.Job = l.id
+		}
+		return res, exitError(exitConfig, err)
+	}
+	return processWith(ctx, sc, videoFile, onStage)
+}
+
+// escapeFilterValue escapes an option value of a filter in an ffmpeg
+// filtergraph: once for the options of the filter, then once for the graph.
+func escapeFilterValue(s string) string {
+	escape := func(s string, special string) string {
+		var b strings.Builder
+		for _, r := range s {
+			if strings.ContainsRune(special, r) {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		}
+		return b.String()
+	}
+	return escape(escape(s, `\':`), `\'[],;`)
+}
+
+// captionArgs are the ffmpeg arguments that draw the captions of ctx over
+// the frames of the clip starting at start: white text on a dark box at the
+// bottom.
+func captionArgs(ctx context.Context, c *cli.Context, start float64) []string {
+	captions := captionsOf(ctx)
+	if len(captions) == 0 {
+		return nil
+	}
+	// captions are timed on the recording, and ffmpeg's clock starts at
+	// the seek point when it seeks in the input
+	offset := 0.0
+	if before, _ := seekArgs(c, start); before != nil {
+		offset = start
+	}
+
+	filters := make([]string, len(captions))
+	for i, cp := range captions {
+		enable := fmt.Sprintf("gte(t,%g)", cp.from-offset)
+		if cp.to > 0 {
+			enable = fmt.Sprintf("between(t,%g,%g)", cp.from-offset, cp.to-offset)
+		}
+		filters[i] = "drawtext=text=" + escapeFilterValue(cp.text) +
+			":expansion=none:fontcolor=white:fontsize=h/15" +
+			":box=1:boxcolor=black@0.6:boxborderw=12" +
+			":x=(w-text_w)/2:y=h-text_h-h/12" +
+			":enable=" + escapeFilterValue(enable)
+	}
+	return []string{"-vf", strings.Join(filters, ",")}
+}
+
+// sidecarModTime is the time the recording or its sidecar last changed,
+// whichever is later, so editing a sidecar converts its recording again.
+func sidecarModTime(videoFile string, modTime time.Time) time.Time {
+	if fi, err := os.Stat(sidecarName(videoFile)); err == nil && fi.ModTime().After(modTime) {
+		return fi.ModTime()
+	}
+	return modTime
+}
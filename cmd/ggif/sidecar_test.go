@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseCaptions(t *testing.T) {
+	list := func(items ...map[string]interface{}) []interface{} {
+		l := make([]interface{}, len(items))
+		for i, item := range items {
+			l[i] = item
+		}
+		return l
+	}
+	captions, err := parseCaptions("s.json", list(
+		map[string]interface{}{"text": "Open the settings", "from": "0:03", "to": "0:06"},
+		map[string]interface{}{"text": "Saved!", "from": float64(12)},
+		map[string]interface{}{"text": "Hi"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []caption{{"Open the settings", 3, 6}, {"Saved!", 12, 0}, {"Hi", 0, 0}}
+	if len(captions) != len(want) {
+		t.Fatalf("%d captions, want %d", len(captions), len(want))
+	}
+	for i := range want {
+		if captions[i] != want[i] {
+			t.Errorf("caption %d = %+v, want %+v", i, captions[i], want[i])
+		}
+	}
+
+	for _, bad := range []interface{}{
+		"Open the settings",
+		list(map[string]interface{}{"from": "0:01"}),
+		list(map[string]interface{}{"text": "  "}),
+		list(map[string]interface{}{"text": "x", "from": "soon"}),
+		list(map[string]interface{}{"text": "x", "from": "0:05", "to": "0:05"}),
+		list(map[string]interface{}{"text": "x", "from": "0:06", "to": "0:05"}),
+		[]interface{}{"just text"},
+	} {
+		if _, err := parseCaptions("s.json", bad); err == nil {
+			t.Errorf("parseCaptions(%v) should fail", bad)
+		}
+	}
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Saved", "Saved"},
+		{"it's", `it\\\'s`},
+		{"a:b", `a\\:b`},
+		{"x,y;z", `x\,y\;z`},
+		{"[n]", `\[n\]`},
+		{`back\slash`, `back\\\\slash`},
+	}
+	for _, tt := range tests {
+		if got := escapeFilterValue(tt.in); got != tt.want {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCaptionArgsOffset(t *testing.T) {
+	ctx := context.WithValue(context.Background(), captionsKey{}, []caption{
+		{"one", 13, 16},
+		{"two", 20, 0},
+	})
+	tests := []struct {
+		mode  string
+		start float64
+		want  []string
+	}{
+		// ffmpeg's clock starts at the seek point when it seeks the input
+		{"fast", 10, []string{`between(t\,3\,6)`, `gte(t\,10)`}},
+		{"fast", 0, []string{`between(t\,13\,16)`, `gte(t\,20)`}},
+		// and at the start of the recording when it decodes up to it
+		{"accurate", 10, []string{`between(t\,13\,16)`, `gte(t\,20)`}},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("seek-mode", tt.mode, "")
+		c := cli.NewContext(nil, set, nil)
+		args := captionArgs(ctx, c, tt.start)
+		if len(args) != 2 || args[0] != "-vf" {
+			t.Fatalf("captionArgs = %q", args)
+		}
+		filters := strings.Split(args[1], ",drawtext=")
+		if len(filters) != len(tt.want) {
+			t.Fatalf("%d filters in %q, want %d", len(filters), args[1], len(tt.want))
+		}
+		for i, enable := range tt.want {
+			if !strings.HasSuffix(filters[i], ":enable="+enable) {
+				t.Errorf("%s from %v: filter %d is %q, want it enabled %s", tt.mode, tt.start, i, filters[i], enable)
+			}
+		}
+	}
+
+	if args := captionArgs(context.Background(), nil, 10); args != nil {
+		t.Errorf("captionArgs without captions = %q", args)
+	}
+}
+
+func TestSidecarKeys(t *testing.T) {
+	for _, key := range []string{"width", "captions", "profile", "start"} {
+		if !isSidecarKey(key) {
+			t.Errorf("%s should be allowed in a sidecar", key)
+		}
+	}
+	for _, key := range []string{"bucket", "uploader", "routes", "share", "dist", "upload-command"} {
+		if isSidecarKey(key) {
+			t.Errorf("%s shouldn't be allowed in a sidecar", key)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+)
+
+var (
+	// suggestedWidths and suggestedFrames are the --width and --frames
+	// values big gifs are pointed at.
+	suggestedWidths = []int{800, 640, 480, 320}
+	suggestedFrames = []int{15, 12, 10, 8}
+)
+
+// sizeSuggestion is a change of settings with the size it is estimated to
+// give the gif.
+type sizeSuggestion struct {
+	args []string
+	size int64
+}
+
+// pickSuggestion returns the first of the candidates below current whose
+// estimate fits in target, or else the last of them.  estimate gives the
+// size of the gif with a candidate.
+func pickSuggestion(name string, candidates []int, current int, target int64, estimate func(v int) int64) (sizeSuggestion, bool) {
+	var last sizeSuggestion
+	found := false
+	for _, v := range candidates {
+		if v >= current {
+			continue
+		}
+		last = sizeSuggestion{[]string{"--" + name, strconv.Itoa(v)}, estimate(v)}
+		found = true
+		if last.size <= target {
+			break
+		}
+	}
+	return last, found
+}
+
+// sizeSuggestions works out settings that bring the gif of res under
+// target.  A gif grows with the area of its frames and with their number,
+// so the estimates scale its size with the square of the width and with
+// the frame rate and the length of the clip.
+func sizeSuggestions(c *cli.Context, res *result, target int64) []sizeSuggestion {
+	if res.Size <= 0 || target <= 0 {
+		return nil
+	}
+	var suggestions []sizeSuggestion
+	scale := func(ratio float64) int64 {
+		return int64(float64(res.Size) * ratio)
+	}
+
+	// the gif's width is 0 when it couldn't be read
+	if width := res.Width; width > 0 {
+		if s, ok := pickSuggestion("width", suggestedWidths, width, target, func(w int) int64 {
+			r := float64(w) / float64(width)
+			return scale(r * r)
+		}); ok {
+			suggestions = append(suggestions, s)
+		}
+	}
+	if fps := c.Int("frames"); fps > 0 {
+		if s, ok := pickSuggestion("frames", suggestedFrames, fps, target, func(f int) int64 {
+			return scale(float64(f) / float64(fps))
+		}); ok {
+			suggestions = append(suggestions, s)
+		}
+	}
+
+	start, end, err := trimRange(c)
+	if err == nil && end == 0 {
+		end = res.Input.Duration
+	}
+	if err == nil && end > start {
+		ratio := float64(target) / float64(res.Size)
+		newEnd := start + (end-start)*ratio
+		suggestions = append(suggestions, sizeSuggestion{
+			[]string{"--end", formatTimestamp(newEnd)},
+			scale(ratio),
+		})
+	}
+	return suggestions
+}
+
+// warnGifSize warns, before it is uploaded, when the gif of res is bigger
+// than --warn-gif-size, with settings that would make it fit.
+func warnGifSize(c *cli.Context, res *result) {
+	target, _ := parseSize(c.String("warn-gif-size"))
+	if target == 0 || uint64(res.Size) <= target {
+		return
+	}
+	log.Warningf("%s is %s, more than --warn-gif-size %s", res.Output, humanSize(res.Size), c.String("warn-gif-size"))
+	if c.Bool("quiet") || c.Bool("json") {
+		return
+	}
+
+	// warnings aren't logged by default, and this one can save an upload
+	fmt.Fprintf(os.Stderr, "%s is %s, more than --warn-gif-size %s.", res.Output, humanSize(res.Size), c.String("warn-gif-size"))
+	suggestions := sizeSuggestions(c, res, int64(target))
+	if len(suggestions) == 0 {
+		fmt.Fprintln(os.Stderr)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "  To make it smaller:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, s := range suggestions {
+		fmt.Fprintf(w, "  %s\test. %s\n", strings.Join(s.args, " "), humanSize(s.size))
+	}
+	w.Flush()
+}
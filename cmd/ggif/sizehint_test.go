@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestPickSuggestion(t *testing.T) {
+	// a gif of 1000 bytes at 1000 wide, shrinking with the width
+	estimate := func(w int) int64 { return int64(w) }
+	tests := []struct {
+		current int
+		target  int64
+		want    string
+		ok      bool
+	}{
+		{1000, 700, "--width 640", true},
+		{1000, 900, "--width 800", true},
+		{1000, 100, "--width 320", true},
+		{640, 600, "--width 480", true},
+		{320, 100, "", false},
+		{0, 100, "", false},
+	}
+	for _, tt := range tests {
+		s, ok := pickSuggestion("width", suggestedWidths, tt.current, tt.target, estimate)
+		if ok != tt.ok || strings.Join(s.args, " ") != tt.want {
+			t.Errorf("pickSuggestion(%d, %d) = %v %v, want %q %v", tt.current, tt.target, s.args, ok, tt.want, tt.ok)
+		}
+		if !ok {
+			continue
+		}
+		if w, _ := strconv.Atoi(s.args[1]); s.size != estimate(w) {
+			t.Errorf("pickSuggestion(%d, %d) size = %d", tt.current, tt.target, s.size)
+		}
+	}
+}
+
+func TestSizeSuggestionsUnknownSize(t *testing.T) {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.Int("frames", 0, "")
+	set.String("start", "", "")
+	set.String("end", "", "")
+	c := cli.NewContext(nil, set, nil)
+
+	// neither the width of the gif nor the frame rate is known
+	res := &result{Size: 5000}
+	for _, s := range sizeSuggestions(c, res, 1000) {
+		if s.args[0] == "--width" || s.args[0] == "--frames" {
+			t.Errorf("suggested %v without knowing the %s", s.args, s.args[0][2:])
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// configMap is an altsrc.InputSourceContext over an already decoded config
+// file.  Decoding json, yaml and toml ourselves lets profiles and several
+// config files be merged before the values are applied to the flags.
+type configMap struct {
+	file   string
+	values map[string]interface{}
+	// origins records which file a key came from when several were merged;
+	// keys missing from it came from file.
+	origins map[string]string
+	// project marks keys, and "profiles.<name>" for profiles, set by a
+	// project config file rather than one the user picked.
+	project map[string]bool
+}
+
+// profileKeys returns the keys set by the named profile.
+func (m *configMap) profileKeys(profile string) map[string]interface{} {
+	profiles, _ := m.values["profiles"].(map[string]interface{})
+	settings, _ := profiles[profile].(map[string]interface{})
+	return settings
+}
+
+func (m *configMap) Source() string {
+	return m.file
+}
+
+// origin returns the file the value for key was read from.
+func (m *configMap) origin(key string) string {
+	if fname, ok := m.origins[key]; ok {
+		return fname
+	}
+	return m.file
+}
+
+func (m *configMap) typeError(name string, want string, value interface{}) error {
+	return fmt.Errorf("%s: %q should be %s, got %T (%v)", m.origin(name), name, want, value, value)
+}
+
+// toInt accepts the number types the json, yaml and toml decoders produce as
+// long as they hold a whole number.
+func toInt(value interface{}) (int, bool) {
+	switch v := value.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
+
+func (m *configMap) Int(name string) (int, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return 0, nil
+	}
+	i, ok := toInt(value)
+	if !ok {
+		return 0, m.typeError(name, "a whole number", value)
+	}
+	return i, nil
+}
+
+func (m *configMap) Duration(name string) (time.Duration, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return 0, nil
+	}
+	if s, ok := value.(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, m.typeError(name, "a duration like \"30s\"", value)
+		}
+		return d, nil
+	}
+	return 0, m.typeError(name, "a duration like \"30s\"", value)
+}
+
+func (m *configMap) Float64(name string) (float64, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return 0, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	}
+	return 0, m.typeError(name, "a number", value)
+}
+
+func (m *configMap) String(name string) (string, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", m.typeError(name, "a string", value)
+	}
+	return s, nil
+}
+
+func (m *configMap) StringSlice(name string) ([]string, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, m.typeError(name, "a list of strings", value)
+	}
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			return nil, m.typeError(name, "a list of strings", value)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+func (m *configMap) IntSlice(name string) ([]int, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, m.typeError(name, "a list of whole numbers", value)
+	}
+	out := make([]int, 0, len(list))
+	for _, item := range list {
+		i, ok := toInt(item)
+		if !ok {
+			return nil, m.typeError(name, "a list of whole numbers", value)
+		}
+		out = append(out, i)
+	}
+	return out, nil
+}
+
+func (m *configMap) Generic(name string) (cli.Generic, error) {
+	return nil, nil
+}
+
+func (m *configMap) Bool(name string) (bool, error) {
+	value, ok := m.values[name]
+	if !ok {
+		return false, nil
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, m.typeError(name, "true or false", value)
+	}
+	return b, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// followPoll is how often a followReader looks for more of a file that is
+// still being written.
+const followPoll = 100 * time.Millisecond
+
+// followReader reads a file while another process writes it, like tail -f,
+// until written is closed and everything has been read.  When the file is
+// not there by then it reads final, where it was renamed to.
+type followReader struct {
+	ctx     context.Context
+	path    string
+	final   string
+	written <-chan struct{}
+	f       *os.File
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for {
+		finished := false
+		select {
+		case <-r.written:
+			finished = true
+		default:
+		}
+
+		if r.f == nil {
+			f, err := os.Open(r.path)
+			if os.IsNotExist(err) && finished && r.final != "" {
+				f, err = os.Open(r.final)
+			}
+			if err != nil && (finished || !os.IsNotExist(err)) {
+				return 0, err
+			}
+			r.f = f
+		}
+		if r.f != nil {
+			n, err := r.f.Read(p)
+			if n > 0 || err != io.EOF || finished {
+				return n, err
+			}
+		}
+
+		select {
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		case <-r.written:
+		case <-time.After(followPoll):
+		}
+	}
+}
+
+func (r *followReader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
+
+// streamUpload copies a gif to the bucket while gifski is still writing it.
+type streamUpload struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+	written chan struct{}
+	done    chan struct{}
+	url     string
+	err     error
+}
+
+// canStreamUpload reports whether the gif at outfn can go to the bucket as
+// it is encoded: nothing may change it after gifski, nobody is asked
+// first, and it must be a new file.
+func canStreamUpload(c *cli.Context, outfn string) bool {
+	return c.Bool("stream-upload") && usesGCS(c) && c.String("bucket") != "" && c.String("routes") == "" &&
+		!c.Bool("no-upload") && !c.Bool("dry-run") && !c.Bool("confirm") &&
+		c.String("palette") == "" && c.Int("segments") <= 1 &&
+		c.String("if-exists") != "skip" && !fileExists(outfn)
+}
+
+// startStreamUpload starts piping outfn into gsutil as outputFile.  Call
+// finish once the gif is written, or abort when encoding failed.
+func startStreamUpload(ctx context.Context, c *cli.Context, outfn string, outputFile string) *streamUpload {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &streamUpload{
+		timeout: toolTimeout(c, true),
+		cancel:  cancel,
+		written: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	bucket := c.String("bucket")
+	dest := fmt.Sprintf("gs://%s/%s", bucket, outputFile)
+	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, outputFile)
+	// a pipe can't be carried on with, so ggif resume sends the written gif
+	resumeArgs := append(append(provenanceHeaders(ctx, c), "cp"), append(storageClassArgs(ctx), outfn, dest)...)
+	rememberUpload(c, pendingUpload{File: outfn, Dest: dest, URL: url, Args: resumeArgs, Started: time.Now()})
+	go func() {
+		defer close(s.done)
+		r := &followReader{ctx: ctx, path: partialName(outfn), final: outfn, written: s.written}
+		defer r.Close()
+
+		args := append(provenanceHeaders(ctx, c), "-h", "Content-Type:image/gif", "cp", "-", dest)
+		cmd := exec.Command("gsutil", gsutilArgs(c, args...)...)
+		cmd.Stdin = limitRate(r, uploadRate(c))
+		if c.Bool("nice") {
+			lowPriority(cmd)
+		}
+		var output bytes.Buffer
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+		log.Debug(cmd.Args)
+		// the upload timeout starts once the gif is written, in finish
+		err := runTool(ctx, cmd, 0)
+		printOutput(output.Bytes())
+		logCommand(ctx, cmd.Args, output.Bytes(), err)
+		if err != nil {
+			if last := lastLine(output.String()); last != "" && ctx.Err() == nil {
+				err = fmt.Errorf("%w: %s", err, last)
+			}
+			s.err = fmt.Errorf("gsutil: %w, run `ggif resume` to upload the gif again", err)
+			return
+		}
+		forgetUpload(c, dest)
+		s.url = url
+	}()
+	return s
+}
+
+// finish waits, up to the upload timeout, for the rest of the written gif
+// to be uploaded and returns its url.
+func (s *streamUpload) finish() (string, error) {
+	close(s.written)
+	var expired <-chan time.Time
+	if s.timeout > 0 {
+		timer := time.NewTimer(s.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-s.done:
+	case <-expired:
+		s.abort()
+		return "", fmt.Errorf("gsutil: %w", &timeoutError{s.timeout})
+	}
+	s.cancel()
+	return s.url, s.err
+}
+
+// abort kills the upload, which leaves no object behind.
+func (s *streamUpload) abort() {
+	s.cancel()
+	<-s.done
+}
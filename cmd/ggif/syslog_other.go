@@ -0,0 +1,16 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/op/go-logging"
+)
+
+// newSyslogBackend fails, there is no syslog here.
+func newSyslogBackend() (logging.Backend, error) {
+	return nil, fmt.Errorf("there is no syslog on %s, use --log-file instead", runtime.GOOS)
+}
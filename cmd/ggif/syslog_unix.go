@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"log/syslog"
+
+	"github.com/op/go-logging"
+)
+
+// newSyslogBackend sends logs to the local syslog daemon as ggif, under the
+// daemon facility with the priority of their level.
+func newSyslogBackend() (logging.Backend, error) {
+	b, err := logging.NewSyslogBackendPriority("ggif", syslog.LOG_DAEMON)
+	if err != nil {
+		return nil, err
+	}
+	return logging.NewBackendFormatter(b, systemFormat), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// teamsCard is the adaptive card posted to Teams: the gif with the name
+// of the recording and a button to open it.
+func teamsCard(e *historyEntry) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					{"type": "TextBlock", "text": filepath.Base(e.Source), "weight": "bolder"},
+					{"type": "Image", "url": e.URLs[0], "altText": filepath.Base(e.Output)},
+				},
+				"actions": []map[string]interface{}{
+					{"type": "Action.OpenUrl", "title": "Open", "url": e.URLs[0]},
+				},
+			},
+		}},
+	}
+}
+
+// shareTeams posts the url as a card to the incoming webhook in the
+// teams-webhook setting, either a Workflows one or an older connector.
+func shareTeams(c *cli.Context, e *historyEntry) (string, error) {
+	hook, err := secretSetting(c, "teams-webhook")
+	if err != nil {
+		return "", err
+	}
+	if hook == "" {
+		return "", fmt.Errorf("no teams webhook configured, set $GGIF_TEAMS_WEBHOOK or teams-webhook to a keyring: or exec: reference to it")
+	}
+	if len(e.URLs) == 0 {
+		return "", fmt.Errorf("%s was never uploaded", e.Output)
+	}
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "POST %s %s\n", redactURL(hook), e.URLs[0])
+		return e.URLs[0], nil
+	}
+
+	body, err := json.Marshal(teamsCard(e))
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(hook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", webhookError(hook, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("teams: %s", resp.Status)
+	}
+	return e.URLs[0], nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// tempPruneAge is how old a temp folder has to be for ggif prune --temp
+// without --older-than.
+const tempPruneAge = 24 * time.Hour
+
+var (
+	tempRootsMu sync.Mutex
+	tempRoots   = make(map[string]bool)
+)
+
+// makeTempDir creates a folder for kind in root, named after this process
+// so that it can be told apart from the folders of other ggifs, running or
+// crashed.
+func makeTempDir(root string, kind string) (string, error) {
+	tempRootsMu.Lock()
+	tempRoots[root] = true
+	tempRootsMu.Unlock()
+	return ioutil.TempDir(root, fmt.Sprintf("ggif-%d-%s", os.Getpid(), kind))
+}
+
+// tempDirOwner returns the pid in the name of a folder made by makeTempDir.
+func tempDirOwner(name string) (int, bool) {
+	parts := strings.SplitN(name, "-", 3)
+	if len(parts) < 3 || parts[0] != "ggif" {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	return pid, err == nil
+}
+
+// removeOwnTempDirs removes the temp folders of this process, for when it
+// exits without running its deferred cleanups.
+func removeOwnTempDirs() {
+	tempRootsMu.Lock()
+	defer tempRootsMu.Unlock()
+	for root := range tempRoots {
+		dirs, _ := filepath.Glob(filepath.Join(root, fmt.Sprintf("ggif-%d-*", os.Getpid())))
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}
+}
+
+// sweepTempDirs removes the folders makeTempDir made in root for ggifs
+// that crashed or were killed, whose processes are gone.  Folders of
+// versions before they carried the pid are left to ggif prune --temp.
+func sweepTempDirs(root string) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if !fi.IsDir() {
+			continue
+		}
+		pid, ok := tempDirOwner(name)
+		if !ok || pid == os.Getpid() || processAlive(pid) {
+			continue
+		}
+		dir := filepath.Join(root, name)
+		if err := os.RemoveAll(dir); err == nil {
+			log.Debugf("Removed %s, left behind by an earlier run", dir)
+		}
+	}
+}
+
+// sweepBefore is the Before of the commands that make temp folders, which
+// clean up after earlier runs first.
+func sweepBefore(c *cli.Context) error {
+	sweepTempDirs(tempDir(c))
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// addToolDirs puts the folder of the ggif binary at the end of $PATH, so
+// ffmpeg and gifski unpacked next to it are found without installing
+// them, as is usual on windows.
+func addToolDirs() {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return
+	}
+	path := os.Getenv("PATH")
+	if path != "" {
+		path += string(os.PathListSeparator)
+	}
+	os.Setenv("PATH", path+filepath.Dir(exe))
+}
+
+// timeoutError is returned by runTool when a tool ran out of time.
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e *timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.timeout)
+}
+
+// isTimeout reports whether err is from a tool that ran out of time.
+func isTimeout(err error) bool {
+	var timedOut *timeoutError
+	return errors.As(err, &timedOut)
+}
+
+// toolTimeout is how long a tool may run: --upload-timeout for gsutil and
+// uploader plugins, --tool-timeout for ffmpeg and gifski.  0 is no limit.
+func toolTimeout(c *cli.Context, upload bool) time.Duration {
+	key := "tool-timeout"
+	if upload {
+		key = "upload-timeout"
+	}
+	// checked by checkSettings
+	d, _ := time.ParseDuration(c.String(key))
+	return d
+}
+
+// runTool runs cmd in a process group of its own and kills the whole group
+// when ctx is done or timeout has passed, so the helpers a tool starts,
+// like gsutil's python workers, don't outlive it.  A killed tool's error is
+// ctx's error or a timeoutError; a tool that succeeded succeeded, even if
+// ctx was done by the time it exited.
+func runTool(ctx context.Context, cmd *exec.Cmd, timeout time.Duration) error {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	newProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-done:
+		}
+	}()
+	err := cmd.Wait()
+	close(done)
+
+	switch {
+	case err == nil:
+		// finished before it could be killed
+		return nil
+	case parent.Err() != nil:
+		return parent.Err()
+	case ctx.Err() != nil:
+		return &timeoutError{timeout}
+	}
+	return err
+}
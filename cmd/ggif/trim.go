@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// parseTimestamp understands seconds ("83.5") as well as mm:ss and
+// hh:mm:ss with optional fractions ("1:23", "1:02:03.5").
+func parseTimestamp(s string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("bad timestamp %q", s)
+	}
+
+	var secs float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return secs, nil
+}
+
+// formatTimestamp prints seconds as m:ss.s, or h:mm:ss.s for long videos.
+func formatTimestamp(secs float64) string {
+	h := int(secs) / 3600
+	m := int(secs) / 60 % 60
+	s := secs - float64(h*3600+m*60)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%04.1f", h, m, s)
+	}
+	return fmt.Sprintf("%d:%04.1f", m, s)
+}
+
+// trimRange returns the start and end (0 when unset) of the part of the
+// video to convert, from --start and --end.
+func trimRange(c *cli.Context) (float64, float64, error) {
+	var start, end float64
+	var err error
+	if s := c.String("start"); s != "" {
+		if start, err = parseTimestamp(s); err != nil {
+			return 0, 0, fmt.Errorf("--start: %v", err)
+		}
+	}
+	if s := c.String("end"); s != "" {
+		if end, err = parseTimestamp(s); err != nil {
+			return 0, 0, fmt.Errorf("--end: %v", err)
+		}
+		if end <= start {
+			return 0, 0, fmt.Errorf("--end %s is not after --start %s", s, c.String("start"))
+		}
+	}
+	return start, end, nil
+}
+
+// seekArgs returns the ffmpeg arguments that start reading at start, to go
+// before and after -i.  With --seek-mode fast ffmpeg seeks in the input,
+// which is quick but can start a few frames off on some recordings;
+// accurate decodes from the beginning and drops the frames before start.
+func seekArgs(c *cli.Context, start float64) (input []string, output []string) {
+	if start <= 0 {
+		return nil, nil
+	}
+	ss := []string{"-ss", strconv.FormatFloat(start, 'f', -1, 64)}
+	if c.String("seek-mode") == "accurate" {
+		return nil, ss
+	}
+	return ss, nil
+}
+
+// ffmpegInputArgs returns the ffmpeg arguments that read the trimmed part of
+// videoFile.
+func ffmpegInputArgs(c *cli.Context, videoFile string) ([]string, error) {
+	start, end, err := trimRange(c)
+	if err != nil {
+		return nil, err
+	}
+	length := 0.0
+	if end > 0 {
+		length = end - start
+	}
+	return clipArgs(c, videoFile, start, length), nil
+}
+
+// clipArgs returns the ffmpeg arguments that read length seconds of
+// videoFile from start, or all of it after start when length is 0.
+func clipArgs(c *cli.Context, videoFile string, start float64, length float64) []string {
+	before, after := seekArgs(c, start)
+	args := append(ffmpegThreadArgs(c), before...)
+	args = append(args, "-i", videoFile)
+	args = append(args, after...)
+	if length > 0 {
+		args = append(args, "-t", strconv.FormatFloat(length, 'f', -1, 64))
+	}
+	return args
+}
+
+// setFlag sets a flag on whichever context in the lineage defines it, so
+// global flags can be changed from within a subcommand.
+func setFlag(c *cli.Context, name string, value string) error {
+	var err error
+	for _, ctx := range c.Lineage() {
+		if ctx.App == nil {
+			continue
+		}
+		if err = ctx.Set(name, value); err == nil {
+			return nil
+		}
+	}
+	return err
+}
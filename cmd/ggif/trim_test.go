@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"12", 12, true},
+		{"12.5", 12.5, true},
+		{" 1:05 ", 65, true},
+		{"1:02:03", 3723, true},
+		{"0:00.25", 0.25, true},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"1:-5", 0, false},
+		{"1::5", 0, false},
+		{"1:2:3:4", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.in)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseTimestamp(%q) error = %v, want ok %v", tt.in, err, tt.ok)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{0, "0:00.0"},
+		{65.25, "1:05.2"},
+		{3723, "1:02:03.0"},
+	}
+	for _, tt := range tests {
+		if got := formatTimestamp(tt.in); got != tt.want {
+			t.Errorf("formatTimestamp(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSeekArgs(t *testing.T) {
+	tests := []struct {
+		mode          string
+		start         float64
+		input, output string
+	}{
+		{"fast", 0, "", ""},
+		{"fast", 12.5, "-ss 12.5", ""},
+		{"accurate", 12.5, "", "-ss 12.5"},
+		{"accurate", 0, "", ""},
+		{"", 3, "-ss 3", ""},
+	}
+	for _, tt := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("seek-mode", tt.mode, "")
+		c := cli.NewContext(nil, set, nil)
+		input, output := seekArgs(c, tt.start)
+		if got := strings.Join(input, " "); got != tt.input {
+			t.Errorf("seekArgs(%s, %v) input = %q, want %q", tt.mode, tt.start, got, tt.input)
+		}
+		if got := strings.Join(output, " "); got != tt.output {
+			t.Errorf("seekArgs(%s, %v) output = %q, want %q", tt.mode, tt.start, got, tt.output)
+		}
+	}
+}
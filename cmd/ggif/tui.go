@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// dashboardRedraw is how often watch --tui redraws its table.
+	dashboardRedraw = 250 * time.Millisecond
+	// dashboardFinished is how many finished conversions stay in the table.
+	dashboardFinished = 10
+	// dashboardLines is how many log lines and failures are shown below it.
+	dashboardLines = 5
+	// dashboardBar is the width of the progress bars.
+	dashboardBar = 20
+)
+
+// dashboardJob is a video of the watch as the dashboard shows it.
+type dashboardJob struct {
+	name     string
+	status   string // queued, converting, done, skipped or failed
+	stage    string
+	progress int
+	started  time.Time
+	finished time.Time
+	url      string
+	err      string
+}
+
+// dashboard is the table watch --tui draws in the terminal: the videos
+// waiting, converting and converted, the urls of the last gifs and why
+// conversions failed, with the latest log lines below.
+type dashboard struct {
+	mu   sync.Mutex
+	out  io.Writer
+	src  string
+	jobs []*dashboardJob
+	// active maps a video to its job while it is queued or converting.
+	active   map[string]*dashboardJob
+	failures []string
+	logs     []string
+	partial  string
+}
+
+func newDashboard(out io.Writer, src string) *dashboard {
+	return &dashboard{out: out, src: src, active: make(map[string]*dashboardJob)}
+}
+
+// track is watchFolder's track, recording the stage a video reached.
+func (d *dashboard) track(name string, stage string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j, ok := d.active[name]
+	if !ok || stage == "queued" {
+		j = &dashboardJob{name: name, status: "queued"}
+		d.active[name] = j
+		d.jobs = append(d.jobs, j)
+	}
+	if stage == "queued" {
+		return
+	}
+	if j.status == "queued" {
+		j.status = "converting"
+		j.started = time.Now()
+	}
+	j.stage = stage
+	if progress, ok := stageProgress[stage]; ok {
+		j.progress = progress
+	}
+}
+
+// finished records the outcome of a conversion and drops the oldest
+// finished jobs from the table.
+func (d *dashboard) finished(res *result, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	j, ok := d.active[res.Source]
+	if !ok {
+		j = &dashboardJob{name: res.Source, started: time.Now()}
+		d.jobs = append(d.jobs, j)
+	}
+	delete(d.active, res.Source)
+	j.finished = time.Now()
+	switch {
+	case err != nil:
+		j.status = "failed"
+		j.err = err.Error()
+		if res.stage != "" {
+			j.err = res.stage + ": " + j.err
+		}
+		failure := filepath.Base(j.name) + ": " + j.err
+		if res.Job != "" {
+			failure += ", see `ggif logs " + res.Job + "`"
+		}
+		d.failures = lastLines(append(d.failures, failure), dashboardLines)
+	case res.Skipped:
+		j.status = "skipped"
+	default:
+		j.status = "done"
+		j.stage = "done"
+		j.progress = stageProgress["done"]
+	}
+	if len(res.URLs) > 0 {
+		j.url = res.URLs[0]
+	} else if err == nil {
+		j.url = res.Output
+	}
+
+	finished := 0
+	for i := len(d.jobs) - 1; i >= 0; i-- {
+		if d.jobs[i].finished.IsZero() {
+			continue
+		}
+		finished++
+		if finished > dashboardFinished {
+			d.jobs = append(d.jobs[:i], d.jobs[i+1:]...)
+		}
+	}
+}
+
+// Write takes the log lines, which would otherwise scroll the table away.
+func (d *dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lines := strings.Split(d.partial+string(p), "\n")
+	d.partial = lines[len(lines)-1]
+	d.logs = lastLines(append(d.logs, lines[:len(lines)-1]...), dashboardLines)
+	return len(p), nil
+}
+
+func lastLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+func progressBar(progress int) string {
+	filled := progress * dashboardBar / 100
+	return fmt.Sprintf("[%s%s] %3d%%", strings.Repeat("#", filled), strings.Repeat("-", dashboardBar-filled), progress)
+}
+
+// shorten cuts s to n characters, marking that it was cut.
+func shorten(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// render writes the dashboard as it is now.
+func (d *dashboard) render(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := make(map[string]int)
+	for _, j := range d.jobs {
+		counts[j.status]++
+	}
+	fmt.Fprintf(w, "ggif watching %s, ctrl-c to stop\n", d.src)
+	fmt.Fprintf(w, "%d queued, %d converting, %d done, %d failed\n\n",
+		counts["queued"], counts["converting"], counts["done"]+counts["skipped"], counts["failed"])
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tVIDEO\tPROGRESS\tSTAGE\tTIME\tURL")
+	now := time.Now()
+	for _, j := range d.jobs {
+		elapsed := "-"
+		switch {
+		case !j.finished.IsZero():
+			elapsed = formatTimestamp(j.finished.Sub(j.started).Seconds())
+		case !j.started.IsZero():
+			elapsed = formatTimestamp(now.Sub(j.started).Seconds())
+		}
+		stage, link := j.stage, j.url
+		if stage == "" {
+			stage = "-"
+		}
+		if j.err != "" {
+			link = j.err
+		}
+		if link == "" {
+			link = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			j.status, shorten(filepath.Base(j.name), 32), progressBar(j.progress), stage, elapsed, shorten(link, 72))
+	}
+	tw.Flush()
+
+	if len(d.failures) > 0 {
+		fmt.Fprintln(w, "\nfailed:")
+		for _, f := range d.failures {
+			fmt.Fprintln(w, "  "+f)
+		}
+	}
+	if len(d.logs) > 0 {
+		fmt.Fprintln(w, "\nlog:")
+		for _, l := range d.logs {
+			fmt.Fprintln(w, "  "+l)
+		}
+	}
+}
+
+// draw redraws the dashboard over the last frame, clearing what is left of
+// each line and below, e.g. urls printed in between.
+func (d *dashboard) draw() {
+	var buf bytes.Buffer
+	d.render(&buf)
+	frame := strings.ReplaceAll(buf.String(), "\n", "\033[K\n")
+	fmt.Fprint(d.out, "\033[H"+frame+"\033[J")
+}
+
+// run draws the dashboard on the alternate screen until ctx is done, then
+// restores the terminal and leaves the last frame in the scrollback.
+func (d *dashboard) run(ctx context.Context) {
+	fmt.Fprint(d.out, "\033[?1049h\033[?25l")
+	ticker := time.NewTicker(dashboardRedraw)
+	defer ticker.Stop()
+	for {
+		d.draw()
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			fmt.Fprint(d.out, "\033[?25h\033[?1049l")
+			d.render(d.out)
+			return
+		}
+	}
+}
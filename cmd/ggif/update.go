@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const releasesURL = "https://api.github.com/repos/neurosnap/ggif/releases/latest"
+
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+func download(url string) ([]byte, error) {
+	resp, err := updateClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func latestRelease() (*release, error) {
+	data, err := download(releasesURL)
+	if err != nil {
+		return nil, err
+	}
+	rel := &release{}
+	if err := json.Unmarshal(data, rel); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// asset returns the download url of the named release asset.
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no %s", r.Tag, name)
+}
+
+// releaseChecksum finds the sha256 of a file in a checksums.txt written by
+// sha256sum.
+func releaseChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			if sum, err := hex.DecodeString(fields[0]); err != nil || len(sum) != sha256.Size {
+				return "", fmt.Errorf("bad checksum for %s: %q", name, fields[0])
+			}
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// parseVersion splits a release version like v1.10.2 or 1.2.0-rc.1 into
+// its major, minor and patch numbers and its pre-release part.
+func parseVersion(s string) ([3]int, string, error) {
+	var nums [3]int
+	v := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		v, pre = v[:i], v[i+1:]
+	}
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return nums, "", fmt.Errorf("bad version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nums, "", fmt.Errorf("bad version %q", s)
+		}
+		nums[i] = n
+	}
+	return nums, pre, nil
+}
+
+// compareVersions returns -1, 0 or 1 as version a is older than, the same
+// as or newer than b, comparing the numbers rather than the text so 1.10
+// comes after 1.9.  A pre-release comes before its release.
+func compareVersions(a string, b string) (int, error) {
+	na, pa, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	nb, pb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range na {
+		switch {
+		case na[i] < nb[i]:
+			return -1, nil
+		case na[i] > nb[i]:
+			return 1, nil
+		}
+	}
+	switch {
+	case pa == pb:
+		return 0, nil
+	case pa == "":
+		return 1, nil
+	case pb == "":
+		return -1, nil
+	case pa < pb:
+		return -1, nil
+	}
+	return 1, nil
+}
+
+// replaceExecutable swaps the running binary for data.  The new file is
+// written next to the old one and renamed over it; windows won't let a
+// running executable be overwritten, but it can be moved out of the way.
+func replaceExecutable(data []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+
+	tmp := exe + ".new"
+	if err := ioutil.WriteFile(tmp, data, 0755); err != nil {
+		return err
+	}
+	old := ""
+	if runtime.GOOS == "windows" {
+		old = exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, exe); err != nil {
+		os.Remove(tmp)
+		if old != "" {
+			// put the running binary back rather than leave no ggif at all
+			if rerr := os.Rename(old, exe); rerr != nil {
+				return fmt.Errorf("%v, and the old binary is left at %s: %v", err, old, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func update(c *cli.Context) error {
+	rel, err := latestRelease()
+	if err != nil {
+		return err
+	}
+	latest := strings.TrimPrefix(rel.Tag, "v")
+	if _, _, err := parseVersion(latest); err != nil {
+		return fmt.Errorf("latest release: %v", err)
+	}
+	if version != "dev" {
+		cmp, err := compareVersions(version, latest)
+		if err != nil {
+			return err
+		}
+		if cmp >= 0 && !c.Bool("force") {
+			fmt.Printf("ggif %s is up to date\n", version)
+			return nil
+		}
+	}
+	if c.Bool("check") {
+		fmt.Printf("ggif %s is available (running %s)\n", latest, version)
+		return nil
+	}
+	if version == "dev" && !c.Bool("force") {
+		return fmt.Errorf("this is a development build, use --force to replace it with %s", latest)
+	}
+
+	name := fmt.Sprintf("ggif_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	binURL, err := rel.asset(name)
+	if err != nil {
+		return err
+	}
+	sumsURL, err := rel.asset("checksums.txt")
+	if err != nil {
+		return err
+	}
+
+	sums, err := download(sumsURL)
+	if err != nil {
+		return err
+	}
+	want, err := releaseChecksum(sums, name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Downloading %s", binURL)
+	data, err := download(binURL)
+	if err != nil {
+		return err
+	}
+	// nothing replaces the binary before it matches the release's checksum,
+	// which catches a corrupt download but, unsigned and from the same
+	// release, not a tampered one
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+
+	if c.Bool("dry-run") {
+		fmt.Fprintf(os.Stderr, "would replace ggif %s with %s\n", version, latest)
+		return nil
+	}
+	if err := replaceExecutable(data); err != nil {
+		return err
+	}
+	fmt.Printf("updated ggif %s to %s\n", version, latest)
+	return nil
+}
+
+var updateCommand = &cli.Command{
+	Name:  "update",
+	Usage: "replace ggif with the latest release from github",
+	Description: `Downloads the release binary for this platform, compares its sha256
+   with the release's checksums.txt and replaces the running executable.
+   The checksums come from the same release and aren't signed: they catch a
+   corrupt or truncated download, not a release that was tampered with.
+
+   Examples:
+      ggif update --check
+      ggif update`,
+	Action: update,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "only report whether a newer release is out",
+		},
+		&cli.BoolFlag{
+			Name:  "force",
+			Usage: "update development builds or reinstall the current release",
+		},
+	},
+}
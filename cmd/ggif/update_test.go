@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.9.0", "1.10.0", -1},
+		{"v1.10", "v1.9", 1},
+		{"1.2.3", "v1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"2.0.0", "1.99.99", 1},
+		{"1.2.0-rc.1", "1.2.0", -1},
+		{"1.2.0-rc.2", "1.2.0-rc.1", 1},
+		{"1.2.0+build.5", "1.2.0", 0},
+	}
+	for _, tt := range tests {
+		got, err := compareVersions(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("compareVersions(%q, %q): %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "dev", "1.x", "1.2.3.4", "1.-2"} {
+		if _, err := compareVersions(bad, "1.0.0"); err == nil {
+			t.Errorf("compareVersions(%q, ...) should fail", bad)
+		}
+	}
+}
+
+func TestReleaseChecksum(t *testing.T) {
+	sum := "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+	sums := []byte(sum + "  ggif_linux_amd64\n" +
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 *ggif_windows_amd64.exe\n" +
+		"nothex  ggif_darwin_arm64\n")
+
+	got, err := releaseChecksum(sums, "ggif_linux_amd64")
+	if err != nil || got != "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08" {
+		t.Errorf("linux checksum = %q, %v", got, err)
+	}
+	if _, err := releaseChecksum(sums, "ggif_windows_amd64.exe"); err != nil {
+		t.Errorf("binary mode checksum: %v", err)
+	}
+	if _, err := releaseChecksum(sums, "ggif_darwin_arm64"); err == nil {
+		t.Error("a checksum that isn't a sha256 should be refused")
+	}
+	if _, err := releaseChecksum(sums, "ggif_freebsd_amd64"); err == nil {
+		t.Error("a missing checksum should be an error")
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atotto/clipboard"
+	"github.com/urfave/cli/v2"
+)
+
+// uploadFile sends the gif to the bucket, or through the uploader plugin,
+// and prints and copies its url once it can be read.  The url is "" when
+// nothing was uploaded.
+func uploadFile(ctx context.Context, c *cli.Context, outfn string, outputFile string) (string, error) {
+	url, err := uploadTo(ctx, c, outfn, outputFile)
+	if err != nil || url == "" {
+		return url, err
+	}
+	if err := waitForURL(ctx, c, url); err != nil {
+		return "", err
+	}
+	announceURL(c, url)
+	return url, nil
+}
+
+// urlPollInterval is how often --wait-for-url asks for the url again.
+const urlPollInterval = time.Second
+
+// waitForURL asks for url with HEAD until it answers 200, up to
+// --wait-for-url, for buckets and CDNs that take a moment before new
+// objects can be read.
+func waitForURL(ctx context.Context, c *cli.Context, url string) error {
+	// checked by checkSettings
+	wait, _ := time.ParseDuration(c.String("wait-for-url"))
+	if wait <= 0 || c.Bool("dry-run") || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	start := time.Now()
+	last := "no answer"
+	for {
+		req, err := http.NewRequestWithContext(waitCtx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		switch {
+		case err == nil:
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				log.Infof("%s could be read after %s", url, time.Since(start).Round(time.Millisecond))
+				return nil
+			}
+			last = resp.Status
+		case waitCtx.Err() == nil:
+			last = err.Error()
+		}
+		log.Debugf("%s: %s", url, last)
+
+		select {
+		case <-time.After(urlPollInterval):
+		case <-waitCtx.Done():
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%s could not be read within %s: %s, see --wait-for-url", url, wait, last)
+		}
+	}
+}
+
+// uploadTo is uploadFile without printing or copying the url.
+func uploadTo(ctx context.Context, c *cli.Context, outfn string, outputFile string) (string, error) {
+	upload := uploadPlugin
+	if usesGCS(c) {
+		if err := checkGCSCredentials(c); err != nil {
+			return "", exitError(exitConfig, err)
+		}
+		upload = uploadGCP
+	}
+	url, err := upload(ctx, c, outfn, outputFile)
+	if err != nil || url == "" {
+		return url, err
+	}
+
+	if fi, err := os.Stat(outfn); err == nil && !c.Bool("dry-run") {
+		uploadBytesTotal.Add(float64(fi.Size()))
+	}
+	return url, nil
+}
+
+// clipboardWarning makes sure a missing clipboard is only complained about
+// once, not for every gif of a watch.
+var clipboardWarning sync.Once
+
+// announceURL prints the url of an uploaded gif and copies it.  The url is
+// printed either way, so a failed copy loses nothing.
+func announceURL(c *cli.Context, url string) {
+	if !c.Bool("json") {
+		fmt.Println(url)
+	}
+	if c.Bool("dry-run") || c.Bool("no-clipboard") {
+		return
+	}
+	if err := copyURL(url); err != nil {
+		clipboardWarning.Do(func() {
+			log.Warning(err)
+			if !c.Bool("quiet") {
+				// warnings aren't logged by default, and this one matters
+				fmt.Fprintf(os.Stderr, "%v, use the printed url, or --no-clipboard to stop trying\n", err)
+			}
+		})
+	}
+}
+
+// copyURL puts url on the clipboard, saying why when it can't.
+func copyURL(url string) error {
+	if clipboard.Unsupported {
+		return fmt.Errorf("the url was not copied, there is no clipboard utility (install xsel, xclip or wl-clipboard)")
+	}
+	if err := clipboard.WriteAll(url); err != nil {
+		return fmt.Errorf("the url was not copied: %v", err)
+	}
+	return nil
+}
+
+// uploadGCP copies the gif to the bucket and returns its public url, or ""
+// when no bucket is configured or the upload was skipped.
+func uploadGCP(ctx context.Context, c *cli.Context, outfn string, outputFile string) (string, error) {
+	bucket := c.String("bucket")
+	if bucket == "" {
+		return "", nil
+	}
+
+	switch c.String("if-exists") {
+	case "skip":
+		if objectExists(ctx, c, bucket, outputFile) {
+			log.Warningf("gs://%s/%s already exists, not uploading %s", bucket, outputFile, outfn)
+			return "", nil
+		}
+	case "rename":
+		ext := filepath.Ext(outputFile)
+		base := strings.TrimSuffix(outputFile, ext)
+		for i := 1; objectExists(ctx, c, bucket, outputFile); i++ {
+			outputFile = fmt.Sprintf("%s-%d%s", base, i, ext)
+		}
+	}
+
+	dest := fmt.Sprintf("gs://%s/%s", bucket, outputFile)
+	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", bucket, outputFile)
+	if !confirmUpload(c, outfn, dest) {
+		log.Warningf("Not uploading %s", outfn)
+		return "", nil
+	}
+
+	if rate := uploadRate(c); rate > 0 && !c.Bool("dry-run") {
+		// gsutil has no rate limit of its own, so it is fed at the rate
+		f, err := os.Open(outfn)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		contentType := mime.TypeByExtension(filepath.Ext(outfn))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		args := append(provenanceHeaders(ctx, c), "-h", "Content-Type:"+contentType, "cp")
+		args = append(args, storageClassArgs(ctx)...)
+		args = append(args, "-", dest)
+		cmd := exec.Command("gsutil", gsutilArgs(c, args...)...)
+		cmd.Stdin = limitRate(f, rate)
+		if err := runCommand(ctx, c, cmd); err != nil {
+			return "", err
+		}
+	} else {
+		// until it finishes, ggif resume can carry on with it
+		fn := keepForResume(c, outfn, dest)
+		args := append(append(provenanceHeaders(ctx, c), "cp"), append(storageClassArgs(ctx), fn, dest)...)
+		rememberUpload(c, pendingUpload{File: fn, Dest: dest, URL: url, Args: args, Started: time.Now()})
+		if err := runCmd(ctx, c, "gsutil", gsutilArgs(c, args...)...); err != nil {
+			return "", fmt.Errorf("%w, run `ggif resume` to carry on with the upload", err)
+		}
+		forgetUpload(c, dest)
+	}
+	return url, nil
+}
+
+// preflightTTL is how long a successful preflight check of a bucket is
+// trusted, so watch mode keeps noticing credentials that expire.
+const preflightTTL = 5 * time.Minute
+
+var (
+	preflightMu sync.Mutex
+	preflighted = make(map[string]time.Time)
+)
+
+// preflightUpload makes sure the gcs-credentials file can be used and, with
+// --preflight, that the bucket exists and the credentials are good before
+// any time goes into a conversion.  Only uploads to google cloud storage
+// are checked.
+func preflightUpload(ctx context.Context, c *cli.Context) error {
+	bucket := c.String("bucket")
+	if c.Bool("no-upload") || c.Bool("dry-run") || !usesGCS(c) || bucket == "" {
+		return nil
+	}
+	if err := checkGCSCredentials(c); err != nil {
+		return exitError(exitConfig, err)
+	}
+	if !c.Bool("preflight") {
+		return nil
+	}
+
+	preflightMu.Lock()
+	defer preflightMu.Unlock()
+	if time.Since(preflighted[bucket]) < preflightTTL {
+		return nil
+	}
+
+	cmd := exec.Command("gsutil", gsutilArgs(c, "ls", "-b", fmt.Sprintf("gs://%s", bucket))...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	log.Debug(cmd.Args)
+	if err := runTool(ctx, cmd, toolTimeout(c, true)); err != nil {
+		if last := lastLine(output.String()); last != "" && !isTimeout(err) {
+			err = fmt.Errorf("%w: %s", err, last)
+		}
+		return fmt.Errorf("gs://%s is not reachable, check the bucket name or run `gcloud auth login`: %w", bucket, err)
+	}
+	preflighted[bucket] = time.Now()
+	return nil
+}
+
+// objectExists reports whether the bucket already has an object by that
+// name.  In a dry run nothing is asked and nothing exists.
+func objectExists(ctx context.Context, c *cli.Context, bucket string, name string) bool {
+	if c.Bool("dry-run") {
+		return false
+	}
+	cmd := exec.Command("gsutil", gsutilArgs(c, "-q", "stat", fmt.Sprintf("gs://%s/%s", bucket, name))...)
+	log.Debug(cmd.Args)
+	return runTool(ctx, cmd, toolTimeout(c, true)) == nil
+}
+
+// confirmMu keeps the questions of parallel conversions from interleaving.
+var confirmMu sync.Mutex
+
+// stdinLines are the lines read from stdin by a single goroutine, shared by
+// everything waiting for input: a line typed while a recording runs goes
+// to whoever asks next instead of into a reader nobody listens to anymore.
+var (
+	stdinOnce  sync.Once
+	stdinLines chan string
+)
+
+// readStdin starts reading stdin, the first time it is called, and
+// returns its lines.  The channel is closed at the end of stdin.
+func readStdin() <-chan string {
+	stdinOnce.Do(func() {
+		stdinLines = make(chan string)
+		go func() {
+			defer close(stdinLines)
+			in := bufio.NewReader(os.Stdin)
+			for {
+				line, err := in.ReadString('\n')
+				if line != "" {
+					stdinLines <- line
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	})
+	return stdinLines
+}
+
+// drainStdin drops what was typed before a question was asked, like an
+// enter pressed after a recording stopped by itself.
+func drainStdin() {
+	lines := readStdin()
+	for {
+		select {
+		case _, ok := <-lines:
+			if !ok {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// confirmUpload asks before a gif goes to the bucket when --confirm is given.
+// Without a terminal to ask on the answer is no.
+func confirmUpload(c *cli.Context, outfn string, dest string) bool {
+	if !c.Bool("confirm") || c.Bool("dry-run") {
+		return true
+	}
+	if !isTerminal(os.Stdin) {
+		log.Warning("--confirm needs a terminal to ask on")
+		return false
+	}
+
+	var size int64
+	if fi, err := os.Stat(outfn); err == nil {
+		size = fi.Size()
+	}
+
+	confirmMu.Lock()
+	defer confirmMu.Unlock()
+	drainStdin()
+	fmt.Fprintf(os.Stderr, "Upload %s to %s? [y/N] ", humanSize(size), dest)
+	answer := <-readStdin()
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y")
+}
+
+// upload sends an existing gif to the bucket.
+func upload(c *cli.Context) error {
+	if c.Args().Len() != 1 {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif upload <file>"))
+	}
+	if err := checkUploader(c); err != nil {
+		return err
+	}
+
+	outfn := c.Args().First()
+	if !fileExists(outfn) {
+		return exitError(exitUsage, fmt.Errorf("%s does not exist", outfn))
+	}
+	if err := checkGif(c, outfn); err != nil {
+		return exitError(exitUsage, err)
+	}
+	// the gif may come from anywhere, its metadata stays here
+	uploadfn, cleanup, err := strippedCopy(c, outfn)
+	if err != nil {
+		return exitError(exitUsage, err)
+	}
+	defer cleanup()
+	sum, _ := fileSHA256(uploadfn)
+	url, err := uploadFile(withGifProvenance(c.Context, c, sum), c, uploadfn, filepath.Base(outfn))
+	if err != nil {
+		return exitError(exitUpload, err)
+	}
+	if c.Bool("json") {
+		printJSON(map[string]interface{}{"output": outfn, "urls": []string{url}})
+	}
+
+	if url != "" {
+		res := &result{Source: outfn, Output: outfn, URLs: []string{url}, SHA256: sum}
+		if fi, err := os.Stat(uploadfn); err == nil {
+			res.Size = fi.Size()
+		}
+		recordHistory(c, res)
+	}
+	return nil
+}
+
+var uploadCommand = &cli.Command{
+	Name:  "upload",
+	Usage: "upload an existing gif and copy its url",
+	Description: `Examples:
+      ggif upload 1590000000.gif
+      ggif --bucket other-bucket upload demo.gif`,
+	ArgsUsage: "<file>",
+	Action:    upload,
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDrainStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	lines := readStdin()
+	// an enter pressed after a recording stopped by itself
+	w.Write([]byte("\n"))
+	time.Sleep(50 * time.Millisecond)
+	drainStdin()
+
+	w.Write([]byte("y\n"))
+	if answer := <-lines; answer != "y\n" {
+		t.Errorf("answer after drainStdin = %q, want %q", answer, "y\n")
+	}
+	w.Write([]byte("last"))
+	w.Close()
+	if line := <-lines; line != "last" {
+		t.Errorf("unterminated last line = %q", line)
+	}
+	if _, ok := <-lines; ok {
+		t.Error("lines aren't closed at the end of stdin")
+	}
+}
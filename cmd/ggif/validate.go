@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// configRules check settings whose valid values are narrower than their
+// type.
+var configRules = map[string]func(value interface{}) error{
+	"quality":                     intBetween(1, 100),
+	"frames":                      intBetween(1, 100),
+	"width":                       intAtLeast(1),
+	"jobs":                        intAtLeast(1),
+	"segments":                    intAtLeast(1),
+	"max-frames":                  intAtLeast(0),
+	"max-tmp-size":                sizeValue,
+	"max-gif-size":                sizeValue,
+	"warn-gif-size":               sizeValue,
+	"ffmpeg-threads":              intAtLeast(0),
+	"tool-timeout":                durationValue,
+	"upload-timeout":              durationValue,
+	"wait-for-url":                durationValue,
+	"limit-rate":                  rateValue,
+	"upload-part-size":            sizeValue,
+	"upload-parallelism":          intAtLeast(0),
+	"routes":                      routesValue,
+	"email-max-attachment":        sizeValue,
+	"share":                       knownShareTargets,
+	"gcs-credentials":             gcsCredentialsFile,
+	"impersonate-service-account": serviceAccountEmail,
+	"log":                         logLevelName,
+	"log-format":                  oneOf("text", "json"),
+	"log-to":                      oneOf("stderr", "syslog", "journald"),
+	"if-exists":                   oneOf("skip", "overwrite", "rename"),
+	"palette":                     paletteValue,
+	"dither":                      oneOf("floyd-steinberg", "none"),
+	"seek-mode":                   oneOf("fast", "accurate"),
+}
+
+func intBetween(min int, max int) func(value interface{}) error {
+	return func(value interface{}) error {
+		if i := value.(int); i < min || i > max {
+			return fmt.Errorf("must be between %d and %d, got %d", min, max, i)
+		}
+		return nil
+	}
+}
+
+func intAtLeast(min int) func(value interface{}) error {
+	return func(value interface{}) error {
+		if i := value.(int); i < min {
+			return fmt.Errorf("must be at least %d, got %d", min, i)
+		}
+		return nil
+	}
+}
+
+func oneOf(choices ...string) func(value interface{}) error {
+	return func(value interface{}) error {
+		for _, choice := range choices {
+			if value.(string) == choice {
+				return nil
+			}
+		}
+		return fmt.Errorf("must be one of %s, got %q", strings.Join(choices, ", "), value)
+	}
+}
+
+func sizeValue(value interface{}) error {
+	_, err := parseSize(value.(string))
+	return err
+}
+
+func rateValue(value interface{}) error {
+	_, err := parseRateLimit(value.(string))
+	return err
+}
+
+func durationValue(value interface{}) error {
+	if d, err := time.ParseDuration(value.(string)); err != nil || d < 0 {
+		return fmt.Errorf("must be a duration like 90s or 10m, 0 for no limit, got %q", value)
+	}
+	return nil
+}
+
+func logLevelName(value interface{}) error {
+	if _, err := parseLogLevels(value.(string)); err != nil {
+		return fmt.Errorf("must be one of CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG, optionally per module as module=LEVEL, got %q", value)
+	}
+	return nil
+}
+
+// checkConfigValue applies the rule for key, if there is one, to an already
+// type checked value.
+func checkConfigValue(key string, value interface{}) error {
+	rule, ok := configRules[key]
+	if !ok {
+		return nil
+	}
+	return rule(value)
+}
+
+// checkSettings applies the rules to the effective settings, so impossible
+// values given as flags or environment variables are caught as well.
+func checkSettings(c *cli.Context) error {
+	for _, key := range []string{"quality", "frames", "width", "jobs", "segments", "max-frames", "ffmpeg-threads", "upload-parallelism"} {
+		if err := checkConfigValue(key, c.Int(key)); err != nil {
+			return fmt.Errorf("--%s %v", key, err)
+		}
+	}
+	for _, key := range []string{"log", "log-format", "log-to", "if-exists", "palette", "dither", "seek-mode", "max-tmp-size", "max-gif-size", "warn-gif-size", "tool-timeout", "upload-timeout", "wait-for-url", "limit-rate", "upload-part-size", "routes", "email-max-attachment", "share", "impersonate-service-account"} {
+		if err := checkConfigValue(key, c.String(key)); err != nil {
+			return fmt.Errorf("--%s %v", key, err)
+		}
+	}
+	return nil
+}
+
+// suggestKey returns a "did you mean" hint for a misspelled config key.
+func suggestKey(flags []cli.Flag, key string) string {
+	best, bestDist := "", 3
+	for _, known := range configKeys(flags) {
+		if d := editDistance(strings.ToLower(key), known); d < bestDist {
+			best, bestDist = known, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance is the levenshtein distance between a and b.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// appFlags returns the global flags of the app.  Subcommands run as their
+// own cli.App, so the flags are looked up on the root of the context lineage.
+func appFlags(c *cli.Context) []cli.Flag {
+	if app := rootApp(c); app != nil {
+		return app.Flags
+	}
+	return nil
+}
+
+// rootApp returns the top level app; subcommands run as apps of their own.
+func rootApp(c *cli.Context) *cli.App {
+	lineage := c.Lineage()
+	for i := len(lineage) - 1; i >= 0; i-- {
+		if lineage[i].App != nil {
+			return lineage[i].App
+		}
+	}
+	return nil
+}
+
+// configKind returns the kind of value a config key holds ("string", "int"
+// or "bool"), or "" when the key does not name a flag that can be set from a
+// config file.
+func configKind(flags []cli.Flag, key string) string {
+	for _, f := range flags {
+		if _, ok := f.(altsrc.FlagInputSourceExtension); !ok {
+			continue
+		}
+		for _, name := range f.Names() {
+			if name != key {
+				continue
+			}
+			switch f.(type) {
+			case *altsrc.StringFlag:
+				return "string"
+			case *altsrc.IntFlag:
+				return "int"
+			case *altsrc.BoolFlag:
+				return "bool"
+			}
+		}
+	}
+	return ""
+}
+
+// configKeys lists the keys that can be set from a config file.
+func configKeys(flags []cli.Flag) []string {
+	var keys []string
+	for _, f := range flags {
+		if _, ok := f.(altsrc.FlagInputSourceExtension); ok {
+			keys = append(keys, f.Names()[0])
+		}
+	}
+	return keys
+}
+
+// validateConfig checks that every key in a decoded config file names a
+// known setting and holds a sensible value of the right type.
+func validateConfig(fname string, values map[string]interface{}, flags []cli.Flag) []error {
+	var errs []error
+	m := &configMap{file: fname, values: values}
+
+	for _, key := range sortedKeys(values) {
+		if key == "profiles" {
+			profiles, ok := values[key].(map[string]interface{})
+			if !ok {
+				errs = append(errs, m.typeError(key, "a table of named profiles", values[key]))
+				continue
+			}
+			for _, name := range sortedKeys(profiles) {
+				settings, ok := profiles[name].(map[string]interface{})
+				if !ok {
+					errs = append(errs, m.typeError("profiles."+name, "a table of settings", profiles[name]))
+					continue
+				}
+				errs = append(errs, validateConfig(fname+" (profile "+name+")", settings, flags)...)
+			}
+			continue
+		}
+
+		var value interface{}
+		var err error
+		switch configKind(flags, key) {
+		case "string":
+			value, err = m.String(key)
+		case "int":
+			value, err = m.Int(key)
+		case "bool":
+			value, err = m.Bool(key)
+		default:
+			err = fmt.Errorf("%s: unknown key %q%s", fname, key, suggestKey(flags, key))
+		}
+		if err == nil {
+			if err = checkConfigValue(key, value); err != nil {
+				err = fmt.Errorf("%s: %q %v", fname, key, err)
+			}
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func sortedKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+// Set at build time, see the Makefile:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=abc1234 -X main.date=..."
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo fills in the commit and date from the module build info when
+// they were not given through ldflags, e.g. after `go install`.
+func buildInfo() (string, string) {
+	rev, when := commit, date
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return rev, when
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && rev == "":
+			rev = s.Value
+		case s.Key == "vcs.time" && when == "":
+			when = s.Value
+		}
+	}
+	return rev, when
+}
+
+// toolVersion returns the first line of a tool's version output, or why
+// there is none.
+func toolVersion(name string, arg ...string) string {
+	if _, err := exec.LookPath(name); err != nil {
+		return "not found"
+	}
+	out, err := exec.Command(name, arg...).CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+func printVersion(c *cli.Context) error {
+	rev, when := buildInfo()
+	info := map[string]string{
+		"version": version,
+		"commit":  rev,
+		"date":    when,
+		"go":      runtime.Version(),
+		"os/arch": runtime.GOOS + "/" + runtime.GOARCH,
+		"ffmpeg":  toolVersion("ffmpeg", "-version"),
+		"ffprobe": toolVersion("ffprobe", "-version"),
+		"gifski":  toolVersion("gifski", "--version"),
+		"gsutil":  toolVersion("gsutil", "version"),
+	}
+	if c.Bool("json") {
+		printJSON(info)
+		return nil
+	}
+
+	fmt.Printf("ggif %s\n", version)
+	for _, key := range []string{"commit", "date", "go", "os/arch", "ffmpeg", "ffprobe", "gifski", "gsutil"} {
+		if info[key] != "" {
+			fmt.Printf("  %-8s %s\n", key+":", info[key])
+		}
+	}
+	return nil
+}
+
+var versionCommand = &cli.Command{
+	Name:  "version",
+	Usage: "print the version, build info and the versions of the tools ggif runs",
+	Description: `Examples:
+      ggif version
+      ggif --json version`,
+	Action: printVersion,
+}
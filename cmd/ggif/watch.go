@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/urfave/cli/v2"
+)
+
+// settleDelay is how long a file in the watched folder has to go without
+// events before it is converted, so recordings that are still being written
+// or copied in are not picked up half-finished.
+const settleDelay = 2 * time.Second
+
+// settler debounces filesystem events per file and hands a file name to
+// ready once it has been quiet for settleDelay.
+type settler struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+	ready  chan string
+}
+
+func newSettler() *settler {
+	return &settler{
+		timers: make(map[string]*time.Timer),
+		ready:  make(chan string, 16),
+	}
+}
+
+func (s *settler) touch(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t, ok := s.timers[name]; ok {
+		t.Reset(settleDelay)
+		return
+	}
+	s.timers[name] = time.AfterFunc(settleDelay, func() {
+		s.mu.Lock()
+		delete(s.timers, name)
+		s.mu.Unlock()
+		s.ready <- name
+	})
+}
+
+// interesting reports whether a watcher event could mean a new file showed up
+// in the folder: created, written to, or renamed/moved in.
+func interesting(event fsnotify.Event) bool {
+	return event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0
+}
+
+// failedResult is the result of a video that never got to be converted.
+func failedResult(videoFile string, err error) *result {
+	return &result{
+		Source:    videoFile,
+		URLs:      []string{},
+		Durations: make(map[string]float64),
+		Error:     err.Error(),
+	}
+}
+
+// watchFolder converts every video that settles in the src folder through
+// q at low priority, handing each result to handle, until ctx is done.
+// handle is never called concurrently.  track, when not nil, is told the
+// stage of each video from "queued" on, from any goroutine.  Only one
+// watcher at a time, in any ggif, watches a folder.
+func watchFolder(ctx context.Context, c *cli.Context, q *jobQueue, track func(name string, stage string), handle func(res *result, err error)) error {
+	// two watchers would convert and upload every video twice
+	release, err := lockWatch(c.String("src"))
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	log.Debugf("Watching %s", c.String("src"))
+
+	s := newSettler()
+	var handleMu sync.Mutex
+	var wg sync.WaitGroup
+	// queue hands a video to q and its result to handle once converted
+	queue := func(name string) error {
+		started := false
+		j := &job{video: name, process: func(ctx context.Context, c *cli.Context, videoFile string, onStage func(stage string)) (*result, error) {
+			started = true
+			return processWithSidecar(ctx, c, videoFile, func(stage string) {
+				onStage(stage)
+				if track != nil {
+					track(name, stage)
+				}
+			})
+		}}
+		queued, err := q.add(ctx, j, "low")
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			state, _ := q.wait(context.Background(), queued.ID)
+			if !started {
+				// stopped while it waited
+				return
+			}
+			res := state.Result
+			if res == nil {
+				res = failedResult(name, state.err)
+			}
+			handleMu.Lock()
+			handle(res, state.err)
+			handleMu.Unlock()
+		}()
+		return nil
+	}
+
+	converted := make(chan bool)
+	go func() {
+		defer close(converted)
+		defer wg.Wait()
+		seen := make(map[string]time.Time)
+		for {
+			var name string
+			select {
+			case name = <-s.ready:
+			case <-ctx.Done():
+				return
+			}
+			// a sidecar dropped in or edited converts its recording
+			name = strings.TrimSuffix(name, sidecarSuffix)
+			fi, err := os.Stat(name)
+			if err != nil {
+				// renamed away or deleted before it settled
+				continue
+			}
+			if !isRecording(name) {
+				continue
+			}
+			modTime := sidecarModTime(name, fi.ModTime())
+			if seen[name].Equal(modTime) {
+				continue
+			}
+			log.Debug("new file:", name)
+			if track != nil {
+				track(name, "queued")
+			}
+			if err := queue(name); err != nil {
+				// the queue is full, try again once it settles anew
+				log.Warningf("%s: %v", name, err)
+				s.touch(name)
+				continue
+			}
+			seen[name] = modTime
+		}
+	}()
+
+	if err := watcher.Add(c.String("src")); err != nil {
+		return err
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			log.Debug("event:", event)
+			if interesting(event) {
+				s.touch(event.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Debug("error:", err)
+		case <-ctx.Done():
+			// conversions in progress are cancelled as well
+			<-converted
+			return nil
+		}
+	}
+}
+
+func watch(c *cli.Context) error {
+	if c.Bool("obs") {
+		if err := setupOBS(c); err != nil {
+			return exitError(exitUsage, err)
+		}
+	}
+	serveMonitoring(c)
+	if c.Bool("tui") {
+		return watchDashboard(c)
+	}
+	q := newJobQueue(c, c.Int("jobs"))
+	return watchFolder(c.Context, c, q, nil, func(res *result, err error) {
+		if err != nil && res.Job != "" {
+			log.Errorf("%v, see `ggif logs %s`", err, res.Job)
+		} else {
+			printError(err)
+		}
+		printResult(c, res)
+		if err == nil && !res.Skipped && c.String("archive") != "" {
+			printError(archiveSource(c, res.Source))
+		}
+	})
+}
+
+// watchDashboard is watch showing the conversions in a live table instead
+// of printing their results, with the logs below it.
+func watchDashboard(c *cli.Context) error {
+	if !isTerminal(os.Stderr) {
+		return exitError(exitUsage, fmt.Errorf("--tui needs a terminal"))
+	}
+	d := newDashboard(os.Stderr, c.String("src"))
+	logOutput = d
+	initLogging(c)
+	defer func() {
+		logOutput = os.Stderr
+		initLogging(c)
+	}()
+
+	ctx, cancel := context.WithCancel(c.Context)
+	drawn := make(chan bool)
+	go func() {
+		defer close(drawn)
+		d.run(ctx)
+	}()
+	q := newJobQueue(c, c.Int("jobs"))
+	err := watchFolder(c.Context, c, q, d.track, func(res *result, err error) {
+		d.finished(res, err)
+		if err == nil && !res.Skipped && c.String("archive") != "" {
+			printError(archiveSource(c, res.Source))
+		}
+	})
+	cancel()
+	<-drawn
+	return err
+}
+
+var watchCommand = &cli.Command{
+	Name:  "watch",
+	Usage: "convert and upload every new movie in the src folder",
+	Description: `Recordings wait in a queue of this process only, so a ggif convert
+   run meanwhile neither waits for them nor goes ahead of them.  For
+   one-offs to go ahead of a backlog of recordings, watch through ggif
+   grpc and send them as Convert calls.
+
+   Examples:
+      ggif --src ~/Desktop watch
+      ggif watch --clipboard
+      ggif watch --tui
+      ggif watch --obs`,
+	Before: sweepBefore,
+	Action: func(c *cli.Context) error {
+		if c.Bool("clipboard") {
+			if c.Bool("tui") {
+				return exitError(exitUsage, fmt.Errorf("--tui shows the conversions of a folder watch, not --clipboard"))
+			}
+			watchClipboard(c)
+			return nil
+		}
+		return watch(c)
+	},
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "clipboard",
+			Usage: "watch the clipboard for video file paths instead",
+		},
+		&cli.StringFlag{
+			Name:  "archive",
+			Usage: "move recordings into this folder, relative to the src folder, once converted",
+		},
+		&cli.BoolFlag{
+			Name:  "obs",
+			Usage: "watch the OBS recording folder with a preset for screen captures, archiving recordings in its ggif folder",
+		},
+		&cli.BoolFlag{
+			Name:  "tui",
+			Usage: "show queued, running and finished conversions in a live table, with their urls and errors",
+		},
+	},
+}
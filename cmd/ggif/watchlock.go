@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// lockWatch makes this process the only ggif watching src, failing when
+// another one already is.  The lock is held on a file in the data folder
+// until release is called or the process ends, however it ends.
+func lockWatch(src string) (release func(), err error) {
+	abs, err := filepath.Abs(src)
+	if err != nil {
+		return nil, err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	f, err := os.OpenFile(filepath.Join(dir, "watch-"+hex.EncodeToString(sum[:8])+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		// windows won't let the pid be read while the file is locked
+		data, _ := ioutil.ReadAll(f)
+		f.Close()
+		if pid := strings.TrimSpace(string(data)); pid != "" {
+			return nil, fmt.Errorf("ggif (pid %s) is already watching %s", pid, abs)
+		}
+		return nil, fmt.Errorf("another ggif is already watching %s", abs)
+	}
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() { f.Close() }, nil
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	// claimWait is how long POST /work waits for a job before answering
+	// 204 No Content.
+	claimWait = 30 * time.Second
+	// workLease is how long a worker has to report a claimed job back
+	// before it is failed.
+	workLease = time.Hour
+	// workRetry is how long a worker waits after failing to reach the
+	// server.
+	workRetry = 5 * time.Second
+)
+
+// workItem is a claimed job as handed to a worker.
+type workItem struct {
+	ID string `json:"id"`
+	// Name is the video's file name, for its extension.
+	Name string `json:"name"`
+}
+
+// workReport is what a worker sends back once a job is converted.
+type workReport struct {
+	Result *result `json:"result"`
+	Error  string  `json:"error,omitempty"`
+	Code   int     `json:"code,omitempty"`
+}
+
+// claim hands the next pending job to a remote worker, waiting until one
+// is queued or done is closed.
+func (q *jobQueue) claim(worker string, done <-chan struct{}) (*job, bool) {
+	for {
+		j, ok := q.next(done)
+		if !ok {
+			return nil, false
+		}
+		if !q.begin(j, worker) {
+			continue
+		}
+		lease := time.AfterFunc(workLease, func() {
+			q.finish(j, nil, fmt.Errorf("worker %s did not report back within %s", worker, workLease))
+		})
+		q.mu.Lock()
+		if j.finished() {
+			lease.Stop()
+		} else {
+			j.lease = lease
+		}
+		q.mu.Unlock()
+		return j, true
+	}
+}
+
+// workHandler serves the endpoints ggif worker pulls jobs from:
+// POST /work to claim one, GET /work/{id}/video for its video, and
+// POST /work/{id}/stage and POST /work/{id}/result to report on it.
+func workHandler(q *jobQueue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/work"), "/")
+		if rest == "" && r.Method == http.MethodPost {
+			claimWork(q, w, r)
+			return
+		}
+
+		parts := strings.Split(rest, "/")
+		if len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		j, ok := q.lookup(parts[0])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", parts[0]))
+			return
+		}
+		// only the worker that claimed a job gets its video or reports on it
+		if state, _ := q.get(j.ID); state.Worker != workerName(r) {
+			writeError(w, http.StatusConflict, fmt.Errorf("job %s is not claimed by %s", j.ID, workerName(r)))
+			return
+		}
+		switch {
+		case parts[1] == "video" && r.Method == http.MethodGet:
+			http.ServeFile(w, r, j.video)
+		case parts[1] == "stage" && r.Method == http.MethodPost:
+			var req struct {
+				Stage string `json:"stage"`
+			}
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if !q.setStage(j, req.Stage) {
+				// tells the worker to stop
+				writeError(w, http.StatusGone, fmt.Errorf("job %s is %s", j.ID, j.Status))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case parts[1] == "result" && r.Method == http.MethodPost:
+			var report workReport
+			if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&report); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			var err error
+			if report.Error != "" {
+				err = exitError(report.Code, errors.New(report.Error))
+			} else {
+				markSuccess()
+			}
+			log.Infof("%s: job %s finished on %s", r.RemoteAddr, j.ID, j.Worker)
+			q.finish(j, report.Result, err)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("GET /work/{id}/video or POST /work/{id}/stage|result"))
+		}
+	}
+}
+
+// workerName names the worker making a request: the name it sends, or
+// else its address.
+func workerName(r *http.Request) string {
+	if name := r.Header.Get("X-Ggif-Worker"); name != "" {
+		return name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func claimWork(q *jobQueue, w http.ResponseWriter, r *http.Request) {
+	worker := workerName(r)
+	ctx, cancel := context.WithTimeout(r.Context(), claimWait)
+	defer cancel()
+
+	j, ok := q.claim(worker, ctx.Done())
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Context().Err() != nil {
+		// the worker hung up as the job came in
+		log.Infof("%s: gone before taking job %s, queueing it again", worker, j.ID)
+		q.requeue(j)
+		return
+	}
+	log.Infof("%s: job %s claimed by %s", r.RemoteAddr, j.ID, worker)
+	writeJSON(w, http.StatusOK, workItem{ID: j.ID, Name: filepath.Base(j.video)})
+}
+
+// workClient talks to the server for ggif worker.  The server bounds
+// claims, and videos can take a while to download.
+type workClient struct {
+	server string
+	token  string
+	name   string
+	http   *http.Client
+}
+
+// statusError is a response from the server that was not a success.
+type statusError struct {
+	method string
+	path   string
+	status int
+	msg    string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.method, e.path, e.status, http.StatusText(e.status), e.msg)
+}
+
+func (wc *workClient) do(ctx context.Context, method string, path string, body interface{}) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, wc.server+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if wc.token != "" {
+		req.Header.Set("Authorization", "Bearer "+wc.token)
+	}
+	req.Header.Set("X-Ggif-Worker", wc.name)
+	resp, err := wc.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, &statusError{method, path, resp.StatusCode, strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// claim asks the server for a job, returning nil when none came up.
+func (wc *workClient) claim(ctx context.Context) (*workItem, error) {
+	resp, err := wc.do(ctx, http.MethodPost, "/work", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	item := &workItem{}
+	if err := json.NewDecoder(resp.Body).Decode(item); err != nil {
+		return nil, err
+	}
+	if !validJobID(item.ID, false) {
+		// it goes into urls and the name of the job's log
+		return nil, fmt.Errorf("the server sent a bad job id %q", item.ID)
+	}
+	return item, nil
+}
+
+// run converts a claimed job and reports the result to the server.  The
+// conversion stops when ctx is done or the server says the job was
+// cancelled.
+func (wc *workClient) run(ctx context.Context, c *cli.Context, item *workItem) error {
+	dir, err := makeTempDir(tempDir(c), "worker")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := workReport{}
+	resp, err := wc.do(jobCtx, http.MethodGet, "/work/"+item.ID+"/video", nil)
+	if err == nil {
+		var videoFile string
+		videoFile, err = saveVideo(dir, item.Name, resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			logCtx, jl := startJobLog(jobCtx, item.ID, videoFile)
+			report.Result, err = processWith(logCtx, c, videoFile, func(stage string) {
+				jl.stage(stage)
+				resp, err := wc.do(jobCtx, http.MethodPost, "/work/"+item.ID+"/stage", map[string]string{"stage": stage})
+				var status *statusError
+				if errors.As(err, &status) && status.status == http.StatusGone {
+					log.Warningf("job %s was cancelled", item.ID)
+					cancel()
+				} else if err == nil {
+					resp.Body.Close()
+				}
+			})
+			jl.finish(report.Result, err)
+		}
+	}
+	if jobCtx.Err() != nil && ctx.Err() == nil {
+		// cancelled on the server, which already failed the job
+		return nil
+	}
+	if err != nil {
+		log.Errorf("job %s: %v", item.ID, err)
+		report.Error = err.Error()
+		report.Code = exitCode(err)
+	}
+
+	// report even when shutting down, so the job does not wait out its lease
+	resp, err = wc.do(context.Background(), http.MethodPost, "/work/"+item.ID+"/result", report)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func runWorker(c *cli.Context) error {
+	server := strings.TrimSuffix(c.String("server"), "/")
+	if server == "" {
+		return exitError(exitUsage, fmt.Errorf("usage: ggif worker --server <url>"))
+	}
+	if c.Int("parallel") < 1 {
+		return exitError(exitUsage, fmt.Errorf("--parallel must be at least 1"))
+	}
+	if err := serverMode(c); err != nil {
+		return err
+	}
+	serveMonitoring(c)
+
+	name := c.String("name")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	wc := &workClient{
+		server: server,
+		token:  c.String("token"),
+		name:   name,
+		http:   &http.Client{},
+	}
+	log.Noticef("Working for %s as %s", server, name)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.Int("parallel"); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c.Context.Err() == nil {
+				item, err := wc.claim(c.Context)
+				if c.Context.Err() != nil {
+					return
+				}
+				if err != nil {
+					log.Warningf("Could not claim a job: %v", err)
+					select {
+					case <-time.After(workRetry):
+					case <-c.Context.Done():
+					}
+					continue
+				}
+				if item == nil {
+					continue
+				}
+				log.Infof("Converting job %s", item.ID)
+				if err := wc.run(c.Context, c, item); err != nil {
+					log.Errorf("job %s: %v", item.ID, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	log.Noticef("Stopped working for %s", server)
+	return nil
+}
+
+var workerCommand = &cli.Command{
+	Name:  "worker",
+	Usage: "convert jobs queued on a ggif serve somewhere else",
+	Description: `Pulls jobs from a ggif serve, downloads their videos, converts them with
+   this machine's ffmpeg and gifski, uploads them to this machine's bucket
+   and reports the results back.  Run the server with --workers 0 to leave
+   every conversion to its workers.
+
+   Examples:
+      ggif serve --addr :8080 --token s3cret --workers 0
+      ggif --bucket my-bucket worker --server http://laptop:8080 --token s3cret`,
+	Before: sweepBefore,
+	Action: runWorker,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "server",
+			EnvVars: []string{"GGIF_SERVER"},
+			Usage:   "url of the ggif serve to pull jobs from",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			EnvVars: []string{"GGIF_TOKEN"},
+			Usage:   "bearer token the server requires",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "name to report to the server, the host name by default",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 1,
+			Usage: "jobs to convert at once",
+		},
+	},
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+// zulipClient calls the api of the Zulip organization in the zulip-site
+// setting as the bot in zulip-email.
+type zulipClient struct {
+	site   string
+	email  string
+	apiKey string
+	http   *http.Client
+}
+
+// call sends a request to the api endpoint and decodes the json answer into
+// out, turning the errors Zulip reports into Go ones.
+func (z *zulipClient) call(endpoint string, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, z.site+"/api/v1/"+endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(z.email, z.apiKey)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := z.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var answer struct {
+		Result string `json:"result"`
+		Msg    string `json:"msg"`
+	}
+	if err := json.Unmarshal(data, &answer); err != nil || answer.Result != "success" {
+		if answer.Msg != "" {
+			return fmt.Errorf("zulip: %s", answer.Msg)
+		}
+		return fmt.Errorf("zulip: %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// upload stores fname on the Zulip server and returns the path it is
+// linked under in messages.
+func (z *zulipClient) upload(fname string) (string, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("filename", filepath.Base(fname))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	var uploaded struct {
+		// URI is the name of servers before Zulip 9
+		URI string `json:"uri"`
+		URL string `json:"url"`
+	}
+	if err := z.call("user_uploads", w.FormDataContentType(), &body, &uploaded); err != nil {
+		return "", err
+	}
+	if uploaded.URL != "" {
+		return uploaded.URL, nil
+	}
+	return uploaded.URI, nil
+}
+
+// shareZulip posts the gif to the zulip-stream stream under zulip-topic,
+// uploading it to Zulip so it shows inline, or linking its url when it is
+// no longer on disk.
+func shareZulip(c *cli.Context, e *historyEntry) (string, error) {
+	apiKey, err := secretSetting(c, "zulip-api-key")
+	if err != nil {
+		return "", err
+	}
+	z := &zulipClient{
+		site:   strings.TrimSuffix(c.String("zulip-site"), "/"),
+		email:  c.String("zulip-email"),
+		apiKey: apiKey,
+		http:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	if z.site == "" || z.email == "" || z.apiKey == "" {
+		return "", fmt.Errorf("zulip isn't configured, set zulip-site and zulip-email from the bot's zuliprc, and $GGIF_ZULIP_API_KEY or zulip-api-key to a keyring: or exec: reference to its key")
+	}
+	stream := c.String("zulip-stream")
+	if stream == "" {
+		return "", fmt.Errorf("no zulip stream configured, set one with `ggif config set zulip-stream <name>`")
+	}
+	local := fileExists(e.Output)
+	if !local && len(e.URLs) == 0 {
+		return "", fmt.Errorf("%s no longer exists and was never uploaded", e.Output)
+	}
+	if c.Bool("dry-run") {
+		if local {
+			fmt.Fprintf(os.Stderr, "POST %s/api/v1/user_uploads %s\n", z.site, e.Output)
+		}
+		fmt.Fprintf(os.Stderr, "POST %s/api/v1/messages #%s > %s\n", z.site, stream, c.String("zulip-topic"))
+		return z.site, nil
+	}
+
+	link := ""
+	if len(e.URLs) > 0 {
+		link = e.URLs[0]
+	}
+	if local {
+		path, err := z.upload(e.Output)
+		if err != nil {
+			return "", err
+		}
+		link = path
+	}
+	form := url.Values{
+		"type":    {"stream"},
+		"to":      {stream},
+		"topic":   {c.String("zulip-topic")},
+		"content": {fmt.Sprintf("[%s](%s)", filepath.Base(e.Source), link)},
+	}
+	var sent struct {
+		ID int `json:"id"`
+	}
+	if err := z.call("messages", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), &sent); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/#narrow/near/%d", z.site, sent.ID), nil
+}